@@ -1,77 +1,78 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"net/http"
+	"time"
 
 	"github.com/sashabaranov/go-openai"
 )
 
-type API struct {
-	PineconeAPIURL string
-	PineconeAPIKey string
-	OpenAIKey      string
-	Messages       []openai.ChatCompletionMessage
-}
-
+// ConversationRequest asks a question in a conversation. A conversation
+// stored server-side is continued by its sessionId; otherwise the client
+// sends the previous messages.
 type ConversationRequest struct {
 	UserMessage string                         `json:"userMessage"`
-	Continued   bool                           `json:"continued"`
+	SessionID   string                         `json:"sessionId,omitempty"`
 	Messages    []openai.ChatCompletionMessage `json:"messages,omitempty"`
+	RetrievalOptions
 }
 
-type ConversationResponse struct {
-	BotMessage string                         `json:"botMessage"`
-	Messages   []openai.ChatCompletionMessage `json:"messages"`
+// ConversationResult is the answer to a user message together with the
+// structured data it was based on. RewrittenQuery is the standalone query
+// a follow-up question was searched with, if it was rewritten. Retrieval is
+// only set for requests asking for debug output.
+type ConversationResult struct {
+	Response       string
+	RewrittenQuery string
+	Owners         []DeclaredOwner
+	Experts        []Expert
+	Retrieval      *RetrievalDebug
 }
 
-func (api *API) HandleConversation(w http.ResponseWriter, r *http.Request) {
-	var req ConversationRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-
-	// Instantiate openaiClient and pineconeClient
-	openaiClient := NewOpenAIClient(api.OpenAIKey)
-	pineconeClient := NewPineconeClient(api.PineconeAPIURL, api.PineconeAPIKey)
-
-	// If it's a continued conversation, update the messages
-	if req.Continued {
-		api.Messages = req.Messages
-	}
+// conversationPrompt is a conversation ready to be completed: the messages
+// for the chat model and the memory and owners retrieved for them.
+type conversationPrompt struct {
+	messages       []openai.ChatCompletionMessage
+	memory         string
+	matches        []Match
+	owners         []DeclaredOwner
+	experts        []Expert
+	retrieval      *RetrievalDebug
+	rewrittenQuery string
+}
 
-	botMessage, err := ProcessConversation(openaiClient, pineconeClient, req.UserMessage, api.Messages)
+func ProcessConversation(ctx context.Context, openaiClient *OpenAIClient, pineconeClient *PineconeClient, userMessage string, messagesIn []openai.ChatCompletionMessage, retrieval RetrievalOptions) (*ConversationResult, error) {
+	prompt, err := prepareConversation(ctx, openaiClient, pineconeClient, userMessage, messagesIn, retrieval)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	if req.Continued {
-		api.Messages = append(api.Messages, openai.ChatCompletionMessage{
-			Role:    "user",
-			Content: req.UserMessage,
-		})
-		api.Messages = append(api.Messages, openai.ChatCompletionMessage{
-			Role:    "assistant",
-			Content: botMessage,
-		})
+		return nil, err
 	}
 
-	resp := ConversationResponse{
-		BotMessage: botMessage,
-		Messages:   api.Messages,
+	start := time.Now()
+	resp, err := openaiClient.CreateChatCompletion(ctx, prompt.messages)
+	observeStage("complete", start)
+	if err != nil {
+		return nil, fmt.Errorf("Error while fetching chat completion: %v", err)
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
+	tokensTotal.WithLabelValues("prompt").Add(float64(resp.Usage.PromptTokens))
+	tokensTotal.WithLabelValues("completion").Add(float64(resp.Usage.CompletionTokens))
+
+	gptResponse := redactUnknownEmails(resp.Choices[0].Message.Content, prompt.memory)
+
+	return &ConversationResult{
+		Response:       gptResponse,
+		Owners:         prompt.owners,
+		Experts:        prompt.experts,
+		Retrieval:      prompt.retrieval,
+		RewrittenQuery: prompt.rewrittenQuery,
+	}, nil
 }
 
-func ProcessConversation(openaiClient *OpenAIClient, pineconeClient *PineconeClient, userMessage string, messagesIn []openai.ChatCompletionMessage) (string, error) {
+// prepareConversation retrieves the memory for a user message and builds the
+// messages to complete.
+func prepareConversation(ctx context.Context, openaiClient *OpenAIClient, pineconeClient *PineconeClient, userMessage string, messagesIn []openai.ChatCompletionMessage, retrieval RetrievalOptions) (*conversationPrompt, error) {
 	messages := []openai.ChatCompletionMessage{}
-	prePrompt := "Always start a sentence with 'I would recommend to'  You are Q&A bot. You must always elobrate / explain your memory in great details (in your own words!), you will find it above the question 🕵️. You are a highly intelligent system that locates people (authors) that could best help regarding a certain topic or question using your memory 🔎. Your personal memory is provided provided above each question. If the answer can not be found in the your personal memory you truthfully say \"I don't know\". Don't answer any other questions. The author may use a username. An author is provided (above the question) with the following format: # 1. <AuthorName>. Don't reference any other people or information that is not mentioned above the question. Always share the email address (if available) in this format: [foobar@example.com] (foobar@example.com). Please always link the to relevant commit (e.g. [https://github.com/aymenfurter/x/commit/64e49e60dc41ecd1d6c5a5aebdc5b66e2275c41f](https://github.com/aymenfurter/x/commit/64e49e60dc41ecd1d6c5a5aebdc5b66e2275c41f)). If you mention an author, always the syntax [user](user@example.com) \n Do you understand? "
+	prePrompt := "Always start a sentence with 'I would recommend to'  You are Q&A bot. You must always elobrate / explain your memory in great details (in your own words!), you will find it above the question 🕵️. You are a highly intelligent system that locates people (authors) that could best help regarding a certain topic or question using your memory 🔎. Your personal memory is provided provided above each question. If the answer can not be found in the your personal memory you truthfully say \"I don't know\". Don't answer any other questions. The author may use a username. An author is provided (above the question) with the following format: # 1. <AuthorName>. Don't reference any other people or information that is not mentioned above the question. Always share the email address (if available) in this format: [foobar@example.com] (foobar@example.com). Only share contact details exactly as they appear in your memory, never make up email addresses. If the email is withheld or a handle is given instead, share only the name or the handle. Please always link the relevant commit or document with the Link given in your memory (e.g. [https://github.com/aymenfurter/x/commit/64e49e60dc41ecd1d6c5a5aebdc5b66e2275c41f](https://github.com/aymenfurter/x/commit/64e49e60dc41ecd1d6c5a5aebdc5b66e2275c41f)). Never build a link yourself; if your memory gives no Link, don't link. If you mention an author, always the syntax [user](user@example.com) Declared owners from CODEOWNERS files may be listed below your memory: mention them as \"declared owner\" and the authors from your memory as \"history-based expert\", and never mix the two up. \n Do you understand? "
 	messages = append(messages, openai.ChatCompletionMessage{
 		Role:    "system",
 		Content: prePrompt,
@@ -82,7 +83,7 @@ func ProcessConversation(openaiClient *OpenAIClient, pineconeClient *PineconeCli
 	})
 	messages = append(messages, openai.ChatCompletionMessage{
 		Role:    "user",
-		Content: "Author: Sam Alias Jones \nRepoURL: https://github.com/MicrosoftDocs/azure-docs.git \nCommit-Message: Uploaded file articles/virtual-machines-sharepoint-farm-azure-preview.md Email: sam.alias.jones@microsoft.com CommitId: 04b6e1dcac6376ddc7bb05f892f4d1b6028e224b Diff: diff --git a/articles/virtual-machines-sharepoint-farm-azure-preview.md b/articles/virtual-machines-sharepoint-farm-azure-preview.md index a/articles/virtual-machines-sharepoint-farm-azure-preview.md +++ b/articles/virtual-machines-sharepoint-farm-azure-preview.md @@ -1,6 +1,23 @@ +<properties + pageTitle=SharePoint Server Farm Describes the new SharePoint Server Farm feature available in the Azure Preview Portal servicesdocumentationCenter= With SharePoint Server Farm, the Microsoft Azure Preview Portal automatically creates a pre-configured SharePoint Server 2013\nAuthor: Jane Doe Smith \nRepoURL: https://github.com/MicrosoftDocs/azure-docs.git \nCommit-Message: Added high availability guide to articles/virtual-machines-sharepoint-farm-azure-preview.md Email: jane.doe.smith@microsoft.com CommitId: f7d42ec13b8465dac3b97c547293db7c62a9d91c Diff: diff --git a/articles/virtual-machines-sharepoint-farm-azure-preview.md b/articles/virtual-machines-sharepoint-farm-azure-preview.md index a/articles/virtual-machines-sharepoint-farm-azure-preview.md +++ b/articles/virtual-machines-sharepoint-farm-azure-preview.md @@ -2,12 +2,35 @@ +<properties + pageTitle=SharePoint Server Farm High Availability Guide Provides a step-by-step guide on configuring high availability for SharePoint Server Farm in Azure Preview Portal servicesdocumentationCenter= This guide focuses on achieving high availability and fault tolerance for your SharePoint Server Farm in the Azure Preview Portal\n\n	\n	Who can help me with with high availability and learning what server farm features are available with Sharepoint Server?",
+		Content: "Author: Sam Alias Jones \nRepoURL: https://github.com/MicrosoftDocs/azure-docs.git \nCommit-Message: Uploaded file articles/virtual-machines-sharepoint-farm-azure-preview.md Email: sam.alias.jones@microsoft.com CommitId: 04b6e1dcac6376ddc7bb05f892f4d1b6028e224b Link: https://github.com/MicrosoftDocs/azure-docs/commit/04b6e1dcac6376ddc7bb05f892f4d1b6028e224b Diff: diff --git a/articles/virtual-machines-sharepoint-farm-azure-preview.md b/articles/virtual-machines-sharepoint-farm-azure-preview.md index a/articles/virtual-machines-sharepoint-farm-azure-preview.md +++ b/articles/virtual-machines-sharepoint-farm-azure-preview.md @@ -1,6 +1,23 @@ +<properties + pageTitle=SharePoint Server Farm Describes the new SharePoint Server Farm feature available in the Azure Preview Portal servicesdocumentationCenter= With SharePoint Server Farm, the Microsoft Azure Preview Portal automatically creates a pre-configured SharePoint Server 2013\nAuthor: Jane Doe Smith \nRepoURL: https://github.com/MicrosoftDocs/azure-docs.git \nCommit-Message: Added high availability guide to articles/virtual-machines-sharepoint-farm-azure-preview.md Email: jane.doe.smith@microsoft.com CommitId: f7d42ec13b8465dac3b97c547293db7c62a9d91c Link: https://github.com/MicrosoftDocs/azure-docs/commit/f7d42ec13b8465dac3b97c547293db7c62a9d91c Diff: diff --git a/articles/virtual-machines-sharepoint-farm-azure-preview.md b/articles/virtual-machines-sharepoint-farm-azure-preview.md index a/articles/virtual-machines-sharepoint-farm-azure-preview.md +++ b/articles/virtual-machines-sharepoint-farm-azure-preview.md @@ -2,12 +2,35 @@ +<properties + pageTitle=SharePoint Server Farm High Availability Guide Provides a step-by-step guide on configuring high availability for SharePoint Server Farm in Azure Preview Portal servicesdocumentationCenter= This guide focuses on achieving high availability and fault tolerance for your SharePoint Server Farm in the Azure Preview Portal\n\n	\n	Who can help me with with high availability and learning what server farm features are available with Sharepoint Server?",
 	})
 	messages = append(messages, openai.ChatCompletionMessage{
 		Role:    "assistant",
@@ -102,17 +103,34 @@ func ProcessConversation(openaiClient *OpenAIClient, pineconeClient *PineconeCli
 	}
 	embeddingMsgContext += userMessage
 
-	embeddingReq := createEmbeddingRequest(embeddingMsgContext)
-	response, err := openaiClient.RequestEmbeddings(embeddingReq)
-	if err != nil {
-		return "", fmt.Errorf("Error generating embeddings: %v", err)
+	// A follow-up is searched with a standalone query if it can be rewritten,
+	// else with the whole conversation.
+	question := userMessage
+	rewrittenQuery := rewriteQuery(ctx, openaiClient, messagesIn, userMessage)
+	if rewrittenQuery != "" {
+		embeddingMsgContext = rewrittenQuery
+		question = rewrittenQuery
 	}
-	pcVector := transformToPineconeVectors(response.Data)
 
-	pineconeResult, err := pineconeClient.QueryPinecone(pcVector)
+	candidates := candidateCount()
+	matches, err := retrieveMatches(ctx, openaiClient, pineconeClient, embeddingMsgContext, retrieval.pineconeFilter(), candidates)
 	if err != nil {
-		return "", fmt.Errorf("Error while querying Pinecone: %v", err)
+		return nil, err
 	}
+	matches, debug := hybridMatches(ctx, pineconeClient, matches, question, retrieval, candidates)
+	if reranker := newReranker(openaiClient); reranker != nil {
+		matches = rerankMatches(ctx, reranker, question, matches)
+		if debug != nil {
+			debug.Reranked = rankedMatches(matches)
+		}
+	}
+	matches = rankMatches(matches, question, retrieval.RetrievalFilter)
+	if err := resolvePeople(ctx, matches); err != nil {
+		loggerFrom(ctx).Warnw("Failed to resolve people of matches", "error", err)
+	}
+
+	owners := declaredOwners(matches, userMessage)
+	pineconeResult := formatMemory(matches) + formatDeclaredOwners(owners)
 
 	for _, message := range messagesIn {
 		messages = append(messages, openai.ChatCompletionMessage{
@@ -125,14 +143,44 @@ func ProcessConversation(openaiClient *OpenAIClient, pineconeClient *PineconeCli
 		Role:    "user",
 		Content: "\n\n-----BEGIN YOUR PERSONAL BOT MEMORY -----n\n" + pineconeResult + "\n\n-----END YOUR PERSONAL BOT MEMORY -----n\n" + userMessage,
 	})
-	fmt.Println("Messages: ", messages)
+	loggerFrom(ctx).Debugw("Requesting chat completion", "messages", messages)
+
+	return &conversationPrompt{
+		messages:       messages,
+		memory:         pineconeResult,
+		matches:        matches,
+		owners:         owners,
+		experts:        rankExperts(matches),
+		retrieval:      debug,
+		rewrittenQuery: rewrittenQuery,
+	}, nil
+}
 
-	resp, err := openaiClient.CreateChatCompletion(messages)
+// retrieveMatches embeds a text with the model of the active generation and
+// returns the topK vectors closest to it.
+func retrieveMatches(ctx context.Context, openaiClient *OpenAIClient, pineconeClient *PineconeClient, text string, filter map[string]interface{}, topK int) ([]Match, error) {
+	generation := generations.active()
+	model, err := generation.embeddingModel()
 	if err != nil {
-		return "", fmt.Errorf("Error while fetching chat completion: %v", err)
+		return nil, err
 	}
 
-	gptResponse := resp.Choices[0].Message.Content
+	embeddingReq := createEmbeddingRequest(model, text)
+	start := time.Now()
+	response, err := openaiClient.RequestEmbeddings(ctx, embeddingReq)
+	observeStage("embed", start)
+	if err != nil {
+		return nil, fmt.Errorf("Error generating embeddings: %v", err)
+	}
+	tokensTotal.WithLabelValues("embedding").Add(float64(response.Usage.TotalTokens))
+	pcVector := transformToPineconeVectors(response.Data)
 
-	return gptResponse, nil
+	start = time.Now()
+	matches, err := pineconeClient.QueryPinecone(ctx, generation, pcVector, filter, topK)
+	observeStage("retrieve", start)
+	if err != nil {
+		return nil, fmt.Errorf("Error while querying Pinecone: %v", err)
+	}
+	observeRetrieval(matches)
+	return matches, nil
 }
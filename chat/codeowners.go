@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const maxDeclaredOwners = 10
+
+// CodeOwners are the rules of a CODEOWNERS file, stored by the indexer per branch.
+type CodeOwners struct {
+	Path  string          `bson:"path"`
+	Rules []CodeOwnerRule `bson:"rules"`
+
+	// patterns are the compiled patterns of the rules, nil for invalid ones.
+	patterns []*regexp.Regexp
+}
+
+// CodeOwnerRule assigns owners to the paths matching a pattern.
+type CodeOwnerRule struct {
+	Pattern string   `bson:"pattern"`
+	Owners  []string `bson:"owners"`
+}
+
+// DeclaredOwner is an owner declared in CODEOWNERS for a path related to the question.
+type DeclaredOwner struct {
+	RepoURL string   `json:"repoUrl"`
+	Path    string   `json:"path"`
+	Pattern string   `json:"pattern"`
+	Owners  []string `json:"owners"`
+	Source  string   `json:"source"`
+}
+
+var questionPathRE = regexp.MustCompile(`[\w.\-]+(?:/[\w.\-*]+)+/?`)
+
+// declaredOwners looks up the CODEOWNERS entries for the files of the
+// retrieved commits and for paths mentioned in the question.
+func declaredOwners(matches []Match, question string) []DeclaredOwner {
+	owners := []DeclaredOwner{}
+	seen := map[string]bool{}
+
+	add := func(repo RepositorySettings, path, lookup string) {
+		rules := defaultBranchCodeOwners(repo)
+		if rules == nil || len(owners) >= maxDeclaredOwners {
+			return
+		}
+
+		rule, ok := rules.owners(lookup)
+		if !ok || len(rule.Owners) == 0 {
+			return
+		}
+
+		key := repo.URL + "\x00" + rule.Pattern
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+
+		owners = append(owners, DeclaredOwner{
+			RepoURL: repo.URL,
+			Path:    path,
+			Pattern: rule.Pattern,
+			Owners:  rule.Owners,
+			Source:  "CODEOWNERS",
+		})
+	}
+
+	for _, path := range questionPathRE.FindAllString(question, -1) {
+		// A path in a question may name a directory, so it is looked up as
+		// one to also match directory-only patterns.
+		path = strings.TrimSuffix(strings.TrimPrefix(path, "./"), "/")
+		for _, repo := range repositories.all() {
+			add(repo, path, path+"/")
+		}
+	}
+
+	for _, match := range matches {
+		repo, ok := repositories.lookup(match.RepoURL)
+		if !ok {
+			continue
+		}
+		for _, file := range match.Files {
+			add(repo, file, file)
+		}
+	}
+
+	return owners
+}
+
+func defaultBranchCodeOwners(repo RepositorySettings) *CodeOwners {
+	for _, branch := range []string{"main", "master"} {
+		if owners, ok := repo.CodeOwners[branch]; ok {
+			return &owners
+		}
+	}
+	for _, owners := range repo.CodeOwners {
+		return &owners
+	}
+	return nil
+}
+
+// compile compiles the patterns of the rules once, when the file is loaded.
+func (c *CodeOwners) compile() {
+	c.patterns = make([]*regexp.Regexp, len(c.Rules))
+	for i, rule := range c.Rules {
+		c.patterns[i], _ = regexp.Compile(codeOwnersPatternRegexp(rule.Pattern))
+	}
+}
+
+// owners returns the rule that applies to a path. As in GitHub and GitLab,
+// the last matching rule takes precedence. A path ending in a slash may name
+// a file or a directory.
+func (c *CodeOwners) owners(path string) (CodeOwnerRule, bool) {
+	if len(c.patterns) != len(c.Rules) {
+		c.compile()
+	}
+
+	path = strings.TrimPrefix(path, "/")
+	file := strings.TrimSuffix(path, "/")
+	for i := len(c.Rules) - 1; i >= 0; i-- {
+		re := c.patterns[i]
+		if re != nil && (re.MatchString(path) || re.MatchString(file)) {
+			return c.Rules[i], true
+		}
+	}
+	return CodeOwnerRule{}, false
+}
+
+// codeOwnersPatternRegexp implements the gitignore style patterns of
+// CODEOWNERS files. A pattern matching a directory matches everything below
+// it; a pattern whose last segment has wildcards only matches that level.
+func codeOwnersPatternRegexp(pattern string) string {
+	directoryOnly := strings.HasSuffix(pattern, "/")
+	pattern = strings.TrimSuffix(pattern, "/")
+	anchored := strings.HasPrefix(pattern, "/") || strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	var expr strings.Builder
+	if anchored {
+		expr.WriteString("^")
+	} else {
+		expr.WriteString("^(?:.*/)?")
+	}
+
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			expr.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case pattern[i] == '*':
+			expr.WriteString("[^/]*")
+		case pattern[i] == '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+
+	last := pattern[strings.LastIndex(pattern, "/")+1:]
+	switch {
+	case directoryOnly:
+		expr.WriteString("/.*$")
+	case strings.ContainsAny(last, "*?"):
+		expr.WriteString("$")
+	default:
+		expr.WriteString("(?:/.*)?$")
+	}
+	return expr.String()
+}
+
+// formatDeclaredOwners renders declared owners for the prompt, labelled so
+// the model can tell them apart from history-based experts.
+func formatDeclaredOwners(owners []DeclaredOwner) string {
+	if len(owners) == 0 {
+		return ""
+	}
+
+	output := "\n\n-----BEGIN DECLARED OWNERS (CODEOWNERS) -----\n"
+	for _, owner := range owners {
+		output += fmt.Sprintf("- %s in %s (pattern %s): %s\n", owner.Path, owner.RepoURL, owner.Pattern, strings.Join(owner.Owners, ", "))
+	}
+	return output + "-----END DECLARED OWNERS -----\n"
+}
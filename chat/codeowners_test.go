@@ -0,0 +1,98 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodeOwnersPatternMatches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		matches bool
+	}{
+		{"*", "articles/aks/intro.md", true},
+		{"*.md", "articles/aks/intro.md", true},
+		{"*.md", "articles/aks/intro.yml", false},
+		{"/articles/aks/", "articles/aks/intro.md", true},
+		{"/articles/aks/", "docs/articles/aks/intro.md", false},
+		{"articles/aks", "articles/aks/networking/cni.md", true},
+		{"docs/", "src/docs/readme.md", true},
+		{"articles/*.md", "articles/intro.md", true},
+		{"articles/*.md", "articles/aks/intro.md", false},
+		{"articles/**/networking.md", "articles/aks/networking.md", true},
+		{"articles/**/networking.md", "articles/networking.md", true},
+		{"/build/logs/", "build/logs", false},
+		{"docs/*", "docs/getting-started.md", true},
+		{"docs/*", "docs/build-app/troubleshooting.md", false},
+		{"/src/*.go", "src/main.go", true},
+		{"/src/*.go", "src/x.go/main.c", false},
+		{"apps/**", "apps/web/src/index.ts", true},
+	}
+
+	for _, test := range tests {
+		re := regexp.MustCompile(codeOwnersPatternRegexp(test.pattern))
+		assert.Equal(t, test.matches, re.MatchString(test.path), "%s on %s", test.pattern, test.path)
+	}
+}
+
+func TestDeclaredOwnersUsesLastMatchingRule(t *testing.T) {
+	repositories.byURL = map[string]RepositorySettings{
+		"https://github.com/MicrosoftDocs/azure-docs.git": {
+			URL: "https://github.com/MicrosoftDocs/azure-docs.git",
+			CodeOwners: map[string]CodeOwners{"main": {Path: ".github/CODEOWNERS", Rules: []CodeOwnerRule{
+				{Pattern: "*", Owners: []string{"@MicrosoftDocs/docs-team"}},
+				{Pattern: "/articles/aks/", Owners: []string{"@aks-docs"}},
+			}}},
+		},
+	}
+	defer func() { repositories.byURL = nil }()
+
+	matches := []Match{{
+		RepoURL: "https://github.com/MicrosoftDocs/azure-docs.git",
+		Files:   []string{"articles/aks/intro.md"},
+	}}
+
+	owners := declaredOwners(matches, "Who owns articles/aks?")
+	assert.Len(t, owners, 1)
+	assert.Equal(t, []string{"@aks-docs"}, owners[0].Owners)
+	assert.Equal(t, "CODEOWNERS", owners[0].Source)
+}
+
+func TestDeclaredOwnersMatchesQuestionFiles(t *testing.T) {
+	repositories.byURL = map[string]RepositorySettings{
+		"https://github.com/MicrosoftDocs/azure-docs.git": {
+			URL: "https://github.com/MicrosoftDocs/azure-docs.git",
+			CodeOwners: map[string]CodeOwners{"main": {Path: ".github/CODEOWNERS", Rules: []CodeOwnerRule{
+				{Pattern: "docs/*", Owners: []string{"@docs"}},
+			}}},
+		},
+	}
+	defer func() { repositories.byURL = nil }()
+
+	owners := declaredOwners(nil, "Who owns docs/intro.md?")
+	assert.Len(t, owners, 1)
+	assert.Equal(t, []string{"@docs"}, owners[0].Owners)
+
+	assert.Empty(t, declaredOwners(nil, "Who owns docs/aks/intro.md?"))
+}
+
+func TestDeclaredOwnersKeepsDotDirectories(t *testing.T) {
+	repositories.byURL = map[string]RepositorySettings{
+		"https://github.com/MicrosoftDocs/azure-docs.git": {
+			URL: "https://github.com/MicrosoftDocs/azure-docs.git",
+			CodeOwners: map[string]CodeOwners{"main": {Path: ".github/CODEOWNERS", Rules: []CodeOwnerRule{
+				{Pattern: "/github/", Owners: []string{"@github-docs"}},
+				{Pattern: "/.github/", Owners: []string{"@docs-admins"}},
+			}}},
+		},
+	}
+	defer func() { repositories.byURL = nil }()
+
+	owners := declaredOwners(nil, "Who owns ./.github/workflows/?")
+	assert.Len(t, owners, 1)
+	assert.Equal(t, ".github/workflows", owners[0].Path)
+	assert.Equal(t, []string{"@docs-admins"}, owners[0].Owners)
+}
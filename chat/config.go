@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v3"
+)
+
+// configFileEnv names the YAML file the configuration is read from. Without
+// it the configuration comes from the defaults and the environment.
+const configFileEnv = "CONFIG_FILE"
+
+const redacted = "<redacted>"
+
+// config is the configuration of the chat service, loaded at startup.
+var config = defaultConfig()
+
+// Config is the configuration of the chat service. Every field can be set in
+// the YAML file and overridden by the environment variable in its env tag.
+// Fields tagged secret are redacted when the configuration is printed.
+type Config struct {
+	LogLevel     string             `yaml:"log_level" env:"LOG_LEVEL"`
+	Tracing      TracingConfig      `yaml:"tracing"`
+	Server       ServerConfig       `yaml:"server"`
+	Database     DatabaseConfig     `yaml:"database"`
+	OpenAI       OpenAIConfig       `yaml:"openai"`
+	Pinecone     PineconeConfig     `yaml:"pinecone"`
+	Privacy      PrivacyConfig      `yaml:"privacy"`
+	Sessions     SessionsConfig     `yaml:"sessions"`
+	Experts      ExpertsConfig      `yaml:"experts"`
+	Links        LinksConfig        `yaml:"links"`
+	Retrieval    RetrievalConfig    `yaml:"retrieval"`
+	Rerank       RerankConfig       `yaml:"rerank"`
+	QueryRewrite QueryRewriteConfig `yaml:"query_rewrite"`
+}
+
+type TracingConfig struct {
+	// OTLPEndpoint is the base URL spans are exported to over OTLP/HTTP.
+	OTLPEndpoint string `yaml:"otlp_endpoint" env:"OTEL_EXPORTER_OTLP_ENDPOINT"`
+}
+
+type ServerConfig struct {
+	Port string `yaml:"port" env:"PORT"`
+}
+
+type DatabaseConfig struct {
+	// ConnectionString is optional. Without it, questions are answered
+	// without per-repository settings from the initial generation.
+	ConnectionString string `yaml:"connection_string" env:"COSMOS_DB_CONNECTION_STRING" secret:"true"`
+}
+
+type OpenAIConfig struct {
+	APIKey      string  `yaml:"api_key" env:"OPEN_AI_KEY" secret:"true"`
+	MaxTokens   int     `yaml:"max_tokens" env:"OPENAI_MAX_TOKENS"`
+	Temperature float64 `yaml:"temperature" env:"OPENAI_TEMPERATURE"`
+}
+
+type PineconeConfig struct {
+	APIURL string `yaml:"api_url" env:"PINECONE_API_URL"`
+	APIKey string `yaml:"api_key" env:"PINECONE_API_KEY" secret:"true"`
+	// TopK is the number of commits retrieved for a question.
+	TopK int `yaml:"top_k" env:"PINECONE_TOP_K"`
+	// BlockedListURL serves the authors, one per line, never suggested.
+	BlockedListURL string `yaml:"blocked_list_url" env:"BLOCKED_LIST_URL"`
+}
+
+// minPseudonymSecretLength keeps pseudonym secrets from being guessed.
+const minPseudonymSecretLength = 16
+
+type PrivacyConfig struct {
+	// PseudonymSecret keys the pseudonyms of contributors. Contributors of
+	// repositories with the pseudonym privacy mode are withheld without it.
+	PseudonymSecret string `yaml:"pseudonym_secret" env:"PSEUDONYM_SECRET" secret:"true"`
+}
+
+type SessionsConfig struct {
+	// TTLMinutes is how long a session is kept after its last message.
+	TTLMinutes int `yaml:"ttl_minutes" env:"SESSION_TTL_MINUTES"`
+	// ClientHistory accepts the history sent by clients in requests without
+	// a session. Deprecated: clients should use sessions, which cannot be
+	// altered; this will be turned off by default.
+	ClientHistory bool `yaml:"client_history" env:"SESSION_CLIENT_HISTORY"`
+}
+
+type ExpertsConfig struct {
+	// Candidates is the number of commits retrieved by an expert search.
+	Candidates int `yaml:"candidates" env:"EXPERT_SEARCH_CANDIDATES"`
+	// RecencyHalfLifeDays is the age at which a commit counts half as much
+	// towards the recency of its author.
+	RecencyHalfLifeDays int `yaml:"recency_half_life_days" env:"EXPERT_RECENCY_HALF_LIFE_DAYS"`
+}
+
+// RetrievalConfig weighs the vector and keyword results when they are fused.
+type RetrievalConfig struct {
+	VectorWeight  float64 `yaml:"vector_weight" env:"RETRIEVAL_VECTOR_WEIGHT"`
+	KeywordWeight float64 `yaml:"keyword_weight" env:"RETRIEVAL_KEYWORD_WEIGHT"`
+	// RRFK dampens the advantage of the top ranks in reciprocal-rank fusion.
+	RRFK int `yaml:"rrf_k" env:"RETRIEVAL_RRF_K"`
+}
+
+// RerankConfig configures the re-ranking of retrieved commits. Without a
+// reranker, commits are ranked by retrieval alone.
+type RerankConfig struct {
+	// Reranker is llm, to have the chat model grade the commits, or http, to
+	// score them with a cross-encoder served at URL.
+	Reranker string `yaml:"reranker" env:"RERANKER"`
+	// Candidates is the number of commits retrieved for re-ranking, and Keep
+	// the number of the best re-ranked commits passed to the prompt.
+	Candidates int    `yaml:"candidates" env:"RERANK_CANDIDATES"`
+	Keep       int    `yaml:"keep" env:"RERANK_KEEP"`
+	Model      string `yaml:"model" env:"RERANK_MODEL"`
+	URL        string `yaml:"url" env:"RERANKER_URL"`
+	APIKey     string `yaml:"api_key" env:"RERANKER_API_KEY" secret:"true"`
+}
+
+// QueryRewriteConfig configures how follow-up questions are searched. With
+// mode llm, the chat model Model condenses the conversation into a
+// standalone query; with mode off, the whole conversation is searched.
+type QueryRewriteConfig struct {
+	Mode  string `yaml:"mode" env:"QUERY_REWRITE"`
+	Model string `yaml:"model" env:"QUERY_REWRITE_MODEL"`
+}
+
+type LinksConfig struct {
+	// Hosts maps the host names of self-hosted git servers to how links to
+	// their commits and files are built. It can only be set in the file.
+	Hosts map[string]LinkHost `yaml:"hosts"`
+}
+
+// LinkHost builds links with the layout of a public host, such as gitlab for
+// a GitLab instance, with the templates overriding it.
+type LinkHost struct {
+	Layout        string `yaml:"layout"`
+	LinkTemplates `yaml:",inline"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		LogLevel:     "info",
+		Server:       ServerConfig{Port: "8080"},
+		OpenAI:       OpenAIConfig{MaxTokens: 1024, Temperature: 0.3},
+		Pinecone:     PineconeConfig{TopK: 10},
+		Sessions:     SessionsConfig{TTLMinutes: 24 * 60, ClientHistory: true},
+		Experts:      ExpertsConfig{Candidates: 100, RecencyHalfLifeDays: 180},
+		Retrieval:    RetrievalConfig{VectorWeight: 1, KeywordWeight: 1, RRFK: 60},
+		Rerank:       RerankConfig{Candidates: 50, Keep: 10, Model: "gpt-3.5-turbo"},
+		QueryRewrite: QueryRewriteConfig{Mode: rewriteLLM, Model: "gpt-3.5-turbo"},
+	}
+}
+
+// loadConfig reads the defaults, then the file at CONFIG_FILE, then the
+// environment. Unknown keys in the file are errors, so typos do not go
+// unnoticed.
+func loadConfig() (Config, error) {
+	c := defaultConfig()
+	if path := os.Getenv(configFileEnv); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return c, fmt.Errorf("failed to read config file: %w", err)
+		}
+
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&c); err != nil && err != io.EOF {
+			return c, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	}
+
+	return c, applyEnv(reflect.ValueOf(&c).Elem())
+}
+
+// applyEnv overrides fields by the environment variables in their env tags.
+// Empty variables are ignored.
+func applyEnv(v reflect.Value) error {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			if err := applyEnv(field); err != nil {
+				return err
+			}
+			continue
+		}
+
+		name := v.Type().Field(i).Tag.Get("env")
+		value := os.Getenv(name)
+		if name == "" || value == "" {
+			continue
+		}
+
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(value)
+		case reflect.Int:
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("%s must be an integer, got %q", name, value)
+			}
+			field.SetInt(n)
+		case reflect.Float64:
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return fmt.Errorf("%s must be a number, got %q", name, value)
+			}
+			field.SetFloat(f)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%s must be true or false, got %q", name, value)
+			}
+			field.SetBool(b)
+		}
+	}
+	return nil
+}
+
+// validate reports every invalid setting at once.
+func (c Config) validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.OpenAI.APIKey != "", "openai.api_key (OPEN_AI_KEY) is required")
+	check(c.Pinecone.APIURL != "", "pinecone.api_url (PINECONE_API_URL) is required")
+	check(c.Pinecone.APIURL == "" || validURL(c.Pinecone.APIURL),
+		"pinecone.api_url (PINECONE_API_URL) must be an http or https URL, got %q", c.Pinecone.APIURL)
+	check(c.Pinecone.BlockedListURL == "" || validURL(c.Pinecone.BlockedListURL),
+		"pinecone.blocked_list_url (BLOCKED_LIST_URL) must be an http or https URL, got %q", c.Pinecone.BlockedListURL)
+	check(c.Pinecone.TopK > 0 && c.Pinecone.TopK <= 10000, "pinecone.top_k (PINECONE_TOP_K) must be between 1 and 10000, got %d", c.Pinecone.TopK)
+	check(c.OpenAI.MaxTokens > 0, "openai.max_tokens (OPENAI_MAX_TOKENS) must be positive, got %d", c.OpenAI.MaxTokens)
+	check(c.OpenAI.Temperature >= 0 && c.OpenAI.Temperature <= 2, "openai.temperature (OPENAI_TEMPERATURE) must be between 0 and 2, got %g", c.OpenAI.Temperature)
+	check(c.Experts.Candidates > 0 && c.Experts.Candidates <= 10000, "experts.candidates (EXPERT_SEARCH_CANDIDATES) must be between 1 and 10000, got %d", c.Experts.Candidates)
+	check(c.Experts.RecencyHalfLifeDays > 0, "experts.recency_half_life_days (EXPERT_RECENCY_HALF_LIFE_DAYS) must be positive, got %d", c.Experts.RecencyHalfLifeDays)
+	check(c.Retrieval.VectorWeight >= 0, "retrieval.vector_weight (RETRIEVAL_VECTOR_WEIGHT) must not be negative, got %g", c.Retrieval.VectorWeight)
+	check(c.Retrieval.KeywordWeight >= 0, "retrieval.keyword_weight (RETRIEVAL_KEYWORD_WEIGHT) must not be negative, got %g", c.Retrieval.KeywordWeight)
+	check(c.Retrieval.VectorWeight+c.Retrieval.KeywordWeight > 0, "retrieval.vector_weight and retrieval.keyword_weight must not both be 0")
+	check(c.Retrieval.RRFK > 0, "retrieval.rrf_k (RETRIEVAL_RRF_K) must be positive, got %d", c.Retrieval.RRFK)
+	check(c.Rerank.Reranker == "" || c.Rerank.Reranker == rerankerLLM || c.Rerank.Reranker == rerankerHTTP,
+		"rerank.reranker (RERANKER) must be llm or http, got %q", c.Rerank.Reranker)
+	check(c.Rerank.Reranker != rerankerHTTP || validURL(c.Rerank.URL), "rerank.url (RERANKER_URL) must be an http or https URL, got %q", c.Rerank.URL)
+	check(c.Rerank.Reranker != rerankerLLM || c.Rerank.Model != "", "rerank.model (RERANK_MODEL) is required")
+	check(c.Rerank.Keep > 0, "rerank.keep (RERANK_KEEP) must be positive, got %d", c.Rerank.Keep)
+	check(c.Rerank.Candidates >= c.Rerank.Keep && c.Rerank.Candidates <= 10000,
+		"rerank.candidates (RERANK_CANDIDATES) must be between rerank.keep and 10000, got %d", c.Rerank.Candidates)
+	check(c.QueryRewrite.Mode == rewriteLLM || c.QueryRewrite.Mode == rewriteOff, "query_rewrite.mode (QUERY_REWRITE) must be llm or off, got %q", c.QueryRewrite.Mode)
+	check(c.QueryRewrite.Mode != rewriteLLM || c.QueryRewrite.Model != "", "query_rewrite.model (QUERY_REWRITE_MODEL) is required")
+	check(c.Privacy.PseudonymSecret == "" || len(c.Privacy.PseudonymSecret) >= minPseudonymSecretLength,
+		"privacy.pseudonym_secret (PSEUDONYM_SECRET) must be at least %d characters", minPseudonymSecretLength)
+	check(c.Sessions.TTLMinutes > 0, "sessions.ttl_minutes (SESSION_TTL_MINUTES) must be positive, got %d", c.Sessions.TTLMinutes)
+
+	hosts := make([]string, 0, len(c.Links.Hosts))
+	for host := range c.Links.Hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		link := c.Links.Hosts[host]
+		_, known := hostLayouts[link.Layout]
+		check(link.Layout == "" || known, "links.hosts.%s.layout must be github, gitlab, azuredevops or bitbucket, got %q", host, link.Layout)
+		check(link.Layout != "" || link.Commit != "", "links.hosts.%s needs a layout or a commit template", host)
+		check(link.Commit == "" || strings.Contains(link.Commit, "{sha}"), "links.hosts.%s.commit must contain {sha}, got %q", host, link.Commit)
+		check(link.File == "" || strings.Contains(link.File, "{path}"), "links.hosts.%s.file must contain {path}, got %q", host, link.File)
+	}
+
+	_, err := zapcore.ParseLevel(c.LogLevel)
+	check(err == nil, "log_level (LOG_LEVEL) must be debug, info, warn or error, got %q", c.LogLevel)
+	check(c.Tracing.OTLPEndpoint == "" || validURL(c.Tracing.OTLPEndpoint),
+		"tracing.otlp_endpoint (OTEL_EXPORTER_OTLP_ENDPOINT) must be an http or https URL, got %q", c.Tracing.OTLPEndpoint)
+	port, err := strconv.Atoi(c.Server.Port)
+	check(err == nil && port > 0 && port < 65536, "server.port (PORT) must be a port number, got %q", c.Server.Port)
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+func validURL(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// redacted returns a copy of the configuration with the secrets that are
+// set replaced.
+func (c Config) redacted() Config {
+	redactSecrets(reflect.ValueOf(&c).Elem())
+	return c
+}
+
+func redactSecrets(v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			redactSecrets(field)
+		} else if v.Type().Field(i).Tag.Get("secret") == "true" && field.String() != "" {
+			field.SetString(redacted)
+		}
+	}
+}
+
+// printConfig writes the configuration as YAML with its secrets redacted.
+func printConfig(w io.Writer, c Config) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(c.redacted()); err != nil {
+		return fmt.Errorf("failed to print config: %w", err)
+	}
+	return encoder.Close()
+}
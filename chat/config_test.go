@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigEnvironmentOverridesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("pinecone:\n  top_k: 5\nopenai:\n  max_tokens: 512\n"), 0o600))
+	t.Setenv(configFileEnv, path)
+	t.Setenv("PINECONE_TOP_K", "20")
+	t.Setenv("OPENAI_TEMPERATURE", "")
+
+	c, err := loadConfig()
+	require.NoError(t, err)
+	require.Equal(t, 20, c.Pinecone.TopK)
+	require.Equal(t, 512, c.OpenAI.MaxTokens)
+	require.Equal(t, 0.3, c.OpenAI.Temperature)
+
+	t.Setenv("OPENAI_TEMPERATURE", "warm")
+	_, err = loadConfig()
+	require.EqualError(t, err, `OPENAI_TEMPERATURE must be a number, got "warm"`)
+
+	t.Setenv("OPENAI_TEMPERATURE", "")
+	t.Setenv("SESSION_CLIENT_HISTORY", "false")
+	c, err = loadConfig()
+	require.NoError(t, err)
+	require.False(t, c.Sessions.ClientHistory)
+
+	t.Setenv("SESSION_CLIENT_HISTORY", "maybe")
+	_, err = loadConfig()
+	require.EqualError(t, err, `SESSION_CLIENT_HISTORY must be true or false, got "maybe"`)
+}
+
+func TestConfigValidation(t *testing.T) {
+	c := defaultConfig()
+	c.Pinecone.TopK = 0
+	c.Server.Port = "http"
+	err := c.validate()
+	require.ErrorContains(t, err, "openai.api_key (OPEN_AI_KEY) is required")
+	require.ErrorContains(t, err, "pinecone.top_k (PINECONE_TOP_K) must be between 1 and 10000, got 0")
+	require.ErrorContains(t, err, `server.port (PORT) must be a port number, got "http"`)
+
+	c = defaultConfig()
+	c.OpenAI.APIKey = "sk-secret"
+	c.Pinecone.APIURL = "https://index.pinecone.io"
+	require.NoError(t, c.validate())
+}
+
+func TestConfigPrintRedactsSecrets(t *testing.T) {
+	c := defaultConfig()
+	c.Pinecone.APIKey = "pc-secret"
+
+	var out bytes.Buffer
+	require.NoError(t, printConfig(&out, c))
+	require.NotContains(t, out.String(), "pc-secret")
+	require.Contains(t, out.String(), "api_key: "+redacted)
+	require.Contains(t, out.String(), "top_k: 10")
+}
+
+func TestConfigValidatesLinkHosts(t *testing.T) {
+	c := defaultConfig()
+	c.Links.Hosts = map[string]LinkHost{
+		"git.example.com":  {Layout: "gitea"},
+		"code.example.com": {LinkTemplates: LinkTemplates{Commit: "{repo}/commits"}},
+		"scm.example.com":  {},
+	}
+	err := c.validate()
+	require.ErrorContains(t, err, `links.hosts.git.example.com.layout must be github, gitlab, azuredevops or bitbucket, got "gitea"`)
+	require.ErrorContains(t, err, `links.hosts.code.example.com.commit must contain {sha}, got "{repo}/commits"`)
+	require.ErrorContains(t, err, "links.hosts.scm.example.com needs a layout or a commit template")
+}
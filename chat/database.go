@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	client        *mongo.Client
+	repoDB        *mongo.Database
+	repoCol       *mongo.Collection
+	generationCol *mongo.Collection
+	commitCol     *mongo.Collection
+
+	keywordCol      *mongo.Collection
+	keywordStatsCol *mongo.Collection
+)
+
+// initDatabase connects to the repository database. Without a connection
+// string the chat service runs without per-repository settings and without
+// sessions.
+func initDatabase() {
+	connString := config.Database.ConnectionString
+	if connString == "" {
+		return
+	}
+
+	var err error
+	client, err = mongo.Connect(context.Background(), options.Client().ApplyURI(connString))
+	if err != nil {
+		logger.Fatalw("Error connecting to database", "error", err)
+	}
+	repoDB = client.Database("repositoryDB")
+	repoCol = repoDB.Collection("repositories")
+	generationCol = repoDB.Collection("generations")
+	commitCol = repoDB.Collection("commits")
+	keywordCol = repoDB.Collection("keywords")
+	keywordStatsCol = repoDB.Collection("keyword_stats")
+
+	sessions = newSessionStore(repoDB.Collection("sessions"), time.Duration(config.Sessions.TTLMinutes)*time.Minute)
+	if err := sessions.ensureIndexes(context.Background()); err != nil {
+		logger.Warnw("Expired sessions are not removed from the database", "error", err)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Expert is a person recommended for a question. Experts are derived from
+// the retrieved commits and documents, not from the generated answer.
+type Expert struct {
+	// Person is the canonical ID the indexer keeps the person's profile
+	// under. It is left out for repositories whose privacy policy hides
+	// contributors, whose experts are grouped by name and contact instead.
+	Person  string `json:"person,omitempty"`
+	Name    string `json:"name"`
+	Contact string `json:"contact,omitempty"`
+	// Score is the sum of the scores of the person's commits and documents,
+	// so several relevant commits outrank a single close one.
+	Score    float64          `json:"score"`
+	Evidence []ExpertEvidence `json:"evidence"`
+}
+
+// ExpertEvidence is a retrieved commit or document of an expert.
+type ExpertEvidence struct {
+	RepoURL string   `json:"repoUrl,omitempty"`
+	SHA     string   `json:"sha,omitempty"`
+	URL     string   `json:"url,omitempty"`
+	Files   []string `json:"files,omitempty"`
+	Date    string   `json:"date,omitempty"`
+	Score   float64  `json:"score"`
+}
+
+// rankExperts groups ranked matches by person, best expert first.
+func rankExperts(matches []Match) []Expert {
+	experts := groupExperts(matches)
+	sort.SliceStable(experts, func(i, j int) bool {
+		return experts[i].Score > experts[j].Score
+	})
+	return experts
+}
+
+// groupExperts groups matches by person in the order the people first
+// appear. The chunks of a commit or document are one piece of evidence,
+// scored by the best of them. The score of an expert is the sum of the
+// scores of their evidence.
+func groupExperts(matches []Match) []Expert {
+	experts := []Expert{}
+	byKey := map[string]int{}
+	evidenceOf := map[string]int{}
+	for _, match := range matches {
+		if match.Author == "" {
+			continue
+		}
+
+		person := match.Person
+		if !contributorsVisible(match.RepoURL) {
+			person = ""
+		}
+		contact := match.Contact
+		if contact == withheldContact {
+			contact = ""
+		}
+
+		key := person
+		if key == "" {
+			key = strings.ToLower(match.RepoURL + "\n" + match.Author + "\n" + contact)
+		}
+		i, ok := byKey[key]
+		if !ok {
+			i = len(experts)
+			byKey[key] = i
+			experts = append(experts, Expert{Person: person, Name: match.Author, Contact: contact})
+		}
+
+		source := key + "\n" + evidenceKey(match)
+		if k, ok := evidenceOf[source]; ok {
+			if best := &experts[i].Evidence[k]; match.Score > best.Score {
+				experts[i].Score += match.Score - best.Score
+				*best = newExpertEvidence(match)
+			}
+			continue
+		}
+		evidenceOf[source] = len(experts[i].Evidence)
+		experts[i].Score += match.Score
+		experts[i].Evidence = append(experts[i].Evidence, newExpertEvidence(match))
+	}
+	return experts
+}
+
+// evidenceKey identifies the commit or document a match is a chunk of.
+func evidenceKey(match Match) string {
+	if match.SHA != "" {
+		return match.RepoURL + "\n" + match.SHA
+	}
+	if match.URL != "" {
+		return match.URL
+	}
+	return match.ID
+}
+
+func newExpertEvidence(match Match) ExpertEvidence {
+	evidence := ExpertEvidence{
+		RepoURL: match.RepoURL,
+		SHA:     match.SHA,
+		URL:     match.URL,
+		Files:   match.Files,
+		Score:   match.Score,
+	}
+	if match.Timestamp > 0 {
+		evidence.Date = time.Unix(match.Timestamp, 0).UTC().Format(time.RFC3339)
+	}
+	return evidence
+}
+
+// contributorsVisible tells whether the privacy policy of a repository lets
+// its contributors be identified across repositories.
+func contributorsVisible(repoURL string) bool {
+	repo, ok := repositories.lookup(repoURL)
+	return !ok || repo.Privacy == nil || repo.Privacy.Mode == "" || repo.Privacy.Mode == privacyEmail
+}
+
+// resolvePeople looks up the canonical person of matches indexed before
+// vectors carried it in the commits ledger.
+func resolvePeople(ctx context.Context, matches []Match) error {
+	if commitCol == nil {
+		return nil
+	}
+
+	var ids []string
+	for _, match := range matches {
+		if match.Person == "" && match.RepoID != "" && match.SHA != "" {
+			ids = append(ids, ledgerID(match.RepoID, match.SHA))
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	cursor, err := commitCol.Find(ctx, bson.M{"_id": bson.M{"$in": ids}}, options.Find().SetProjection(bson.M{"person": 1}))
+	if err != nil {
+		return fmt.Errorf("failed to look up people: %w", err)
+	}
+	var entries []struct {
+		ID     string `bson:"_id"`
+		Person string `bson:"person"`
+	}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return fmt.Errorf("failed to decode people: %w", err)
+	}
+
+	people := map[string]string{}
+	for _, entry := range entries {
+		people[entry.ID] = entry.Person
+	}
+	for i := range matches {
+		if matches[i].Person == "" {
+			matches[i].Person = people[ledgerID(matches[i].RepoID, matches[i].SHA)]
+		}
+	}
+	return nil
+}
+
+// ledgerID is the ID of a commit in the indexer's commits ledger.
+func ledgerID(repoID, sha string) string {
+	return repoID + ":" + sha
+}
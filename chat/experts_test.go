@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRankExpertsGroupsMatchesByPerson(t *testing.T) {
+	matches := []Match{
+		{Score: 0.9, RepoURL: "https://example.com/api.git", SHA: "a1", Person: "p-sam", Author: "Sam", Contact: "sam@example.com", Timestamp: 1700000000, Files: []string{"api/main.go"}},
+		{Score: 0.8, RepoURL: "https://example.com/web.git", SHA: "b1", Person: "p-jane", Author: "Jane", Contact: "jane@example.com"},
+		{Score: 0.7, RepoURL: "https://example.com/web.git", SHA: "b2", Person: "p-jane", Author: "Jane D.", Contact: "jane@example.com"},
+		{Score: 0.6, RepoURL: "https://example.com/web.git", SHA: "b3"},
+		{Score: 0.5, RepoURL: "https://example.com/web.git", SHA: "b1", Person: "p-jane", Author: "Jane", Contact: "jane@example.com"},
+		{Score: 0.4, RepoURL: "https://example.com/api.git", SHA: "a1", Person: "p-sam", Author: "Sam", Contact: "sam@example.com"},
+	}
+
+	experts := rankExperts(matches)
+	assert.Len(t, experts, 2)
+	assert.Equal(t, "p-jane", experts[0].Person)
+	assert.Equal(t, "Jane", experts[0].Name)
+	assert.InDelta(t, 1.5, experts[0].Score, 1e-9)
+	assert.Len(t, experts[0].Evidence, 2)
+	assert.Equal(t, []string{"b1", "b2"}, []string{experts[0].Evidence[0].SHA, experts[0].Evidence[1].SHA})
+
+	assert.Equal(t, "p-sam", experts[1].Person)
+	assert.InDelta(t, 0.9, experts[1].Score, 1e-9)
+	assert.Equal(t, "sam@example.com", experts[1].Contact)
+	assert.Equal(t, ExpertEvidence{
+		RepoURL: "https://example.com/api.git",
+		SHA:     "a1",
+		Files:   []string{"api/main.go"},
+		Date:    "2023-11-14T22:13:20Z",
+		Score:   0.9,
+	}, experts[1].Evidence[0])
+}
+
+func TestRankExpertsHidesPeopleOfPrivateRepositories(t *testing.T) {
+	repositories.byURL = map[string]RepositorySettings{
+		"https://example.com/private.git": {ID: "private", Privacy: &PrivacyPolicy{Mode: privacyName}},
+	}
+	defer func() { repositories.byURL = nil }()
+
+	experts := rankExperts([]Match{
+		{Score: 0.5, RepoURL: "https://example.com/private.git", SHA: "a1", Person: "p-jane", Author: "Jane", Contact: withheldContact},
+		{Score: 0.4, RepoURL: "https://example.com/open.git", SHA: "b1", Person: "p-jane", Author: "Jane", Contact: "jane@example.com"},
+	})
+	assert.Len(t, experts, 2)
+	assert.Empty(t, experts[0].Person)
+	assert.Empty(t, experts[0].Contact)
+	assert.Equal(t, "p-jane", experts[1].Person)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Weights of the parts of an expert search score. Every part is between 0
+// and 1, and so is the score.
+const (
+	similarityWeight = 0.6
+	recencyWeight    = 0.25
+	commitsWeight    = 0.15
+)
+
+// commitSaturation is the number of matching commits that earns the full
+// commit count part of the score.
+const commitSaturation = 10
+
+// ExpertSearchResult is an expert found without a chat completion, with the
+// parts their score is made of.
+type ExpertSearchResult struct {
+	Expert
+	// Similarity is the score of the expert's closest match.
+	Similarity float64 `json:"similarity"`
+	// Recency halves every recency half-life since the expert's latest match.
+	Recency float64 `json:"recency"`
+	// Commits is the number of distinct commits of the expert's evidence.
+	Commits int `json:"commits"`
+}
+
+// expertQuery is a search for the experts on a topic, optionally restricted
+// to a repository, a path and the commits after a date.
+type expertQuery struct {
+	Text  string
+	Repo  string
+	Path  string
+	Since time.Time
+}
+
+func parseExpertQuery(c *gin.Context) (expertQuery, error) {
+	query := expertQuery{
+		Text: strings.TrimSpace(c.Query("q")),
+		Repo: c.Query("repo"),
+		Path: strings.Trim(c.Query("path"), "/"),
+	}
+	if query.Text == "" {
+		return query, errors.New("q is required")
+	}
+
+	if since := c.Query("since"); since != "" {
+		var err error
+		query.Since, err = time.Parse("2006-01-02", since)
+		if err != nil {
+			query.Since, err = time.Parse(time.RFC3339, since)
+		}
+		if err != nil {
+			return query, fmt.Errorf("since must be a date such as 2023-01-31, got %q", since)
+		}
+	}
+	return query, nil
+}
+
+// pineconeFilter returns the metadata filter of the query, or nil if
+// nothing is filtered. Paths cannot be matched by prefix in Pinecone, so
+// they are filtered after retrieval.
+func (q expertQuery) pineconeFilter() map[string]interface{} {
+	filter := map[string]interface{}{}
+	if q.Repo != "" {
+		if repo, ok := repositories.lookup(q.Repo); ok {
+			filter["repoId"] = map[string]interface{}{"$eq": repo.ID}
+		} else if strings.Contains(q.Repo, "://") {
+			filter["repoUrl"] = map[string]interface{}{"$eq": q.Repo}
+		} else {
+			filter["repoId"] = map[string]interface{}{"$eq": q.Repo}
+		}
+	}
+	if !q.Since.IsZero() {
+		filter["timestamp"] = map[string]interface{}{"$gte": float64(q.Since.Unix())}
+	}
+	if len(filter) == 0 {
+		return nil
+	}
+	return filter
+}
+
+// filterPath keeps the matches touching the query's path or a file below it.
+func (q expertQuery) filterPath(matches []Match) []Match {
+	if q.Path == "" {
+		return matches
+	}
+
+	kept := matches[:0]
+	for _, match := range matches {
+		for _, file := range match.Files {
+			if file == q.Path || strings.HasPrefix(file, q.Path+"/") {
+				kept = append(kept, match)
+				break
+			}
+		}
+	}
+	return kept
+}
+
+// scoreExperts groups matches by person and scores every person by the
+// similarity of their closest match, the age of their latest match and the
+// number of their commits. Ties are broken by name, so the same matches
+// always give the same ranking.
+func scoreExperts(matches []Match, now time.Time) []ExpertSearchResult {
+	halfLife := time.Duration(config.Experts.RecencyHalfLifeDays) * 24 * time.Hour
+
+	results := []ExpertSearchResult{}
+	for _, expert := range groupExperts(matches) {
+		result := ExpertSearchResult{Expert: expert}
+		for _, evidence := range expert.Evidence {
+			if evidence.SHA != "" {
+				result.Commits++
+			}
+			result.Similarity = math.Max(result.Similarity, evidence.Score)
+			if date, err := time.Parse(time.RFC3339, evidence.Date); err == nil {
+				recency := math.Pow(0.5, float64(now.Sub(date))/float64(halfLife))
+				result.Recency = math.Max(result.Recency, math.Min(recency, 1))
+			}
+		}
+
+		commits := math.Min(math.Log1p(float64(result.Commits))/math.Log1p(commitSaturation), 1)
+		result.Score = similarityWeight*result.Similarity + recencyWeight*result.Recency + commitsWeight*commits
+		results = append(results, result)
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Name < results[j].Name
+	})
+	return results
+}
+
+// registerExpertRoutes adds the expert search, which ranks the authors of
+// the commits closest to a query without asking the chat model.
+func registerExpertRoutes(router *gin.Engine, openaiClient *OpenAIClient, pineconeClient *PineconeClient) {
+	router.GET("/api/experts", func(c *gin.Context) {
+		query, err := parseExpertQuery(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx := c.Request.Context()
+		matches, err := retrieveMatches(ctx, openaiClient, pineconeClient, query.Text, query.pineconeFilter(), config.Experts.Candidates)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		matches = query.filterPath(matches)
+		if err := resolvePeople(ctx, matches); err != nil {
+			loggerFrom(ctx).Warnw("Failed to resolve people of matches", "error", err)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"query":   query.Text,
+			"experts": scoreExperts(matches, time.Now()),
+		})
+	})
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScoreExpertsCombinesSimilarityRecencyAndCommits(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	halfLifeAgo := now.AddDate(0, 0, -config.Experts.RecencyHalfLifeDays).Unix()
+	matches := []Match{
+		{Score: 0.9, SHA: "o1", Person: "p-old", Author: "Old", Timestamp: halfLifeAgo},
+		{Score: 0.8, SHA: "b1", Person: "p-busy", Author: "Busy", Timestamp: now.Unix()},
+		{Score: 0.7, SHA: "b2", Person: "p-busy", Author: "Busy", Timestamp: now.AddDate(0, -1, 0).Unix()},
+		{Score: 0.6, SHA: "b2", Person: "p-busy", Author: "Busy", Timestamp: now.AddDate(0, -1, 0).Unix()},
+		{Score: 0.6, URL: "https://example.com/README.md", Person: "p-busy", Author: "Busy"},
+		{Score: 0.5, SHA: "c1", Person: "p-b", Author: "B"},
+		{Score: 0.5, SHA: "a1", Person: "p-a", Author: "A"},
+	}
+
+	results := scoreExperts(matches, now)
+	require.Len(t, results, 4)
+	require.Equal(t, []string{"Busy", "Old", "A", "B"}, []string{results[0].Name, results[1].Name, results[2].Name, results[3].Name})
+
+	require.Equal(t, 0.8, results[0].Similarity)
+	require.Equal(t, 1.0, results[0].Recency)
+	require.Equal(t, 2, results[0].Commits)
+	require.Len(t, results[0].Evidence, 3)
+	require.InDelta(t, 0.5, results[1].Recency, 1e-9)
+	require.Zero(t, results[2].Recency)
+}
+
+func TestExpertQueryFiltersRepositoryPathAndDate(t *testing.T) {
+	parse := func(target string) (expertQuery, error) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", target, nil)
+		return parseExpertQuery(c)
+	}
+
+	_, err := parse("/api/experts?repo=api")
+	require.EqualError(t, err, "q is required")
+	_, err = parse("/api/experts?q=auth&since=yesterday")
+	require.EqualError(t, err, `since must be a date such as 2023-01-31, got "yesterday"`)
+
+	query, err := parse("/api/experts?q=auth&repo=api&path=/src/auth/&since=2023-01-31")
+	require.NoError(t, err)
+	require.Nil(t, expertQuery{Text: "auth"}.pineconeFilter())
+	require.Equal(t, map[string]interface{}{
+		"repoId":    map[string]interface{}{"$eq": "api"},
+		"timestamp": map[string]interface{}{"$gte": float64(1675123200)},
+	}, query.pineconeFilter())
+
+	matches := query.filterPath([]Match{
+		{SHA: "a", Files: []string{"README.md", "src/auth/login.go"}},
+		{SHA: "b", Files: []string{"src/authz/policy.go"}},
+		{SHA: "c", Files: []string{"src/auth"}},
+	})
+	require.Equal(t, []string{"a", "c"}, []string{matches[0].SHA, matches[1].SHA})
+	require.Len(t, matches, 2)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// facetBoost is added to the score of a match for every language or
+// component it shares with the question.
+const facetBoost = 0.05
+
+// RetrievalFilter restricts retrieval to commits touching the given
+// languages or components, as tagged by the indexer.
+type RetrievalFilter struct {
+	Languages  []string `json:"languages,omitempty"`
+	Components []string `json:"components,omitempty"`
+}
+
+// pineconeFilter returns the metadata filter of the query, or nil if
+// nothing is filtered.
+func (f RetrievalFilter) pineconeFilter() map[string]interface{} {
+	filter := map[string]interface{}{}
+	if len(f.Languages) > 0 {
+		filter["languages"] = map[string]interface{}{"$in": f.Languages}
+	}
+	if len(f.Components) > 0 {
+		filter["components"] = map[string]interface{}{"$in": f.Components}
+	}
+	if len(filter) == 0 {
+		return nil
+	}
+	return filter
+}
+
+// accepts reports whether a match passes the filter, for matches retrieved
+// without the metadata filter.
+func (f RetrievalFilter) accepts(match Match) bool {
+	return (len(f.Languages) == 0 || overlaps(f.Languages, match.Languages)) &&
+		(len(f.Components) == 0 || overlaps(f.Components, match.Components))
+}
+
+func overlaps(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// languageAliases maps the words people use for a language or ecosystem to
+// the name the indexer tags it with. Ambiguous words such as "go" are left out.
+var languageAliases = map[string]string{
+	"golang":     "Go",
+	"typescript": "TypeScript",
+	"javascript": "JavaScript",
+	"node":       "JavaScript",
+	"python":     "Python",
+	"c#":         "C#",
+	"csharp":     "C#",
+	"dotnet":     "C#",
+	"f#":         "F#",
+	"java":       "Java",
+	"kotlin":     "Kotlin",
+	"swift":      "Swift",
+	"ruby":       "Ruby",
+	"rust":       "Rust",
+	"php":        "PHP",
+	"c++":        "C++",
+	"shell":      "Shell",
+	"bash":       "Shell",
+	"powershell": "PowerShell",
+	"sql":        "SQL",
+	"html":       "HTML",
+	"css":        "CSS",
+	"terraform":  "Terraform",
+	"bicep":      "Bicep",
+	"helm":       "Helm",
+	"docker":     "Docker",
+	"dockerfile": "Docker",
+	"angular":    "Angular",
+	"vue":        "Vue",
+	"protobuf":   "Protobuf",
+	"yaml":       "YAML",
+}
+
+var questionWordRE = regexp.MustCompile(`[\w#+.\-/@]+`)
+
+// mentionedLanguages returns the languages named in a question.
+func mentionedLanguages(question string) map[string]bool {
+	languages := map[string]bool{}
+	for _, word := range questionWordRE.FindAllString(strings.ToLower(question), -1) {
+		if language, ok := languageAliases[strings.TrimRight(word, ".")]; ok {
+			languages[language] = true
+		}
+	}
+	return languages
+}
+
+// rankMatches orders matches by score, boosted for the languages or
+// components named in the question or requested by the filter. The boost
+// only orders the matches; their scores are left as retrieved.
+func rankMatches(matches []Match, question string, filter RetrievalFilter) []Match {
+	languages := mentionedLanguages(question)
+	for _, language := range filter.Languages {
+		languages[language] = true
+	}
+	requested := map[string]bool{}
+	for _, component := range filter.Components {
+		requested[component] = true
+	}
+	words := map[string]bool{}
+	for _, word := range questionWordRE.FindAllString(strings.ToLower(question), -1) {
+		words[strings.TrimRight(word, ".")] = true
+	}
+
+	boosted := make([]float64, len(matches))
+	order := make([]int, len(matches))
+	for i, match := range matches {
+		order[i] = i
+		boosted[i] = match.Score
+		for _, language := range match.Languages {
+			if languages[language] {
+				boosted[i] += facetBoost
+			}
+		}
+		for _, component := range match.Components {
+			if requested[component] || componentMentioned(words, component) {
+				boosted[i] += facetBoost
+			}
+		}
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return boosted[order[i]] > boosted[order[j]]
+	})
+	ranked := make([]Match, 0, len(matches))
+	for _, i := range order {
+		ranked = append(ranked, matches[i])
+	}
+	return ranked
+}
+
+// componentMentioned reports whether a question names a component, either
+// by its full name or by the last segment of a path-like name.
+func componentMentioned(words map[string]bool, component string) bool {
+	name := strings.ToLower(component)
+	if words[name] {
+		return true
+	}
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	return len(name) >= 3 && words[name]
+}
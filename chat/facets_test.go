@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRankMatchesBoostsMentionedFacets(t *testing.T) {
+	matches := []Match{
+		{ID: "docs", Score: 0.80, Languages: []string{"Markdown"}},
+		{ID: "infra", Score: 0.78, Languages: []string{"Terraform"}, Components: []string{"platform"}},
+		{ID: "web", Score: 0.77, Languages: []string{"TypeScript", "Angular"}, Components: []string{"@example/web"}},
+	}
+
+	ranked := rankMatches(matches, "Who knows our Terraform?", RetrievalFilter{})
+	require.Equal(t, "infra", ranked[0].ID)
+	require.Equal(t, 0.78, ranked[0].Score)
+	require.Equal(t, "docs", matches[0].ID)
+
+	ranked = rankMatches(matches, "Who does the Angular frontend in web?", RetrievalFilter{})
+	require.Equal(t, []string{"web", "docs", "infra"}, []string{ranked[0].ID, ranked[1].ID, ranked[2].ID})
+
+	ranked = rankMatches(matches, "Who wrote the docs?", RetrievalFilter{})
+	require.Equal(t, []string{"docs", "infra", "web"}, []string{ranked[0].ID, ranked[1].ID, ranked[2].ID})
+}
+
+func TestRetrievalFilter(t *testing.T) {
+	require.Nil(t, RetrievalFilter{}.pineconeFilter())
+	require.Equal(t, map[string]interface{}{
+		"languages": map[string]interface{}{"$in": []string{"Go"}},
+	}, RetrievalFilter{Languages: []string{"Go"}}.pineconeFilter())
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RetrievalOptions tune the retrieval of a conversation request.
+type RetrievalOptions struct {
+	RetrievalFilter
+	// Weights override the configured weights of the vector and keyword
+	// results. A keyword weight of 0 turns keyword search off.
+	Weights RetrievalWeights `json:"weights"`
+	// Debug adds the vector, keyword and fused results to the response.
+	Debug bool `json:"debug,omitempty"`
+}
+
+type RetrievalWeights struct {
+	Vector  *float64 `json:"vector,omitempty"`
+	Keyword *float64 `json:"keyword,omitempty"`
+}
+
+func (o RetrievalOptions) validate() error {
+	vector, keyword := o.fusionWeights()
+	if vector < 0 || keyword < 0 {
+		return errors.New("weights must not be negative")
+	}
+	if vector+keyword == 0 {
+		return errors.New("weights must not both be 0")
+	}
+	return nil
+}
+
+// fusionWeights returns the weights of the request, or the configured ones.
+func (o RetrievalOptions) fusionWeights() (vector, keyword float64) {
+	vector, keyword = config.Retrieval.VectorWeight, config.Retrieval.KeywordWeight
+	if o.Weights.Vector != nil {
+		vector = *o.Weights.Vector
+	}
+	if o.Weights.Keyword != nil {
+		keyword = *o.Weights.Keyword
+	}
+	return vector, keyword
+}
+
+// RetrievalDebug shows how the matches of a question were retrieved.
+type RetrievalDebug struct {
+	VectorWeight  float64        `json:"vectorWeight"`
+	KeywordWeight float64        `json:"keywordWeight"`
+	Vector        []RankedResult `json:"vector"`
+	Keyword       []RankedResult `json:"keyword"`
+	Fused         []RankedResult `json:"fused"`
+	Reranked      []RankedResult `json:"reranked,omitempty"`
+}
+
+// RankedResult is an entry of a result list, best first. Keyword results
+// are identified by their entry in the commits ledger, the others by vector.
+type RankedResult struct {
+	ID      string  `json:"id"`
+	RepoURL string  `json:"repoUrl,omitempty"`
+	SHA     string  `json:"sha,omitempty"`
+	Score   float64 `json:"score"`
+}
+
+func rankedMatches(matches []Match) []RankedResult {
+	results := make([]RankedResult, 0, len(matches))
+	for _, match := range matches {
+		results = append(results, RankedResult{ID: match.ID, RepoURL: match.RepoURL, SHA: match.SHA, Score: match.Score})
+	}
+	return results
+}
+
+func rankedHits(hits []keywordHit) []RankedResult {
+	results := make([]RankedResult, 0, len(hits))
+	for _, hit := range hits {
+		_, sha := splitLedgerID(hit.Entry)
+		results = append(results, RankedResult{ID: hit.Entry, SHA: sha, Score: hit.Score})
+	}
+	return results
+}
+
+// hybridMatches adds the commits found by the keyword index to the vector
+// matches and fuses both rankings. Without keyword hits the vector matches
+// are returned as they are. Keyword search failing is logged and does not
+// fail the question. The result lists are returned for debugging if the
+// options ask for them.
+func hybridMatches(ctx context.Context, pineconeClient *PineconeClient, vectorMatches []Match, question string, options RetrievalOptions, limit int) ([]Match, *RetrievalDebug) {
+	vectorWeight, keywordWeight := options.fusionWeights()
+
+	var hits []keywordHit
+	var keywordMatches []Match
+	if keywordWeight > 0 {
+		var err error
+		hits, err = searchKeywords(ctx, question, limit)
+		if err == nil {
+			keywordMatches, err = fetchKeywordMatches(ctx, pineconeClient, vectorMatches, hits, options.RetrievalFilter)
+		}
+		if err != nil {
+			loggerFrom(ctx).Warnw("Keyword search failed, using vector search only", "error", err)
+			hits, keywordMatches = nil, nil
+		}
+	}
+
+	fused := vectorMatches
+	if len(hits) > 0 {
+		fused = fuseMatches(vectorMatches, keywordMatches, hits, vectorWeight, keywordWeight, config.Retrieval.RRFK, limit)
+	}
+
+	if !options.Debug {
+		return fused, nil
+	}
+	return fused, &RetrievalDebug{
+		VectorWeight:  vectorWeight,
+		KeywordWeight: keywordWeight,
+		Vector:        rankedMatches(vectorMatches),
+		Keyword:       rankedHits(hits),
+		Fused:         rankedMatches(fused),
+	}
+}
+
+// fuseMatches scores the commits of both lists by reciprocal-rank fusion:
+// the weighted sum of 1/(k+rank) over the lists a commit is in. The scores
+// are normalised, so a commit ranking first in both lists scores 1. The
+// chunks of a commit share its score.
+func fuseMatches(vectorMatches, keywordMatches []Match, hits []keywordHit, vectorWeight, keywordWeight float64, k, limit int) []Match {
+	scores := map[string]float64{}
+	rank := 0
+	for _, match := range vectorMatches {
+		key := matchKey(match)
+		if _, ok := scores[key]; ok {
+			continue
+		}
+		rank++
+		scores[key] = vectorWeight / float64(k+rank)
+	}
+	for i, hit := range hits {
+		scores[hit.Entry] += keywordWeight / float64(k+i+1)
+	}
+	best := (vectorWeight + keywordWeight) / float64(k+1)
+
+	fused := make([]Match, 0, len(vectorMatches)+len(keywordMatches))
+	for _, match := range append(append([]Match{}, vectorMatches...), keywordMatches...) {
+		match.Score = scores[matchKey(match)] / best
+		fused = append(fused, match)
+	}
+	sort.SliceStable(fused, func(i, j int) bool {
+		return fused[i].Score > fused[j].Score
+	})
+	if len(fused) > limit {
+		fused = fused[:limit]
+	}
+	return fused
+}
+
+// matchKey identifies the commit of a match by its ledger entry, or by the
+// vector for vectors indexed without their repository.
+func matchKey(match Match) string {
+	if match.RepoID != "" && match.SHA != "" {
+		return ledgerID(match.RepoID, match.SHA)
+	}
+	return match.ID
+}
+
+func splitLedgerID(id string) (repoID, sha string) {
+	i := strings.LastIndex(id, ":")
+	if i < 0 {
+		return "", id
+	}
+	return id[:i], id[i+1:]
+}
+
+// fetchKeywordMatches fetches the vectors of the keyword hits missing from
+// the vector matches, one per commit, and applies the filter to them.
+func fetchKeywordMatches(ctx context.Context, pineconeClient *PineconeClient, vectorMatches []Match, hits []keywordHit, filter RetrievalFilter) ([]Match, error) {
+	known := map[string]bool{}
+	for _, match := range vectorMatches {
+		known[matchKey(match)] = true
+	}
+	var entries []string
+	for _, hit := range hits {
+		if !known[hit.Entry] {
+			entries = append(entries, hit.Entry)
+		}
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	cursor, err := commitCol.Find(ctx, bson.M{"_id": bson.M{"$in": entries}}, options.Find().SetProjection(bson.M{"vector_ids": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up commits: %w", err)
+	}
+	var ledgerEntries []struct {
+		ID        string   `bson:"_id"`
+		VectorIDs []string `bson:"vector_ids"`
+	}
+	if err := cursor.All(ctx, &ledgerEntries); err != nil {
+		return nil, fmt.Errorf("failed to decode commits: %w", err)
+	}
+
+	entryOf := map[string]string{}
+	var ids []string
+	for _, entry := range ledgerEntries {
+		if len(entry.VectorIDs) > 0 {
+			entryOf[entry.VectorIDs[0]] = entry.ID
+			ids = append(ids, entry.VectorIDs[0])
+		}
+	}
+
+	fetched, err := pineconeClient.FetchVectors(ctx, generations.active(), ids)
+	if err != nil {
+		return nil, err
+	}
+	matches := make([]Match, 0, len(fetched))
+	for _, match := range fetched {
+		if match.RepoID == "" || match.SHA == "" {
+			match.RepoID, match.SHA = splitLedgerID(entryOf[match.ID])
+		}
+		if filter.accepts(match) {
+			matches = append(matches, match)
+		}
+	}
+	return matches, nil
+}
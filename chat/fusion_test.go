@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFuseMatchesCombinesRankings(t *testing.T) {
+	vectorMatches := []Match{
+		{ID: "a-0", RepoID: "api", SHA: "a", Score: 0.9},
+		{ID: "a-1", RepoID: "api", SHA: "a", Score: 0.88},
+		{ID: "b", RepoID: "api", SHA: "b", Score: 0.8},
+		{ID: "legacy", Score: 0.7},
+	}
+	keywordMatches := []Match{{ID: "c", RepoID: "api", SHA: "c"}}
+	hits := []keywordHit{{Entry: "api:b", Score: 7}, {Entry: "api:c", Score: 5}}
+
+	fused := fuseMatches(vectorMatches, keywordMatches, hits, 1, 1, 60, 10)
+	require.Equal(t, []string{"b", "a-0", "a-1", "c", "legacy"}, []string{fused[0].ID, fused[1].ID, fused[2].ID, fused[3].ID, fused[4].ID})
+	require.InDelta(t, (1.0/62+1.0/61)/(2.0/61), fused[0].Score, 1e-9)
+	require.Equal(t, fused[1].Score, fused[2].Score)
+	require.Equal(t, 0.9, vectorMatches[0].Score)
+
+	fused = fuseMatches(vectorMatches, keywordMatches, hits, 1, 0, 60, 2)
+	require.Equal(t, []string{"a-0", "a-1"}, []string{fused[0].ID, fused[1].ID})
+}
+
+func TestRetrievalOptionsWeights(t *testing.T) {
+	zero, negative := 0.0, -1.0
+	vector, keyword := RetrievalOptions{}.fusionWeights()
+	require.Equal(t, []float64{1, 1}, []float64{vector, keyword})
+
+	options := RetrievalOptions{Weights: RetrievalWeights{Keyword: &zero}}
+	vector, keyword = options.fusionWeights()
+	require.Equal(t, []float64{1, 0}, []float64{vector, keyword})
+	require.NoError(t, options.validate())
+
+	options.Weights.Vector = &zero
+	require.EqualError(t, options.validate(), "weights must not both be 0")
+	options.Weights.Vector = &negative
+	require.EqualError(t, options.validate(), "weights must not be negative")
+}
+
+func TestRetrievalFilterAcceptsMatches(t *testing.T) {
+	match := Match{Languages: []string{"Go"}, Components: []string{"api"}}
+	require.True(t, RetrievalFilter{}.accepts(match))
+	require.True(t, RetrievalFilter{Languages: []string{"Python", "Go"}}.accepts(match))
+	require.False(t, RetrievalFilter{Languages: []string{"Go"}, Components: []string{"web"}}.accepts(match))
+}
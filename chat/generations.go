@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const generationRefreshInterval = 30 * time.Second
+
+var generations = &generationCache{}
+
+// Generation is the version of the vector index questions are answered
+// from: the model its vectors were embedded with and where they are stored.
+// The indexer switches the active generation when a migration completes.
+type Generation struct {
+	ID        string `bson:"id"`
+	Model     string `bson:"model"`
+	Index     string `bson:"index,omitempty"`
+	Namespace string `bson:"namespace"`
+}
+
+// initialGeneration is read until the index is first migrated: vectors
+// embedded with Ada in the default namespace of PINECONE_API_URL.
+var initialGeneration = Generation{ID: "gen-1", Model: openai.AdaEmbeddingV2.String()}
+
+func (g Generation) embeddingModel() (openai.EmbeddingModel, error) {
+	var model openai.EmbeddingModel
+	if err := model.UnmarshalText([]byte(g.Model)); err != nil || model == openai.Unknown {
+		return model, fmt.Errorf("generation %s uses unknown embedding model %q", g.ID, g.Model)
+	}
+	return model, nil
+}
+
+// generationCache caches the active generation and refreshes it from the
+// database at most once per refresh interval.
+type generationCache struct {
+	mutex    sync.Mutex
+	current  *Generation
+	loadedAt time.Time
+}
+
+func (c *generationCache) active() Generation {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.refresh()
+	if c.current == nil {
+		return initialGeneration
+	}
+	return *c.current
+}
+
+func (c *generationCache) refresh() {
+	if generationCol == nil || time.Since(c.loadedAt) < generationRefreshInterval {
+		return
+	}
+
+	generation, err := loadActiveGeneration(context.Background())
+	if err != nil {
+		logger.Warnw("Failed to load active generation", "error", err)
+	} else {
+		c.current = generation
+	}
+	c.loadedAt = time.Now()
+}
+
+// loadActiveGeneration returns nil if the index was never migrated.
+func loadActiveGeneration(ctx context.Context) (*Generation, error) {
+	var state struct {
+		Active Generation `bson:"active"`
+	}
+	err := generationCol.FindOne(ctx, bson.M{"_id": "index"}).Decode(&state)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &state.Active, nil
+}
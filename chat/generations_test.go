@@ -0,0 +1,20 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/require"
+)
+
+func TestActiveGenerationDefaultsToInitial(t *testing.T) {
+	generation := (&generationCache{}).active()
+	require.Equal(t, initialGeneration, generation)
+
+	model, err := generation.embeddingModel()
+	require.NoError(t, err)
+	require.Equal(t, openai.AdaEmbeddingV2, model)
+
+	_, err = Generation{ID: "gen-2", Model: "no-such-model"}.embeddingModel()
+	require.Error(t, err)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// BM25 parameters: how quickly repeated terms saturate and how much long
+// commits are penalised.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// maxTermPostings bounds the postings read per term. Terms in more commits
+// are too common to rank by and are left out of the search, so a question
+// made of common words cannot read the whole index.
+const maxTermPostings = 5000
+
+// maxQuestionTerms bounds the terms searched for a question, as every term
+// is a query of its own.
+const maxQuestionTerms = 32
+
+// keywordStatsID is the ID of the stats document the indexer keeps for the
+// keyword index.
+const keywordStatsID = "corpus"
+
+// keywordHit is a commit found by the keyword index, identified by its
+// entry in the commits ledger.
+type keywordHit struct {
+	Entry string
+	Score float64
+}
+
+type keywordPosting struct {
+	Term   string `bson:"term"`
+	Entry  string `bson:"entry"`
+	TF     int    `bson:"tf"`
+	Length int    `bson:"length"`
+}
+
+var keywordTermRE = regexp.MustCompile(`[\p{L}\p{N}_]+(?:[.\-][\p{L}\p{N}_]+)*`)
+
+var keywordStopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "by": true,
+	"for": true, "from": true, "if": true, "in": true, "is": true, "it": true, "of": true, "on": true,
+	"or": true, "the": true, "this": true, "to": true, "with": true,
+}
+
+// keywordTerms tokenizes a question into the distinct terms of the keyword
+// index. It must tokenize like the indexer, which keeps dotted and dashed
+// names whole and also indexes their parts.
+func keywordTerms(text string) []string {
+	var terms []string
+	seen := map[string]bool{}
+	add := func(term string) {
+		if len(term) >= 2 && len(term) <= 64 && !keywordStopWords[term] && !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+
+	for _, token := range keywordTermRE.FindAllString(strings.ToLower(text), -1) {
+		add(token)
+		if strings.ContainsAny(token, ".-") {
+			for _, part := range strings.FieldsFunc(token, func(r rune) bool { return r == '.' || r == '-' }) {
+				add(part)
+			}
+		}
+	}
+	return terms
+}
+
+// searchKeywords returns the limit commits ranking best by BM25 for a
+// question, or nothing if there is no keyword index.
+func searchKeywords(ctx context.Context, question string, limit int) ([]keywordHit, error) {
+	terms := keywordTerms(question)
+	if keywordCol == nil || len(terms) == 0 {
+		return nil, nil
+	}
+	if len(terms) > maxQuestionTerms {
+		terms = terms[:maxQuestionTerms]
+	}
+
+	var stats struct {
+		Documents int `bson:"documents"`
+		Length    int `bson:"length"`
+	}
+	err := keywordStatsCol.FindOne(ctx, bson.M{"_id": keywordStatsID}).Decode(&stats)
+	if err == mongo.ErrNoDocuments || (err == nil && stats.Documents <= 0) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyword stats: %w", err)
+	}
+
+	// The postings are read term by term and completely, so the document
+	// frequency of every term is exact.
+	var postings []keywordPosting
+	for _, term := range terms {
+		cursor, err := keywordCol.Find(ctx, bson.M{"term": term}, options.Find().SetLimit(maxTermPostings+1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to search keywords: %w", err)
+		}
+		var termPostings []keywordPosting
+		if err := cursor.All(ctx, &termPostings); err != nil {
+			return nil, fmt.Errorf("failed to decode keyword postings: %w", err)
+		}
+		if len(termPostings) > maxTermPostings {
+			loggerFrom(ctx).Debugw("Leaving out common keyword", "term", term)
+			continue
+		}
+		postings = append(postings, termPostings...)
+	}
+
+	return bm25(postings, stats.Documents, float64(stats.Length)/float64(stats.Documents), limit), nil
+}
+
+// bm25 scores the commits of the postings of the question's terms, best
+// first. The postings of a term must be complete, since its document
+// frequency is counted from them. Ties are broken by entry, so the ranking
+// is stable.
+func bm25(postings []keywordPosting, documents int, averageLength float64, limit int) []keywordHit {
+	frequency := map[string]int{}
+	for _, posting := range postings {
+		frequency[posting.Term]++
+	}
+
+	scores := map[string]float64{}
+	for _, posting := range postings {
+		df := float64(frequency[posting.Term])
+		idf := math.Log(1 + (float64(documents)-df+0.5)/(df+0.5))
+		tf := float64(posting.TF)
+		norm := 1 - bm25B
+		if averageLength > 0 {
+			norm += bm25B * float64(posting.Length) / averageLength
+		}
+		scores[posting.Entry] += idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+	}
+
+	hits := make([]keywordHit, 0, len(scores))
+	for entry, score := range scores {
+		hits = append(hits, keywordHit{Entry: entry, Score: score})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Entry < hits[j].Entry
+	})
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeywordTermsOfQuestion(t *testing.T) {
+	require.Equal(t,
+		[]string{"who", "changed", "pinecone.top_k", "pinecone", "top_k", "err-conn-42", "err", "conn", "42"},
+		keywordTerms("Who changed pinecone.top_k and ERR-CONN-42, and top_k?"))
+}
+
+func TestBM25RanksRareTermsAndShortCommitsFirst(t *testing.T) {
+	postings := []keywordPosting{
+		{Term: "retry", Entry: "api:a", TF: 1, Length: 100},
+		{Term: "retry", Entry: "api:b", TF: 1, Length: 10},
+		{Term: "retry", Entry: "api:c", TF: 1, Length: 10},
+		{Term: "errtimeout", Entry: "api:c", TF: 2, Length: 10},
+		{Term: "retry", Entry: "api:d", TF: 1, Length: 10},
+	}
+
+	hits := bm25(postings, 10, 20, 3)
+	require.Len(t, hits, 3)
+	require.Equal(t, []string{"api:c", "api:b", "api:d"}, []string{hits[0].Entry, hits[1].Entry, hits[2].Entry})
+	require.Equal(t, hits[1].Score, hits[2].Score)
+	require.Greater(t, hits[0].Score, hits[1].Score)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Layouts of the web URLs of the repository hosts the links are built for.
+const (
+	hostGitHub      = "github"
+	hostGitLab      = "gitlab"
+	hostAzureDevOps = "azuredevops"
+	hostBitbucket   = "bitbucket"
+)
+
+// LinkTemplates build the web URLs of commits and files on a host. {repo} is
+// replaced by the web URL of the repository, {sha} by a commit, {path} by a
+// file, {ref} by the branch or commit of the file, and {version} by the
+// Azure DevOps form of the ref: GB followed by a branch or GC by a commit.
+type LinkTemplates struct {
+	Commit string `yaml:"commit"`
+	File   string `yaml:"file"`
+}
+
+var hostLayouts = map[string]LinkTemplates{
+	hostGitHub:      {Commit: "{repo}/commit/{sha}", File: "{repo}/blob/{ref}/{path}"},
+	hostGitLab:      {Commit: "{repo}/-/commit/{sha}", File: "{repo}/-/blob/{ref}/{path}"},
+	hostAzureDevOps: {Commit: "{repo}/commit/{sha}", File: "{repo}?path=/{path}&version={version}"},
+	hostBitbucket:   {Commit: "{repo}/commits/{sha}", File: "{repo}/src/{ref}/{path}"},
+}
+
+// knownHosts maps the public hosts to their layout.
+var knownHosts = map[string]string{
+	"github.com":    hostGitHub,
+	"gitlab.com":    hostGitLab,
+	"dev.azure.com": hostAzureDevOps,
+	"bitbucket.org": hostBitbucket,
+}
+
+var (
+	// scpLikeRE matches SSH clone URLs such as git@github.com:owner/repo.git.
+	scpLikeRE = regexp.MustCompile(`^(?:[^@/]+@)?([^:/]+):(.+)$`)
+	// azureSSHRE matches the SSH paths of Azure DevOps, v3/org/project/repo.
+	azureSSHRE = regexp.MustCompile(`^v3/([^/]+)/([^/]+)/([^/]+)$`)
+	commitRE   = regexp.MustCompile(`^[0-9a-f]{40}$`)
+)
+
+// commitURL returns the web URL of a commit, or "" if the host of the
+// repository is unknown.
+func commitURL(repoURL, sha string) string {
+	if sha == "" {
+		return ""
+	}
+	return buildLink(repoURL, func(t LinkTemplates) string { return t.Commit }, map[string]string{"{sha}": sha})
+}
+
+// fileURL returns the web URL of a file at a branch or commit, or "" if the
+// host of the repository is unknown.
+func fileURL(repoURL, ref, path string) string {
+	if ref == "" || path == "" {
+		return ""
+	}
+	version := "GB" + ref
+	if commitRE.MatchString(ref) {
+		version = "GC" + ref
+	}
+	return buildLink(repoURL, func(t LinkTemplates) string { return t.File }, map[string]string{
+		"{ref}":     ref,
+		"{path}":    strings.TrimPrefix(path, "/"),
+		"{version}": version,
+	})
+}
+
+// buildLink fills in a template of the host of a repository. Values are
+// escaped for the part of the URL they end up in, path or query, one path
+// segment at a time, so the slashes of paths and branches are kept.
+func buildLink(repoURL string, template func(LinkTemplates) string, values map[string]string) string {
+	host, web := webRepoURL(repoURL)
+	templates, ok := linkTemplates(host)
+	if !ok || template(templates) == "" {
+		return ""
+	}
+
+	path, query, hasQuery := strings.Cut(template(templates), "?")
+	link := fillLink(path, web, values, url.PathEscape)
+	if hasQuery {
+		link += "?" + fillLink(query, web, values, url.QueryEscape)
+	}
+	return link
+}
+
+func fillLink(template, web string, values map[string]string, escape func(string) string) string {
+	link := strings.ReplaceAll(template, "{repo}", web)
+	for placeholder, value := range values {
+		segments := strings.Split(value, "/")
+		for i, segment := range segments {
+			segments[i] = escape(segment)
+		}
+		link = strings.ReplaceAll(link, placeholder, strings.Join(segments, "/"))
+	}
+	return link
+}
+
+// linkTemplates returns the templates of a host: those configured for it,
+// else those of its layout.
+func linkTemplates(host string) (LinkTemplates, bool) {
+	if configured, ok := config.Links.Hosts[host]; ok {
+		templates := hostLayouts[configured.Layout]
+		if configured.Commit != "" {
+			templates.Commit = configured.Commit
+		}
+		if configured.File != "" {
+			templates.File = configured.File
+		}
+		return templates, true
+	}
+
+	layout, ok := knownHosts[host]
+	if !ok && strings.HasSuffix(host, ".visualstudio.com") {
+		layout, ok = hostAzureDevOps, true
+	}
+	return hostLayouts[layout], ok
+}
+
+// webRepoURL turns a clone URL into the host and the web URL of the
+// repository. Credentials, ports of SSH URLs and the .git suffix are dropped.
+func webRepoURL(repoURL string) (host, web string) {
+	repoURL = strings.TrimSpace(repoURL)
+	if !strings.Contains(repoURL, "://") {
+		match := scpLikeRE.FindStringSubmatch(repoURL)
+		if match == nil {
+			return "", ""
+		}
+		repoURL = "ssh://" + match[1] + "/" + match[2]
+	}
+
+	u, err := url.Parse(repoURL)
+	if err != nil || u.Host == "" {
+		return "", ""
+	}
+	host = strings.ToLower(u.Hostname())
+	path := strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git")
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		// Azure DevOps serves SSH from other hosts and paths than the web.
+		if match := azureSSHRE.FindStringSubmatch(path); match != nil {
+			switch {
+			case host == "ssh.dev.azure.com":
+				host = "dev.azure.com"
+				path = match[1] + "/" + match[2] + "/_git/" + match[3]
+			case host == "vs-ssh.visualstudio.com":
+				host = match[1] + ".visualstudio.com"
+				path = match[2] + "/_git/" + match[3]
+			}
+		}
+		return host, "https://" + host + "/" + path
+	}
+
+	u.User = nil
+	u.Path = "/" + path
+	u.RawPath = ""
+	u.RawQuery = ""
+	u.Fragment = ""
+	return host, u.String()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// correlationIDHeader lets clients pass the ID their request is logged
+// under. Requests without one get a generated ID, returned in the response.
+const correlationIDHeader = "X-Correlation-ID"
+
+// logLevel is the minimum level of the logger, set from the configuration
+// once it is loaded.
+var logLevel = zap.NewAtomicLevel()
+
+// logger writes JSON lines to stderr.
+var logger = newLogger()
+
+func newLogger() *zap.SugaredLogger {
+	zapConfig := zap.NewProductionConfig()
+	zapConfig.EncoderConfig.TimeKey = "time"
+	zapConfig.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	zapConfig.Level = logLevel
+
+	l, err := zapConfig.Build()
+	if err != nil {
+		panic(err)
+	}
+	return l.Sugar()
+}
+
+// setLogLevel sets the minimum level of the logger. Unknown levels are
+// rejected when the configuration is validated and ignored here.
+func setLogLevel(name string) {
+	if level, err := zapcore.ParseLevel(name); err == nil {
+		logLevel.SetLevel(level)
+	}
+}
+
+type loggerKey struct{}
+
+// loggerFrom returns the logger of the request running in ctx, which
+// carries its correlation ID, or the global logger outside of a request.
+func loggerFrom(ctx context.Context) *zap.SugaredLogger {
+	if l, ok := ctx.Value(loggerKey{}).(*zap.SugaredLogger); ok {
+		return l
+	}
+	return logger
+}
+
+func newCorrelationID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// correlateRequests starts the span of a request, attaches a logger carrying
+// its correlation ID and logs the request once it completed.
+func correlateRequests(c *gin.Context) {
+	start := time.Now()
+	correlationID := c.GetHeader(correlationIDHeader)
+	if correlationID == "" {
+		correlationID = newCorrelationID()
+	}
+	c.Header(correlationIDHeader, correlationID)
+
+	ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+	ctx, span := tracer.Start(ctx, c.Request.Method+" "+c.Request.URL.Path, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+		attribute.String("correlation.id", correlationID),
+	))
+	defer span.End()
+
+	log := logger.With("correlation_id", correlationID)
+	c.Request = c.Request.WithContext(context.WithValue(ctx, loggerKey{}, log))
+	c.Next()
+
+	span.SetAttributes(attribute.Int("http.status_code", c.Writer.Status()))
+	log.Infow("Handled request",
+		"method", c.Request.Method,
+		"path", c.Request.URL.Path,
+		"status", c.Writer.Status(),
+		"duration", time.Since(start).Seconds(),
+	)
+}
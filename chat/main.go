@@ -1,33 +1,90 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 	"os"
+	"strings"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sashabaranov/go-openai"
 )
 
 // add main method
 func main() {
+	var err error
+	config, err = loadConfig()
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1:]); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := config.validate(); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	setLogLevel(config.LogLevel)
+	defer logger.Sync()
+
+	shutdownTracing, err := initTracing(context.Background())
+	if err != nil {
+		logger.Fatalw("Error initialising tracing", "error", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	initDatabase()
+	setBlockedUsers(retrieveAndCacheBlockedUserList())
 	router := SetupRouter()
-	router.Run()
+	if err := router.Run(":" + config.Server.Port); err != nil {
+		logger.Errorw("Error serving HTTP", "error", err)
+	}
+}
+
+const usage = `Usage:
+  chat               serve the chat API
+  chat config print  print the effective configuration, secrets redacted`
+
+// runCommand runs the command given on the command line. The only one is
+// config print, which prints the configuration and then reports what is
+// wrong with it.
+func runCommand(args []string) error {
+	if len(args) != 2 || args[0] != "config" || args[1] != "print" {
+		return fmt.Errorf("unknown command %q\n%s", strings.Join(args, " "), usage)
+	}
+	if err := printConfig(os.Stdout, config); err != nil {
+		return err
+	}
+	return config.validate()
 }
 
 // SetupRouter configures the router for API endpoints
 func SetupRouter() *gin.Engine {
-	router := gin.Default()
+	router := gin.New()
+	router.Use(gin.Recovery(), correlateRequests)
 
 	// Initialize OpenAIClient and PineconeClient
-	openaiClient := NewOpenAIClient(os.Getenv("OPEN_AI_KEY"))
-	pineconeClient := NewPineconeClient(os.Getenv("PINECONE_API_URL"), os.Getenv("PINECONE_API_KEY"))
+	openaiClient := NewOpenAIClient(config.OpenAI.APIKey)
+	pineconeClient := NewPineconeClient(config.Pinecone.APIURL, config.Pinecone.APIKey)
+
+	router.Use(observeRequests)
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
 
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{"GET", "POST", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowMethods:     []string{"GET", "POST", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Content-Type", correlationIDHeader, clientIDHeader},
+		ExposeHeaders:    []string{"Content-Length", correlationIDHeader},
 		AllowCredentials: true,
 		AllowOriginFunc: func(origin string) bool {
 			return true
@@ -35,32 +92,86 @@ func SetupRouter() *gin.Engine {
 	}))
 
 	router.POST("/api/conversation", func(c *gin.Context) {
-		var requestBody ConversationRequest
-		if err := c.BindJSON(&requestBody); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		requestBody, session, ok := bindConversation(c)
+		if !ok {
+			return
+		}
+
+		if wantsEventStream(c) {
+			streamConversation(c, openaiClient, pineconeClient, requestBody, session)
 			return
 		}
 
-		response, err := ProcessConversation(openaiClient, pineconeClient, requestBody.UserMessage, requestBody.Messages)
+		result, err := ProcessConversation(c.Request.Context(), openaiClient, pineconeClient, requestBody.UserMessage, requestBody.Messages, requestBody.RetrievalOptions)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		response := result.Response
 
-		messages := requestBody.Messages
-		messages = append(messages, openai.ChatCompletionMessage{
-			Role:    "user",
-			Content: requestBody.UserMessage,
-		}, openai.ChatCompletionMessage{
-			Role:    "assistant",
-			Content: response,
-		})
-
-		c.JSON(http.StatusOK, gin.H{
-			"response": response,
-			"messages": messages,
-		})
+		if session != nil {
+			err = sessions.appendExchange(c.Request.Context(), session, requestBody.UserMessage, response)
+			if !sessionOK(c, err) {
+				return
+			}
+		}
+
+		body := gin.H{
+			"response":  response,
+			"messages":  appendExchange(requestBody.Messages, requestBody.UserMessage, response),
+			"owners":    result.Owners,
+			"experts":   result.Experts,
+			"sessionId": requestBody.SessionID,
+		}
+		if result.RewrittenQuery != "" {
+			body["rewrittenQuery"] = result.RewrittenQuery
+		}
+		if result.Retrieval != nil {
+			body["retrieval"] = result.Retrieval
+		}
+		c.JSON(http.StatusOK, body)
+	})
+
+	router.POST("/api/conversation/stream", func(c *gin.Context) {
+		requestBody, session, ok := bindConversation(c)
+		if !ok {
+			return
+		}
+
+		streamConversation(c, openaiClient, pineconeClient, requestBody, session)
 	})
 
+	registerSessionRoutes(router)
+	registerExpertRoutes(router, openaiClient, pineconeClient)
+
 	return router
 }
+
+// bindConversation reads a conversation request and the session it
+// continues, if any. It responds with an error if either is invalid.
+func bindConversation(c *gin.Context) (ConversationRequest, *Session, bool) {
+	var request ConversationRequest
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return request, nil, false
+	}
+	if err := request.RetrievalOptions.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return request, nil, false
+	}
+
+	session, ok := loadSession(c, &request)
+	return request, session, ok
+}
+
+// appendExchange returns the messages of a conversation followed by the
+// user message and the answer to it.
+func appendExchange(messages []openai.ChatCompletionMessage, userMessage, response string) []openai.ChatCompletionMessage {
+	return append(messages, openai.ChatCompletionMessage{
+		Role:    "user",
+		Content: userMessage,
+	}, openai.ChatCompletionMessage{
+		Role:    "assistant",
+		Content: response,
+	})
+}
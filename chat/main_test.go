@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"testing"
@@ -19,11 +20,11 @@ func TestChat(t *testing.T) {
 	userMessage := "Who can help me with AKS?"
 	messages := []openai.ChatCompletionMessage{}
 
-	gptResponse, err := ProcessConversation(openaiClient, pineconeClient, userMessage, messages)
+	result, err := ProcessConversation(context.Background(), openaiClient, pineconeClient, userMessage, messages, RetrievalOptions{})
 	if err != nil {
 		fmt.Printf("Error processing conversation: %v\n", err)
 		return
 	}
 
-	fmt.Printf("Bot: %s\n", gptResponse)
+	fmt.Printf("Bot: %s\n", result.Response)
 }
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "chat_request_duration_seconds",
+		Help:    "Latency of chat API requests, by route and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "code"})
+
+	stageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "chat_stage_duration_seconds",
+		Help:    "Latency of the stages of a conversation: rewrite, embed, retrieve, rerank and complete.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"stage"})
+
+	tokensTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_tokens_total",
+		Help: "OpenAI tokens consumed, by kind: embedding, rewrite, rerank, prompt or completion.",
+	}, []string{"kind"})
+
+	retrievalsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chat_retrievals_total",
+		Help: "Retrievals from Pinecone, by result: empty or matched.",
+	}, []string{"result"})
+)
+
+// observeRequests records the latency of every request handled by the router.
+func observeRequests(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	requestDuration.WithLabelValues(route, strconv.Itoa(c.Writer.Status())).Observe(time.Since(start).Seconds())
+}
+
+func observeStage(stage string, start time.Time) {
+	stageDuration.WithLabelValues(stage).Observe(time.Since(start).Seconds())
+}
+
+func observeRetrieval(matches []Match) {
+	if len(matches) == 0 {
+		retrievalsTotal.WithLabelValues("empty").Inc()
+		return
+	}
+	retrievalsTotal.WithLabelValues("matched").Inc()
+}
@@ -2,9 +2,13 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 
 	"github.com/sashabaranov/go-openai"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type OpenAIClient struct {
@@ -17,8 +21,10 @@ func NewOpenAIClient(apiKey string) *OpenAIClient {
 	}
 }
 
-func (client *OpenAIClient) RequestEmbeddings(embeddingReq openai.EmbeddingRequest) (*openai.EmbeddingResponse, error) {
-	response, err := client.Client.CreateEmbeddings(context.Background(), embeddingReq)
+func (client *OpenAIClient) RequestEmbeddings(ctx context.Context, embeddingReq openai.EmbeddingRequest) (*openai.EmbeddingResponse, error) {
+	ctx, span := tracer.Start(ctx, "openai.embeddings", trace.WithAttributes(attribute.String("openai.model", embeddingReq.Model.String())))
+	response, err := client.Client.CreateEmbeddings(ctx, embeddingReq)
+	endSpan(span, err)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate embeddings from OpenAI: %w", err)
 	}
@@ -27,17 +33,10 @@ func (client *OpenAIClient) RequestEmbeddings(embeddingReq openai.EmbeddingReque
 	return &response, nil
 }
 
-func (c *OpenAIClient) CreateChatCompletion(messages []openai.ChatCompletionMessage) (*openai.ChatCompletionResponse, error) {
-	resp, err := c.Client.CreateChatCompletion(
-		context.Background(),
-		openai.ChatCompletionRequest{
-			Model:       openai.GPT3Dot5Turbo,
-			Messages:    messages,
-			MaxTokens:   1024,
-			Temperature: 0.3,
-			N:           1,
-		},
-	)
+func (c *OpenAIClient) CreateChatCompletion(ctx context.Context, messages []openai.ChatCompletionMessage) (*openai.ChatCompletionResponse, error) {
+	ctx, span := tracer.Start(ctx, "openai.chat_completion", trace.WithAttributes(attribute.String("openai.model", openai.GPT3Dot5Turbo)))
+	resp, err := c.Client.CreateChatCompletion(ctx, chatCompletionRequest(messages))
+	endSpan(span, err)
 	if err != nil {
 		return nil, err
 	}
@@ -45,13 +44,61 @@ func (c *OpenAIClient) CreateChatCompletion(messages []openai.ChatCompletionMess
 	return &resp, nil
 }
 
+// StreamChatCompletion streams a chat completion and passes every piece of
+// content to onDelta as it arrives. It stops when onDelta fails or ctx is
+// cancelled, which closes the upstream request. It returns the number of
+// chunks received; streamed responses do not report their usage.
+func (c *OpenAIClient) StreamChatCompletion(ctx context.Context, messages []openai.ChatCompletionMessage, onDelta func(string) error) (chunks int, err error) {
+	ctx, span := tracer.Start(ctx, "openai.chat_completion", trace.WithAttributes(
+		attribute.String("openai.model", openai.GPT3Dot5Turbo),
+		attribute.Bool("openai.stream", true),
+	))
+	defer func() { endSpan(span, err) }()
+
+	request := chatCompletionRequest(messages)
+	request.Stream = true
+	stream, err := c.Client.CreateChatCompletionStream(ctx, request)
+	if err != nil {
+		return 0, err
+	}
+	defer stream.Close()
+
+	for {
+		response, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return chunks, nil
+		}
+		if err != nil {
+			return chunks, err
+		}
+		chunks++
+
+		if len(response.Choices) == 0 || response.Choices[0].Delta.Content == "" {
+			continue
+		}
+		if err := onDelta(response.Choices[0].Delta.Content); err != nil {
+			return chunks, err
+		}
+	}
+}
+
+func chatCompletionRequest(messages []openai.ChatCompletionMessage) openai.ChatCompletionRequest {
+	return openai.ChatCompletionRequest{
+		Model:       openai.GPT3Dot5Turbo,
+		Messages:    messages,
+		MaxTokens:   config.OpenAI.MaxTokens,
+		Temperature: float32(config.OpenAI.Temperature),
+		N:           1,
+	}
+}
+
 func transformToPineconeVectors(embeddings []openai.Embedding) []float32 {
 	return embeddings[0].Embedding
 }
 
-func createEmbeddingRequest(input string) openai.EmbeddingRequest {
+func createEmbeddingRequest(model openai.EmbeddingModel, input string) openai.EmbeddingRequest {
 	return openai.EmbeddingRequest{
 		Input: []string{input},
-		Model: openai.AdaEmbeddingV2,
+		Model: model,
 	}
 }
@@ -6,9 +6,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
-	"os"
+	"net/url"
 	"regexp"
 	"strings"
 )
@@ -25,10 +24,32 @@ func NewPineconeClient(apiURL, apiKey string) *PineconeClient {
 	}
 }
 
-var blockedUsers = retrieveAndCacheBlockedUserList()
+// blockedUsers are never suggested. They are loaded once at startup.
+// blockedPseudonyms holds the pseudonyms of the blocked email addresses, so
+// contributors are still recognised in vectors masked at index time.
+var (
+	blockedUsers      []string
+	blockedPseudonyms map[string]bool
+)
+
+// setBlockedUsers replaces the blocked users, ignoring blank lines.
+func setBlockedUsers(users []string) {
+	blockedUsers = nil
+	blockedPseudonyms = map[string]bool{}
+	for _, user := range users {
+		user = strings.TrimSpace(user)
+		if user == "" {
+			continue
+		}
+		blockedUsers = append(blockedUsers, user)
+		if pseudonym := pseudonymise(user); emailAddressRE.MatchString(user) && pseudonym != withheldContact {
+			blockedPseudonyms[pseudonym] = true
+		}
+	}
+}
 
 func retrieveAndCacheBlockedUserList() []string {
-	url := os.Getenv("BLOCKED_LIST_URL")
+	url := config.Pinecone.BlockedListURL
 
 	if url == "" {
 		return []string{}
@@ -36,13 +57,13 @@ func retrieveAndCacheBlockedUserList() []string {
 
 	resp, err := http.Get(url)
 	if err != nil {
-		log.Fatalf("Error making request: %v", err)
+		logger.Fatalw("Error fetching blocked user list", "error", err)
 	}
 	defer resp.Body.Close()
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		log.Fatalf("Error reading body: %v", err)
+		logger.Fatalw("Error reading blocked user list", "error", err)
 	}
 
 	sb := string(body)
@@ -51,21 +72,60 @@ func retrieveAndCacheBlockedUserList() []string {
 	return s
 }
 
-func (client *PineconeClient) QueryPinecone(query []float32) (string, error) {
-	apiURL := fmt.Sprintf("%s/query", client.APIURL)
+// Match is a commit retrieved from Pinecone.
+type Match struct {
+	ID        string
+	Score     float64
+	Text      string
+	RepoID    string
+	RepoURL   string
+	SHA       string
+	URL       string // of the commit, or of the file of a document
+	Person    string
+	Author    string
+	Contact   string
+	Timestamp int64
+	Files     []string
+
+	Languages  []string
+	Components []string
+
+	// RetrievalScore is the score a reranker replaced, if any.
+	RetrievalScore float64
+
+	// blocked is set for commits of blocked users, which are dropped.
+	blocked bool
+}
+
+// QueryPinecone returns the topK vectors of a generation closest to the query.
+func (client *PineconeClient) QueryPinecone(ctx context.Context, generation Generation, query []float32, filter map[string]interface{}, topK int) (_ []Match, err error) {
+	ctx, span := tracer.Start(ctx, "pinecone/query")
+	defer func() { endSpan(span, err) }()
 
-	requestBody, err := json.Marshal(map[string]interface{}{
+	apiURL := client.APIURL
+	if generation.Index != "" {
+		apiURL = generation.Index
+	}
+	apiURL = fmt.Sprintf("%s/query", apiURL)
+
+	request := map[string]interface{}{
 		"vector":          query,
-		"top_k":           10,
+		"top_k":           topK,
 		"includeMetadata": true,
-	})
+		"namespace":       generation.Namespace,
+	}
+	if filter != nil {
+		request["filter"] = filter
+	}
+
+	requestBody, err := json.Marshal(request)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal JSON: %w", err)
+		return nil, fmt.Errorf("failed to marshal JSON: %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(context.Background(), "POST", apiURL, bytes.NewBuffer(requestBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", apiURL, bytes.NewBuffer(requestBody))
 	if err != nil {
-		return "", fmt.Errorf("failed to create POST request: %w", err)
+		return nil, fmt.Errorf("failed to create POST request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Api-Key", client.APIKey)
@@ -73,60 +133,219 @@ func (client *PineconeClient) QueryPinecone(query []float32) (string, error) {
 	httpClient := &http.Client{}
 	resp, err := httpClient.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to send POST request: %w", err)
+		return nil, fmt.Errorf("failed to send POST request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := ioutil.ReadAll(resp.Body)
-		return "", fmt.Errorf("failed to query Pinecone, status code: %d, response: %s", resp.StatusCode, string(body))
+		return nil, fmt.Errorf("failed to query Pinecone, status code: %d, response: %s", resp.StatusCode, string(body))
 	}
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("failed to read Pinecone response: %w", err)
+		return nil, fmt.Errorf("failed to read Pinecone response: %w", err)
 	}
 
-	var result map[string]interface{}
-	json.Unmarshal(body, &result)
+	var result struct {
+		Matches []struct {
+			ID       string                 `json:"id"`
+			Score    float64                `json:"score"`
+			Metadata map[string]interface{} `json:"metadata"`
+		} `json:"matches"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to decode Pinecone response: %w", err)
+	}
 
-	matches := result["matches"].([]interface{})
-	matchOutput := ""
-	authorRE := regexp.MustCompile(`Author:\s(.+)`)
-	emailRE := regexp.MustCompile(`Email:\s(.+)`)
+	matches := make([]Match, 0, len(result.Matches))
+	for _, match := range result.Matches {
+		m := newMatch(match.ID, match.Score, match.Metadata)
+		if !m.blocked {
+			matches = append(matches, m)
+		}
+	}
 
-	for i, match := range matches {
-		match := match.(map[string]interface{})
-		metadata := match["metadata"].(map[string]interface{})
-		text := metadata["text"].(string)
-
-		validUser := true
-		author := authorRE.FindStringSubmatch(text)[1]
-		email := emailRE.FindStringSubmatch(text)[1]
-		if author != "" {
-			for _, blockedUser := range blockedUsers {
-				if strings.HasPrefix(email, blockedUser) || strings.HasPrefix(blockedUser, email) {
-					validUser = false
-				}
-				if strings.HasPrefix(blockedUser, author) || strings.HasPrefix(author, blockedUser) {
-					validUser = false
-				}
-			}
+	return matches, nil
+}
+
+// FetchVectors returns the vectors of a generation with the given IDs as
+// matches scored 0, in no particular order.
+func (client *PineconeClient) FetchVectors(ctx context.Context, generation Generation, ids []string) (_ []Match, err error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	ctx, span := tracer.Start(ctx, "pinecone/fetch")
+	defer func() { endSpan(span, err) }()
+
+	apiURL := client.APIURL
+	if generation.Index != "" {
+		apiURL = generation.Index
+	}
+	query := url.Values{"ids": ids}
+	if generation.Namespace != "" {
+		query.Set("namespace", generation.Namespace)
+	}
+	apiURL = fmt.Sprintf("%s/vectors/fetch?%s", apiURL, query.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GET request: %w", err)
+	}
+	req.Header.Set("Api-Key", client.APIKey)
+
+	httpClient := &http.Client{}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send GET request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Pinecone response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch vectors from Pinecone, status code: %d, response: %s", resp.StatusCode, string(body))
+	}
+
+	var result struct {
+		Vectors map[string]struct {
+			Metadata map[string]interface{} `json:"metadata"`
+		} `json:"vectors"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to decode Pinecone response: %w", err)
+	}
+
+	matches := make([]Match, 0, len(result.Vectors))
+	for id, vector := range result.Vectors {
+		m := newMatch(id, 0, vector.Metadata)
+		if !m.blocked {
+			matches = append(matches, m)
+		}
+	}
+	return matches, nil
+}
+
+var (
+	authorRE = regexp.MustCompile(`Author:\s(.+)`)
+	emailRE  = regexp.MustCompile(`Email:\s(.+)`)
+	shaRE    = regexp.MustCompile(`CommitId:\s*\n?([0-9a-f]{40})`)
+)
+
+// newMatch reads a match from its metadata. Vectors indexed before the
+// metadata fields were introduced only carry the text, so the fields are
+// parsed from it as a fallback. The blocked users are checked before the
+// text is masked, as masking hides the names and addresses they list.
+func newMatch(id string, score float64, metadata map[string]interface{}) Match {
+	text, _ := metadata["text"].(string)
+	if diff, ok := metadata["diff"].(string); ok && diff != "" {
+		text += "\nDiff: " + diff
+	}
+	blocked := isBlockedUser(firstSubmatch(authorRE, text), firstSubmatch(emailRE, text))
+	text = applyPrivacy(text)
+
+	m := Match{
+		ID:      id,
+		Score:   score,
+		Text:    text,
+		RepoID:  stringField(metadata, "repoId"),
+		RepoURL: firstSubmatch(repoURLRE, text),
+		SHA:     stringField(metadata, "sha"),
+		Person:  stringField(metadata, "person"),
+		Author:  firstSubmatch(authorRE, text),
+		Contact: firstSubmatch(emailRE, text),
+		blocked: blocked,
+	}
+	if m.RepoURL == "" {
+		m.RepoURL = stringField(metadata, "repoUrl")
+	}
+	if m.SHA == "" {
+		m.SHA = firstSubmatch(shaRE, text)
+	}
+	if stringField(metadata, "kind") == "document" {
+		m.URL = fileURL(m.RepoURL, stringField(metadata, "branch"), stringField(metadata, "path"))
+	} else {
+		m.URL = commitURL(m.RepoURL, m.SHA)
+	}
+	if timestamp, ok := metadata["timestamp"].(float64); ok {
+		m.Timestamp = int64(timestamp)
+	}
+	m.Files = stringsField(metadata, "files")
+	m.Languages = stringsField(metadata, "languages")
+	m.Components = stringsField(metadata, "components")
+
+	return m
+}
+
+func stringField(metadata map[string]interface{}, key string) string {
+	value, _ := metadata[key].(string)
+	return value
+}
+
+func stringsField(metadata map[string]interface{}, key string) []string {
+	values, _ := metadata[key].([]interface{})
+	var result []string
+	for _, value := range values {
+		if value, ok := value.(string); ok {
+			result = append(result, value)
 		}
+	}
+	return result
+}
 
-		if validUser {
-			output := fmt.Sprintf("\n\n# %d. %s\n", i+1, text)
-			if len(output) > 1100 {
-				output = output[:1100]
-			}
+func isBlockedUser(author, email string) bool {
+	if author == "" {
+		return false
+	}
 
-			matchOutput += output + "\n\n"
+	if blockedPseudonyms[email] {
+		return true
+	}
+	for _, blockedUser := range blockedUsers {
+		if strings.HasPrefix(email, blockedUser) || strings.HasPrefix(blockedUser, email) {
+			return true
+		}
+		if strings.HasPrefix(blockedUser, author) || strings.HasPrefix(author, blockedUser) {
+			return true
 		}
+	}
+	return false
+}
+
+// formatMemory renders matches as the bot memory of the prompt.
+func formatMemory(matches []Match) string {
+	matchOutput := ""
+	for i, match := range matches {
+		output := fmt.Sprintf("\n\n# %d. %s\n", i+1, match.Text)
+		if len(output) > 1100 {
+			output = output[:1100]
+		}
+		if match.URL != "" {
+			output += fmt.Sprintf("\nLink: %s", match.URL)
+		}
+		if len(match.Languages) > 0 {
+			output += fmt.Sprintf("\nLanguages: %s", strings.Join(match.Languages, ", "))
+		}
+		if len(match.Components) > 0 {
+			output += fmt.Sprintf("\nComponents: %s", strings.Join(match.Components, ", "))
+		}
+
+		matchOutput += output + "\n\n"
 
 		if len(matchOutput) > 4500 {
 			break
 		}
 	}
 
-	return matchOutput, nil
+	return matchOutput
+}
+
+func firstSubmatch(re *regexp.Regexp, text string) string {
+	match := re.FindStringSubmatch(text)
+	if match == nil {
+		return ""
+	}
+	return strings.TrimSpace(match[1])
 }
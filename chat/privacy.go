@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+)
+
+// Privacy modes mirror the ones the indexer applies at index time.
+const (
+	privacyEmail     = "email"
+	privacyName      = "name"
+	privacyHandle    = "handle"
+	privacyPseudonym = "pseudonym"
+)
+
+const withheldContact = "withheld"
+
+var (
+	emailAddressRE = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	repoURLRE      = regexp.MustCompile(`Repo-?URL:\s*(\S+)`)
+	authorLineRE   = regexp.MustCompile(`Author:[ \t]*([^\n]*)`)
+	emailLineRE    = regexp.MustCompile(`Email:[ \t]*([^\n]*)`)
+)
+
+// PrivacyPolicy decides which personal data of contributors may be shown.
+type PrivacyPolicy struct {
+	Mode    string            `bson:"mode"`
+	Handles map[string]string `bson:"handles,omitempty"`
+}
+
+// applyPrivacy enforces the privacy policy of the repository a memory entry
+// belongs to. Entries indexed before the policy was configured still carry
+// plain email addresses, so they are masked here as well.
+func applyPrivacy(text string) string {
+	match := repoURLRE.FindStringSubmatch(text)
+	if match == nil {
+		return text
+	}
+
+	repo, ok := repositories.lookup(match[1])
+	if !ok || repo.Privacy == nil || repo.Privacy.Mode == "" || repo.Privacy.Mode == privacyEmail {
+		return text
+	}
+
+	if repo.Privacy.Mode == privacyPseudonym {
+		if email := emailLineRE.FindStringSubmatch(text); email != nil && emailAddressRE.MatchString(email[1]) {
+			pseudonym := pseudonymise(strings.TrimSpace(email[1]))
+			text = authorLineRE.ReplaceAllLiteralString(text, "Author: "+pseudonym)
+		}
+	}
+
+	return emailAddressRE.ReplaceAllStringFunc(text, func(email string) string {
+		return repo.Privacy.contact(email)
+	})
+}
+
+func (p *PrivacyPolicy) contact(email string) string {
+	switch p.Mode {
+	case privacyHandle:
+		if handle, ok := p.Handles[strings.ToLower(email)]; ok {
+			return handle
+		}
+		return withheldContact
+	case privacyPseudonym:
+		return pseudonymise(email)
+	case privacyName:
+		return withheldContact
+	default:
+		return email
+	}
+}
+
+// pseudonymise derives the same stable pseudonym as the indexer does.
+// Without a secret the contact is withheld, as pseudonyms keyed by anything
+// public could be reversed by hashing known addresses.
+func pseudonymise(email string) string {
+	secret := config.Privacy.PseudonymSecret
+	if secret == "" {
+		return withheldContact
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return "contributor-" + hex.EncodeToString(mac.Sum(nil))[:10]
+}
+
+// redactUnknownEmails removes email addresses from a generated answer that
+// do not appear in the memory it was given, so the model cannot surface
+// addresses that were withheld or made up.
+func redactUnknownEmails(answer, memory string) string {
+	allowed := map[string]bool{}
+	for _, email := range emailAddressRE.FindAllString(memory, -1) {
+		allowed[strings.ToLower(email)] = true
+	}
+
+	return emailAddressRE.ReplaceAllStringFunc(answer, func(email string) string {
+		if allowed[strings.ToLower(email)] {
+			return email
+		}
+		return withheldContact
+	})
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyPrivacy(t *testing.T) {
+	repositories.byURL = map[string]RepositorySettings{
+		"https://example.com/name.git": {ID: "name", Privacy: &PrivacyPolicy{Mode: privacyName}},
+		"https://example.com/handle.git": {ID: "handle", Privacy: &PrivacyPolicy{
+			Mode:    privacyHandle,
+			Handles: map[string]string{"jane@example.com": "@jdoe"},
+		}},
+		"https://example.com/pseudonym.git": {ID: "pseudonym", Privacy: &PrivacyPolicy{Mode: privacyPseudonym}},
+	}
+	defer func() { repositories.byURL = nil }()
+
+	text := func(repoURL string) string {
+		return "Author: Jane Doe\nRepoURL:\n" + repoURL + "\nCommit-Message:\nFix\nEmail: jane@example.com\nDiff: "
+	}
+
+	assert.Equal(t, text("https://example.com/open.git"), applyPrivacy(text("https://example.com/open.git")))
+	assert.Contains(t, applyPrivacy(text("https://example.com/name.git")), "Email: withheld")
+	assert.Contains(t, applyPrivacy(text("https://example.com/handle.git")), "Email: @jdoe")
+
+	masked := applyPrivacy(text("https://example.com/pseudonym.git"))
+	assert.Contains(t, masked, "Author: withheld\n")
+	assert.Contains(t, masked, "Email: withheld\n")
+
+	config.Privacy.PseudonymSecret = "a-secret-for-tests"
+	defer func() { config.Privacy.PseudonymSecret = "" }()
+
+	pseudonym := pseudonymise("jane@example.com")
+	masked = applyPrivacy(text("https://example.com/pseudonym.git"))
+	assert.Contains(t, masked, "Author: "+pseudonym+"\n")
+	assert.Contains(t, masked, "Email: "+pseudonym+"\n")
+	assert.NotContains(t, masked, "Jane Doe")
+}
+
+func TestRedactUnknownEmails(t *testing.T) {
+	memory := "Author: Jane Doe\nEmail: jane@example.com"
+	answer := "Ask [Jane](jane@example.com) or [Sam](sam.alias.jones@microsoft.com)."
+
+	assert.Equal(t, "Ask [Jane](jane@example.com) or [Sam](withheld).", redactUnknownEmails(answer, memory))
+}
+
+func TestBlockedUsersAreCheckedBeforeMasking(t *testing.T) {
+	repositories.byURL = map[string]RepositorySettings{
+		"https://example.com/name.git":      {ID: "name", Privacy: &PrivacyPolicy{Mode: privacyName}},
+		"https://example.com/pseudonym.git": {ID: "pseudonym", Privacy: &PrivacyPolicy{Mode: privacyPseudonym}},
+	}
+	config.Privacy.PseudonymSecret = "a-secret-for-tests"
+	defer func() {
+		repositories.byURL = nil
+		config.Privacy.PseudonymSecret = ""
+		setBlockedUsers(nil)
+	}()
+	setBlockedUsers([]string{"jane@example.com", ""})
+
+	text := func(repoURL, author, email string) map[string]interface{} {
+		return map[string]interface{}{"text": "Author: " + author + "\nRepoURL:\n" + repoURL + "\nEmail: " + email}
+	}
+
+	// Masked by the chat service.
+	assert.True(t, newMatch("a", 1, text("https://example.com/pseudonym.git", "Jane Doe", "jane@example.com")).blocked)
+	assert.True(t, newMatch("b", 1, text("https://example.com/name.git", "Jane Doe", "jane@example.com")).blocked)
+	// Masked by the indexer.
+	pseudonym := pseudonymise("jane@example.com")
+	assert.True(t, newMatch("c", 1, text("https://example.com/pseudonym.git", pseudonym, pseudonym)).blocked)
+
+	assert.False(t, newMatch("d", 1, text("https://example.com/pseudonym.git", "Sam Jones", "sam@example.com")).blocked)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const repositoryRefreshInterval = time.Minute
+
+var repositories = &repositoryCache{}
+
+// RepositorySettings holds the parts of a registered repository the chat
+// service needs to answer questions.
+type RepositorySettings struct {
+	ID         string                `bson:"_id"`
+	URL        string                `bson:"url"`
+	Privacy    *PrivacyPolicy        `bson:"privacy,omitempty"`
+	CodeOwners map[string]CodeOwners `bson:"code_owners,omitempty"`
+}
+
+// repositoryCache caches the settings of all repositories by URL and
+// refreshes them from the database at most once per refresh interval.
+type repositoryCache struct {
+	mutex    sync.Mutex
+	byURL    map[string]RepositorySettings
+	loadedAt time.Time
+}
+
+func (c *repositoryCache) lookup(repoURL string) (RepositorySettings, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.refresh()
+	repo, ok := c.byURL[repoURL]
+	return repo, ok
+}
+
+func (c *repositoryCache) all() []RepositorySettings {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.refresh()
+	all := make([]RepositorySettings, 0, len(c.byURL))
+	for _, repo := range c.byURL {
+		all = append(all, repo)
+	}
+	return all
+}
+
+func (c *repositoryCache) refresh() {
+	if repoCol == nil || time.Since(c.loadedAt) < repositoryRefreshInterval {
+		return
+	}
+
+	byURL, err := loadRepositorySettings(context.Background())
+	if err != nil {
+		logger.Warnw("Failed to load repository settings", "error", err)
+	} else {
+		c.byURL = byURL
+	}
+	c.loadedAt = time.Now()
+}
+
+func loadRepositorySettings(ctx context.Context) (map[string]RepositorySettings, error) {
+	cursor, err := repoCol.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	var settings []RepositorySettings
+	if err := cursor.All(ctx, &settings); err != nil {
+		return nil, err
+	}
+
+	byURL := make(map[string]RepositorySettings, len(settings))
+	for _, repo := range settings {
+		for branch, owners := range repo.CodeOwners {
+			owners.compile()
+			repo.CodeOwners[branch] = owners
+		}
+		byURL[repo.URL] = repo
+	}
+	return byURL, nil
+}
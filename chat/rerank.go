@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Rerankers configurable in rerank.reranker.
+const (
+	rerankerLLM  = "llm"
+	rerankerHTTP = "http"
+)
+
+// rerankTextLength bounds the text of a commit sent to a reranker.
+const rerankTextLength = 1000
+
+// reranker re-scores the matches retrieved for a question. It returns one
+// score per match, higher meaning more relevant.
+type reranker interface {
+	rerank(ctx context.Context, question string, matches []Match) ([]float64, error)
+}
+
+// newReranker returns the configured reranker, or nil if matches are ranked
+// by retrieval alone.
+func newReranker(openaiClient *OpenAIClient) reranker {
+	switch config.Rerank.Reranker {
+	case rerankerLLM:
+		return llmReranker{client: openaiClient, model: config.Rerank.Model}
+	case rerankerHTTP:
+		return httpReranker{url: config.Rerank.URL, apiKey: config.Rerank.APIKey}
+	}
+	return nil
+}
+
+// candidateCount is the number of commits retrieved for a question: a wider
+// pool when it is re-ranked afterwards.
+func candidateCount() int {
+	if config.Rerank.Reranker != "" {
+		return config.Rerank.Candidates
+	}
+	return config.Pinecone.TopK
+}
+
+// rerankMatches re-scores matches and keeps the best rerank.keep of them.
+// The retrieval score of a re-ranked match is kept in RetrievalScore. If the
+// reranker fails, the best matches by retrieval are kept.
+func rerankMatches(ctx context.Context, reranker reranker, question string, matches []Match) []Match {
+	if reranker == nil || len(matches) == 0 {
+		return matches
+	}
+
+	start := time.Now()
+	scores, err := reranker.rerank(ctx, question, matches)
+	observeStage("rerank", start)
+	if err == nil && len(scores) != len(matches) {
+		err = fmt.Errorf("got %d scores for %d matches", len(scores), len(matches))
+	}
+	if err != nil {
+		loggerFrom(ctx).Warnw("Re-ranking failed, keeping the retrieval order", "error", err)
+		return limitMatches(matches, config.Rerank.Keep)
+	}
+
+	reranked := make([]Match, len(matches))
+	for i, match := range matches {
+		match.RetrievalScore = match.Score
+		match.Score = scores[i]
+		reranked[i] = match
+	}
+	sort.SliceStable(reranked, func(i, j int) bool {
+		return reranked[i].Score > reranked[j].Score
+	})
+	return limitMatches(reranked, config.Rerank.Keep)
+}
+
+func limitMatches(matches []Match, limit int) []Match {
+	if len(matches) > limit {
+		return matches[:limit]
+	}
+	return matches
+}
+
+func rerankText(match Match) string {
+	if len(match.Text) > rerankTextLength {
+		return match.Text[:rerankTextLength]
+	}
+	return match.Text
+}
+
+// llmReranker has the chat model grade every match from 0 to 10. The
+// grades are scaled to scores between 0 and 1.
+type llmReranker struct {
+	client *OpenAIClient
+	model  string
+}
+
+const rerankPrompt = "You grade how relevant commits are to finding the people who can help with a question. " +
+	"Grade every numbered commit from 0 (unrelated) to 10 (exactly what the question is about). " +
+	"Answer with a JSON array of the grades in the order of the commits and nothing else."
+
+func (r llmReranker) rerank(ctx context.Context, question string, matches []Match) (_ []float64, err error) {
+	ctx, span := tracer.Start(ctx, "openai.rerank", trace.WithAttributes(attribute.String("openai.model", r.model)))
+	defer func() { endSpan(span, err) }()
+
+	var commits strings.Builder
+	for i, match := range matches {
+		fmt.Fprintf(&commits, "# %d.\n%s\n\n", i+1, rerankText(match))
+	}
+
+	resp, err := r.client.Client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model: r.model,
+		Messages: []openai.ChatCompletionMessage{
+			{Role: "system", Content: rerankPrompt},
+			{Role: "user", Content: commits.String() + "Question: " + question},
+		},
+		MaxTokens: 4*len(matches) + 16,
+		N:         1,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to grade matches: %w", err)
+	}
+	tokensTotal.WithLabelValues("rerank").Add(float64(resp.Usage.TotalTokens))
+
+	grades, err := parseGrades(resp.Choices[0].Message.Content)
+	if err != nil {
+		return nil, err
+	}
+	for i := range grades {
+		grades[i] /= 10
+	}
+	return grades, nil
+}
+
+// parseGrades reads the JSON array of grades from an answer of the chat
+// model, ignoring any text around it.
+func parseGrades(answer string) ([]float64, error) {
+	start, end := strings.Index(answer, "["), strings.LastIndex(answer, "]")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("no grades in answer %q", answer)
+	}
+
+	var grades []float64
+	if err := json.Unmarshal([]byte(answer[start:end+1]), &grades); err != nil {
+		return nil, fmt.Errorf("failed to decode grades: %w", err)
+	}
+	return grades, nil
+}
+
+// httpReranker scores matches with a cross-encoder served over HTTP with the
+// rerank API of Hugging Face text-embeddings-inference: a POST of the query
+// and the texts, answered with the score of every text by index.
+type httpReranker struct {
+	url    string
+	apiKey string
+}
+
+func (r httpReranker) rerank(ctx context.Context, question string, matches []Match) (_ []float64, err error) {
+	ctx, span := tracer.Start(ctx, "reranker/rerank")
+	defer func() { endSpan(span, err) }()
+
+	texts := make([]string, 0, len(matches))
+	for _, match := range matches {
+		texts = append(texts, rerankText(match))
+	}
+	requestBody, err := json.Marshal(map[string]interface{}{
+		"query":    question,
+		"texts":    texts,
+		"truncate": true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", r.url, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create POST request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+r.apiKey)
+	}
+
+	httpClient := &http.Client{}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send POST request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read reranker response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to rerank, status code: %d, response: %s", resp.StatusCode, string(body))
+	}
+
+	var ranks []struct {
+		Index int     `json:"index"`
+		Score float64 `json:"score"`
+	}
+	if err := json.Unmarshal(body, &ranks); err != nil {
+		return nil, fmt.Errorf("failed to decode reranker response: %w", err)
+	}
+
+	scores := make([]float64, len(matches))
+	for _, rank := range ranks {
+		if rank.Index < 0 || rank.Index >= len(scores) {
+			return nil, fmt.Errorf("reranker scored unknown text %d", rank.Index)
+		}
+		scores[rank.Index] = rank.Score
+	}
+	return scores, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeReranker struct {
+	scores []float64
+	err    error
+}
+
+func (r fakeReranker) rerank(ctx context.Context, question string, matches []Match) ([]float64, error) {
+	return r.scores, r.err
+}
+
+func TestRerankMatchesKeepsBestRescored(t *testing.T) {
+	previous := config
+	defer func() { config = previous }()
+	config.Rerank.Keep = 2
+
+	matches := []Match{{ID: "a", Score: 0.9}, {ID: "b", Score: 0.8}, {ID: "c", Score: 0.7}}
+	reranked := rerankMatches(context.Background(), fakeReranker{scores: []float64{0.1, 0.9, 0.5}}, "q", matches)
+	require.Equal(t, []Match{{ID: "b", Score: 0.9, RetrievalScore: 0.8}, {ID: "c", Score: 0.5, RetrievalScore: 0.7}}, reranked)
+	require.Equal(t, 0.9, matches[0].Score)
+
+	reranked = rerankMatches(context.Background(), fakeReranker{err: errors.New("unavailable")}, "q", matches)
+	require.Equal(t, matches[:2], reranked)
+	reranked = rerankMatches(context.Background(), fakeReranker{scores: []float64{1}}, "q", matches)
+	require.Equal(t, matches[:2], reranked)
+}
+
+func TestParseGrades(t *testing.T) {
+	grades, err := parseGrades("Grades: [7, 0, 10.0]")
+	require.NoError(t, err)
+	require.Equal(t, []float64{7, 0, 10}, grades)
+
+	_, err = parseGrades("I cannot grade these.")
+	require.Error(t, err)
+}
+
+func TestHTTPRerankerScoresByIndex(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		var request struct {
+			Query string   `json:"query"`
+			Texts []string `json:"texts"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		require.Equal(t, "Who knows retries?", request.Query)
+		require.Equal(t, []string{"first", "second"}, request.Texts)
+		w.Write([]byte(`[{"index": 1, "score": 0.98}, {"index": 0, "score": 0.02}]`))
+	}))
+	defer server.Close()
+
+	scores, err := httpReranker{url: server.URL, apiKey: "secret"}.rerank(context.Background(), "Who knows retries?", []Match{{Text: "first"}, {Text: "second"}})
+	require.NoError(t, err)
+	require.Equal(t, []float64{0.02, 0.98}, scores)
+}
+
+func TestConfigValidatesReranker(t *testing.T) {
+	c := defaultConfig()
+	c.Rerank.Reranker = rerankerHTTP
+	c.Rerank.Keep = 60
+	err := c.validate()
+	require.ErrorContains(t, err, `rerank.url (RERANKER_URL) must be an http or https URL, got ""`)
+	require.ErrorContains(t, err, "rerank.candidates (RERANK_CANDIDATES) must be between rerank.keep and 10000, got 50")
+}
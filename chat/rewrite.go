@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Modes configurable in query_rewrite.mode.
+const (
+	rewriteLLM = "llm"
+	rewriteOff = "off"
+)
+
+// Bounds of the history a follow-up question is rewritten with.
+const (
+	rewriteHistoryMessages = 6
+	rewriteMessageLength   = 500
+)
+
+const rewritePrompt = "You turn the last question of a conversation into a standalone search query for a commit history. " +
+	"Resolve references such as \"it\" or \"that service\" from the conversation and keep names, identifiers, paths and error codes exactly. " +
+	"Answer with the query only."
+
+// rewriteQuery condenses a follow-up question and the conversation before it
+// into a standalone search query. It returns "" for first questions, when
+// rewriting is off, or when it fails, in which case the caller falls back to
+// the whole conversation.
+func rewriteQuery(ctx context.Context, openaiClient *OpenAIClient, messages []openai.ChatCompletionMessage, userMessage string) string {
+	if config.QueryRewrite.Mode != rewriteLLM || !hasUserMessage(messages) {
+		return ""
+	}
+
+	start := time.Now()
+	query, err := openaiClient.condenseQuery(ctx, config.QueryRewrite.Model, rewriteInput(messages, userMessage))
+	observeStage("rewrite", start)
+	if err != nil {
+		loggerFrom(ctx).Warnw("Query rewriting failed, searching with the whole conversation", "error", err)
+		return ""
+	}
+	return query
+}
+
+func hasUserMessage(messages []openai.ChatCompletionMessage) bool {
+	for _, message := range messages {
+		if message.Role == "user" {
+			return true
+		}
+	}
+	return false
+}
+
+// rewriteInput renders the last messages of a conversation and the follow-up
+// question for the rewriting prompt.
+func rewriteInput(messages []openai.ChatCompletionMessage, userMessage string) string {
+	if len(messages) > rewriteHistoryMessages {
+		messages = messages[len(messages)-rewriteHistoryMessages:]
+	}
+
+	var input strings.Builder
+	input.WriteString("Conversation:\n")
+	for _, message := range messages {
+		content := message.Content
+		if runes := []rune(content); len(runes) > rewriteMessageLength {
+			content = string(runes[:rewriteMessageLength]) + "…"
+		}
+		fmt.Fprintf(&input, "%s: %s\n", message.Role, content)
+	}
+	fmt.Fprintf(&input, "\nLast question: %s", userMessage)
+	return input.String()
+}
+
+func (c *OpenAIClient) condenseQuery(ctx context.Context, model, input string) (_ string, err error) {
+	ctx, span := tracer.Start(ctx, "openai.rewrite_query", trace.WithAttributes(attribute.String("openai.model", model)))
+	defer func() { endSpan(span, err) }()
+
+	resp, err := c.Client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model: model,
+		Messages: []openai.ChatCompletionMessage{
+			{Role: "system", Content: rewritePrompt},
+			{Role: "user", Content: input},
+		},
+		MaxTokens: 100,
+		N:         1,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to rewrite query: %w", err)
+	}
+	tokensTotal.WithLabelValues("rewrite").Add(float64(resp.Usage.TotalTokens))
+
+	query := strings.Trim(strings.TrimSpace(resp.Choices[0].Message.Content), `"`)
+	if query == "" {
+		return "", fmt.Errorf("rewritten query is empty")
+	}
+	return query, nil
+}
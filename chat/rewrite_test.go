@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRewriteInputKeepsRecentHistory(t *testing.T) {
+	var messages []openai.ChatCompletionMessage
+	for i := 0; i < 4; i++ {
+		messages = append(messages,
+			openai.ChatCompletionMessage{Role: "user", Content: "question " + string(rune('a'+i))},
+			openai.ChatCompletionMessage{Role: "assistant", Content: strings.Repeat("ü", rewriteMessageLength+1)})
+	}
+
+	input := rewriteInput(messages, "And who reviews it?")
+	require.NotContains(t, input, "question a")
+	require.Contains(t, input, "user: question b\n")
+	require.Contains(t, input, "assistant: "+strings.Repeat("ü", rewriteMessageLength)+"…\n")
+	require.True(t, strings.HasSuffix(input, "\nLast question: And who reviews it?"))
+}
+
+func TestRewriteQueryFallsBack(t *testing.T) {
+	var answer string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices": [{"message": {"role": "assistant", "content": ` + answer + `}}], "usage": {"total_tokens": 42}}`))
+	}))
+	defer server.Close()
+	clientConfig := openai.DefaultConfig("sk-test")
+	clientConfig.BaseURL = server.URL + "/v1"
+	client := &OpenAIClient{Client: openai.NewClientWithConfig(clientConfig)}
+	history := []openai.ChatCompletionMessage{{Role: "user", Content: "Who owns the billing service?"}}
+	ctx := context.Background()
+
+	answer = `"\"billing service invoice retries\""`
+	require.Equal(t, "billing service invoice retries", rewriteQuery(ctx, client, history, "Who fixed its retries?"))
+	require.Empty(t, rewriteQuery(ctx, client, nil, "Who owns the billing service?"))
+
+	answer = `" "`
+	require.Empty(t, rewriteQuery(ctx, client, history, "Who fixed its retries?"))
+
+	previous := config
+	defer func() { config = previous }()
+	config.QueryRewrite.Mode = rewriteOff
+	answer = `"billing retries"`
+	require.Empty(t, rewriteQuery(ctx, client, history, "Who fixed its retries?"))
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sashabaranov/go-openai"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// clientIDHeader scopes sessions to the client that created them. The chat
+// service has no accounts, so clients such as the web UI keep a random ID.
+// Session requests without one are rejected.
+const clientIDHeader = "X-Client-ID"
+
+const maxClientIDLength = 128
+
+const (
+	sessionListLimit   = 100
+	sessionTitleLength = 80
+)
+
+var errSessionNotFound = errors.New("session not found")
+
+// sessions stores conversations server-side. It is nil without a database.
+var sessions *sessionStore
+
+// Session is a conversation stored server-side. Its messages are the user
+// and assistant turns only: the prompt around them is built on every
+// request, so clients cannot change it.
+type Session struct {
+	ID        string                         `json:"id" bson:"_id"`
+	ClientID  string                         `json:"-" bson:"client_id"`
+	Title     string                         `json:"title" bson:"title"`
+	Messages  []openai.ChatCompletionMessage `json:"messages,omitempty" bson:"messages"`
+	CreatedAt time.Time                      `json:"createdAt" bson:"created_at"`
+	UpdatedAt time.Time                      `json:"updatedAt" bson:"updated_at"`
+	ExpiresAt time.Time                      `json:"expiresAt" bson:"expires_at"`
+}
+
+// sessionStore keeps sessions until they have been idle for the TTL.
+type sessionStore struct {
+	col *mongo.Collection
+	ttl time.Duration
+}
+
+func newSessionStore(col *mongo.Collection, ttl time.Duration) *sessionStore {
+	return &sessionStore{col: col, ttl: ttl}
+}
+
+// ensureIndexes lets the database remove expired sessions. Reads skip them
+// too, since the database removes them with a delay, or not at all where
+// TTL indexes are not supported.
+func (s *sessionStore) ensureIndexes(ctx context.Context) error {
+	_, err := s.col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		{Keys: bson.D{{Key: "client_id", Value: 1}, {Key: "updated_at", Value: -1}}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create session indexes: %w", err)
+	}
+	return nil
+}
+
+// liveSession selects the unexpired session of a client.
+func liveSession(clientID string, filter bson.M) bson.M {
+	filter["client_id"] = clientID
+	filter["expires_at"] = bson.M{"$gt": time.Now()}
+	return filter
+}
+
+func (s *sessionStore) create(ctx context.Context, clientID string) (*Session, error) {
+	now := time.Now().UTC()
+	session := &Session{
+		ID:        newSessionID(),
+		ClientID:  clientID,
+		Messages:  []openai.ChatCompletionMessage{},
+		CreatedAt: now,
+		UpdatedAt: now,
+		ExpiresAt: now.Add(s.ttl),
+	}
+	if _, err := s.col.InsertOne(ctx, session); err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+	return session, nil
+}
+
+func (s *sessionStore) get(ctx context.Context, clientID, id string) (*Session, error) {
+	var session Session
+	err := s.col.FindOne(ctx, liveSession(clientID, bson.M{"_id": id})).Decode(&session)
+	if err == mongo.ErrNoDocuments {
+		return nil, errSessionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session: %w", err)
+	}
+	return &session, nil
+}
+
+// list returns the most recently used sessions of a client without their
+// messages.
+func (s *sessionStore) list(ctx context.Context, clientID string) ([]Session, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "updated_at", Value: -1}}).
+		SetLimit(sessionListLimit).
+		SetProjection(bson.M{"messages": 0})
+	cursor, err := s.col.Find(ctx, liveSession(clientID, bson.M{}), opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	list := []Session{}
+	if err := cursor.All(ctx, &list); err != nil {
+		return nil, fmt.Errorf("failed to decode sessions: %w", err)
+	}
+	return list, nil
+}
+
+func (s *sessionStore) delete(ctx context.Context, clientID, id string) error {
+	result, err := s.col.DeleteOne(ctx, liveSession(clientID, bson.M{"_id": id}))
+	if err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	if result.DeletedCount == 0 {
+		return errSessionNotFound
+	}
+	return nil
+}
+
+// appendExchange adds a user message and its answer to a session and
+// extends its expiry. The first message becomes the title of the session.
+func (s *sessionStore) appendExchange(ctx context.Context, session *Session, userMessage, response string) error {
+	now := time.Now().UTC()
+	set := bson.M{"updated_at": now, "expires_at": now.Add(s.ttl)}
+	if session.Title == "" {
+		set["title"] = sessionTitle(userMessage)
+	}
+
+	exchange := appendExchange(nil, userMessage, response)
+	result, err := s.col.UpdateOne(ctx, liveSession(session.ClientID, bson.M{"_id": session.ID}), bson.M{
+		"$push": bson.M{"messages": bson.M{"$each": exchange}},
+		"$set":  set,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return errSessionNotFound
+	}
+	return nil
+}
+
+func newSessionID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(id)
+}
+
+func sessionTitle(message string) string {
+	runes := []rune(message)
+	if len(runes) > sessionTitleLength {
+		return string(runes[:sessionTitleLength-1]) + "…"
+	}
+	return message
+}
+
+// loadSession replaces the messages of a request that continues a session
+// by the stored ones. It responds with an error and returns false if the
+// session cannot be continued, or if the request sends its own history
+// although sessions.client_history is off.
+func loadSession(c *gin.Context, request *ConversationRequest) (*Session, bool) {
+	if request.SessionID == "" {
+		if len(request.Messages) > 0 && !config.Sessions.ClientHistory {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "messages are no longer accepted, use a session"})
+			return nil, false
+		}
+		return nil, true
+	}
+	if len(request.Messages) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "send either sessionId or messages"})
+		return nil, false
+	}
+	clientID, ok := sessionClient(c)
+	if !ok {
+		return nil, false
+	}
+
+	session, err := sessions.get(c.Request.Context(), clientID, request.SessionID)
+	if !sessionOK(c, err) {
+		return nil, false
+	}
+	request.Messages = session.Messages
+	return session, true
+}
+
+// sessionClient returns the client ID of a session request. It responds
+// with an error and returns false if sessions are disabled or the request
+// has no client ID.
+func sessionClient(c *gin.Context) (string, bool) {
+	if sessions == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "sessions require a database"})
+		return "", false
+	}
+	clientID := c.GetHeader(clientIDHeader)
+	if clientID == "" || len(clientID) > maxClientIDLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("sessions require an %s header of at most %d characters", clientIDHeader, maxClientIDLength)})
+		return "", false
+	}
+	return clientID, true
+}
+
+// sessionOK responds with the error of a session operation, if any, and
+// tells whether it succeeded.
+func sessionOK(c *gin.Context, err error) bool {
+	if errors.Is(err, errSessionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return false
+	}
+	if err != nil {
+		loggerFrom(c.Request.Context()).Errorw("Error accessing session", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to access session"})
+		return false
+	}
+	return true
+}
+
+// registerSessionRoutes adds the endpoints that create, list, fetch and
+// delete sessions. Sessions are continued through /api/conversation.
+func registerSessionRoutes(router *gin.Engine) {
+	router.POST("/api/sessions", func(c *gin.Context) {
+		clientID, ok := sessionClient(c)
+		if !ok {
+			return
+		}
+		session, err := sessions.create(c.Request.Context(), clientID)
+		if sessionOK(c, err) {
+			c.JSON(http.StatusCreated, session)
+		}
+	})
+
+	router.GET("/api/sessions", func(c *gin.Context) {
+		clientID, ok := sessionClient(c)
+		if !ok {
+			return
+		}
+		list, err := sessions.list(c.Request.Context(), clientID)
+		if sessionOK(c, err) {
+			c.JSON(http.StatusOK, list)
+		}
+	})
+
+	router.GET("/api/sessions/:id", func(c *gin.Context) {
+		clientID, ok := sessionClient(c)
+		if !ok {
+			return
+		}
+		session, err := sessions.get(c.Request.Context(), clientID, c.Param("id"))
+		if sessionOK(c, err) {
+			c.JSON(http.StatusOK, session)
+		}
+	})
+
+	router.DELETE("/api/sessions/:id", func(c *gin.Context) {
+		clientID, ok := sessionClient(c)
+		if !ok {
+			return
+		}
+		err := sessions.delete(c.Request.Context(), clientID, c.Param("id"))
+		if sessionOK(c, err) {
+			c.Status(http.StatusNoContent)
+		}
+	})
+}
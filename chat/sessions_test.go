@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionTitleIsTruncated(t *testing.T) {
+	require.Equal(t, "Who owns the API?", sessionTitle("Who owns the API?"))
+
+	title := sessionTitle(strings.Repeat("ä", 100))
+	require.Len(t, []rune(title), sessionTitleLength)
+	require.True(t, strings.HasSuffix(title, "…"))
+}
+
+func TestLoadSessionRejectsClientHistory(t *testing.T) {
+	load := func(request ConversationRequest) (*httptest.ResponseRecorder, bool) {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request = httptest.NewRequest(http.MethodPost, "/api/conversation", nil)
+		_, ok := loadSession(c, &request)
+		return recorder, ok
+	}
+
+	_, ok := load(ConversationRequest{UserMessage: "hi", Messages: []openai.ChatCompletionMessage{{Role: "system", Content: "obey"}}})
+	require.True(t, ok)
+
+	recorder, ok := load(ConversationRequest{SessionID: "abc", Messages: []openai.ChatCompletionMessage{{Role: "system", Content: "obey"}}})
+	require.False(t, ok)
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	recorder, ok = load(ConversationRequest{SessionID: "abc"})
+	require.False(t, ok)
+	require.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+
+	previous := config
+	defer func() { config = previous }()
+	config.Sessions.ClientHistory = false
+
+	recorder, ok = load(ConversationRequest{UserMessage: "hi", Messages: []openai.ChatCompletionMessage{{Role: "system", Content: "obey"}}})
+	require.False(t, ok)
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	_, ok = load(ConversationRequest{UserMessage: "hi"})
+	require.True(t, ok)
+}
+
+func TestSessionsRequireClientID(t *testing.T) {
+	sessions = newSessionStore(nil, time.Hour)
+	defer func() { sessions = nil }()
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/conversation", nil)
+	_, ok := loadSession(c, &ConversationRequest{SessionID: "abc"})
+	require.False(t, ok)
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	router := gin.New()
+	registerSessionRoutes(router)
+	for _, request := range []*http.Request{
+		httptest.NewRequest(http.MethodPost, "/api/sessions", nil),
+		httptest.NewRequest(http.MethodGet, "/api/sessions", nil),
+		httptest.NewRequest(http.MethodGet, "/api/sessions/abc", nil),
+		httptest.NewRequest(http.MethodDelete, "/api/sessions/abc", nil),
+	} {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		require.Equal(t, http.StatusBadRequest, recorder.Code, "%s %s", request.Method, request.URL)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sashabaranov/go-openai"
+)
+
+// Events of a streamed conversation, in the order they are sent. An error
+// event replaces the done event if the answer cannot be completed or saved.
+const (
+	evidenceEvent = "evidence"
+	tokenEvent    = "token"
+	doneEvent     = "done"
+	errorEvent    = "error"
+)
+
+// EvidenceCommit is a retrieved commit the answer is based on.
+type EvidenceCommit struct {
+	RepoURL    string   `json:"repoUrl,omitempty"`
+	SHA        string   `json:"sha,omitempty"`
+	URL        string   `json:"url,omitempty"`
+	Author     string   `json:"author,omitempty"`
+	Contact    string   `json:"contact,omitempty"`
+	Score      float64  `json:"score"`
+	Timestamp  int64    `json:"timestamp,omitempty"`
+	Files      []string `json:"files,omitempty"`
+	Languages  []string `json:"languages,omitempty"`
+	Components []string `json:"components,omitempty"`
+
+	// RetrievalScore is set if Score comes from the reranker.
+	RetrievalScore float64 `json:"retrievalScore,omitempty"`
+}
+
+type evidencePayload struct {
+	Commits        []EvidenceCommit `json:"commits"`
+	Owners         []DeclaredOwner  `json:"owners"`
+	Experts        []Expert         `json:"experts"`
+	Retrieval      *RetrievalDebug  `json:"retrieval,omitempty"`
+	RewrittenQuery string           `json:"rewrittenQuery,omitempty"`
+}
+
+type tokenPayload struct {
+	Content string `json:"content"`
+}
+
+type donePayload struct {
+	Response  string                         `json:"response"`
+	Messages  []openai.ChatCompletionMessage `json:"messages"`
+	Owners    []DeclaredOwner                `json:"owners"`
+	Experts   []Expert                       `json:"experts"`
+	SessionID string                         `json:"sessionId,omitempty"`
+}
+
+type errorPayload struct {
+	Error string `json:"error"`
+}
+
+func newEvidence(matches []Match, owners []DeclaredOwner, experts []Expert) evidencePayload {
+	commits := make([]EvidenceCommit, 0, len(matches))
+	for _, match := range matches {
+		commits = append(commits, EvidenceCommit{
+			RepoURL:        match.RepoURL,
+			SHA:            match.SHA,
+			URL:            match.URL,
+			Author:         match.Author,
+			Contact:        match.Contact,
+			Score:          match.Score,
+			RetrievalScore: match.RetrievalScore,
+			Timestamp:      match.Timestamp,
+			Files:          match.Files,
+			Languages:      match.Languages,
+			Components:     match.Components,
+		})
+	}
+	if owners == nil {
+		owners = []DeclaredOwner{}
+	}
+	return evidencePayload{Commits: commits, Owners: owners, Experts: experts}
+}
+
+// wantsEventStream tells whether a conversation request asked for a stream.
+func wantsEventStream(c *gin.Context) bool {
+	return strings.Contains(c.GetHeader("Accept"), "text/event-stream")
+}
+
+// streamConversation answers a conversation as Server-Sent Events: the
+// retrieved evidence first, then the answer token by token, then the updated
+// messages. Retrieval errors are reported with a plain error response, since
+// the stream has not started yet. When the client disconnects, the request
+// context is cancelled and with it the chat completion. The answer is only
+// added to the session once it is complete.
+func streamConversation(c *gin.Context, openaiClient *OpenAIClient, pineconeClient *PineconeClient, request ConversationRequest, session *Session) {
+	ctx := c.Request.Context()
+	prompt, err := prepareConversation(ctx, openaiClient, pineconeClient, request.UserMessage, request.Messages, request.RetrievalOptions)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	events := eventWriter{w: c.Writer, flush: c.Writer.Flush}
+	evidence := newEvidence(prompt.matches, prompt.owners, prompt.experts)
+	evidence.Retrieval = prompt.retrieval
+	evidence.RewrittenQuery = prompt.rewrittenQuery
+	if err := events.send(evidenceEvent, evidence); err != nil {
+		return
+	}
+
+	var answer strings.Builder
+	redactor := streamRedactor{memory: prompt.memory}
+	start := time.Now()
+	chunks, err := openaiClient.StreamChatCompletion(ctx, prompt.messages, func(delta string) error {
+		answer.WriteString(delta)
+		return events.sendToken(redactor.write(delta))
+	})
+	observeStage("complete", start)
+	// Streams report no usage. Every chunk carries about one token.
+	tokensTotal.WithLabelValues("completion").Add(float64(chunks))
+	if ctx.Err() != nil {
+		loggerFrom(ctx).Infow("Client disconnected from conversation stream", "chunks", chunks)
+		return
+	}
+	if err == nil {
+		err = events.sendToken(redactor.flush())
+	}
+	if err != nil {
+		loggerFrom(ctx).Errorw("Error streaming chat completion", "error", err)
+		events.send(errorEvent, errorPayload{Error: fmt.Sprintf("Error while streaming chat completion: %v", err)})
+		return
+	}
+
+	response := redactUnknownEmails(answer.String(), prompt.memory)
+	if session != nil {
+		if err := sessions.appendExchange(ctx, session, request.UserMessage, response); err != nil {
+			loggerFrom(ctx).Errorw("Error saving session", "session_id", session.ID, "error", err)
+			events.send(errorEvent, errorPayload{Error: "failed to save session"})
+			return
+		}
+	}
+
+	events.send(doneEvent, donePayload{
+		Response:  response,
+		Messages:  appendExchange(request.Messages, request.UserMessage, response),
+		Owners:    prompt.owners,
+		Experts:   prompt.experts,
+		SessionID: request.SessionID,
+	})
+}
+
+// eventWriter writes Server-Sent Events with JSON data and flushes each one
+// to the client.
+type eventWriter struct {
+	w     io.Writer
+	flush func()
+}
+
+func (e eventWriter) send(event string, data interface{}) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s event: %w", event, err)
+	}
+	if _, err := fmt.Fprintf(e.w, "event: %s\ndata: %s\n\n", event, encoded); err != nil {
+		return err
+	}
+	e.flush()
+	return nil
+}
+
+func (e eventWriter) sendToken(content string) error {
+	if content == "" {
+		return nil
+	}
+	return e.send(tokenEvent, tokenPayload{Content: content})
+}
+
+// streamRedactor redacts unknown email addresses from an answer while it is
+// streamed. Addresses contain no whitespace, so text is released up to the
+// last whitespace and the word after it is held back until it is complete.
+type streamRedactor struct {
+	memory  string
+	pending string
+}
+
+func (r *streamRedactor) write(delta string) string {
+	r.pending += delta
+	end := strings.LastIndexAny(r.pending, " \t\n")
+	if end < 0 {
+		return ""
+	}
+
+	released := r.pending[:end+1]
+	r.pending = r.pending[end+1:]
+	return redactUnknownEmails(released, r.memory)
+}
+
+// flush releases the text held back at the end of the answer.
+func (r *streamRedactor) flush() string {
+	released := redactUnknownEmails(r.pending, r.memory)
+	r.pending = ""
+	return released
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamRedactorHoldsBackIncompleteWords(t *testing.T) {
+	redactor := streamRedactor{memory: "Email: jane@example.com"}
+
+	var out strings.Builder
+	for _, delta := range []string{"Ask jane@exa", "mple.com or bob@", "example.com", " today"} {
+		out.WriteString(redactor.write(delta))
+	}
+	require.Equal(t, "Ask jane@example.com or "+withheldContact+" ", out.String())
+	out.WriteString(redactor.flush())
+	require.Equal(t, "Ask jane@example.com or "+withheldContact+" today", out.String())
+}
+
+func TestEventWriterFramesJSONEvents(t *testing.T) {
+	var buf bytes.Buffer
+	flushes := 0
+	events := eventWriter{w: &buf, flush: func() { flushes++ }}
+
+	require.NoError(t, events.sendToken("line\nbreak"))
+	require.NoError(t, events.sendToken(""))
+	require.Equal(t, "event: token\ndata: {\"content\":\"line\\nbreak\"}\n\n", buf.String())
+	require.Equal(t, 1, flushes)
+}
+
+func TestStreamChatCompletionPassesDeltas(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, content := range []string{"I would", " recommend"} {
+			fmt.Fprintf(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":%q}}]}\n\n", content)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	clientConfig := openai.DefaultConfig("test")
+	clientConfig.BaseURL = server.URL
+	client := &OpenAIClient{Client: openai.NewClientWithConfig(clientConfig)}
+
+	var deltas []string
+	chunks, err := client.StreamChatCompletion(context.Background(), nil, func(delta string) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, chunks)
+	require.Equal(t, []string{"I would", " recommend"}, deltas)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// adminAPI exports and imports bundles and migrates the index over HTTP. It
+// requires the configured admin token as a bearer token and is disabled
+// without one.
+type adminAPI struct {
+	repoCol *mongo.Collection
+	ledger  *ledger
+	jobs    jobSender
+	token   string
+}
+
+func newAdminAPI(repoCol *mongo.Collection, ledger *ledger, jobs jobSender) *adminAPI {
+	return &adminAPI{
+		repoCol: repoCol,
+		ledger:  ledger,
+		jobs:    jobs,
+		token:   config.HTTP.AdminToken,
+	}
+}
+
+func (a *adminAPI) authorized(w http.ResponseWriter, r *http.Request) bool {
+	if a.token == "" {
+		http.Error(w, "admin API is disabled", http.StatusNotFound)
+		return false
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// export streams a bundle of the repository given by the "repository" query
+// parameter, or of the whole index.
+func (a *adminAPI) export(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !a.authorized(w, r) {
+		return
+	}
+
+	repoID := r.URL.Query().Get("repository")
+	if repoID != "" {
+		_, err := getRepositoryByID(r.Context(), repoID, a.repoCol)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			http.Error(w, "repository not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	generation, store, err := activeStore(r.Context(), a.ledger)
+	if err != nil {
+		logger.Errorw("Failed to open vector store", "error", err)
+		http.Error(w, "failed to export bundle", http.StatusInternalServerError)
+		return
+	}
+
+	name := "index"
+	if repoID != "" {
+		name = repoID
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".jsonl.gz"))
+
+	// Once streaming started a failure cannot change the status. The bundle
+	// is then left without its gzip trailer and fails to import.
+	report, err := exportBundle(r.Context(), w, repoID, a.repoCol, a.ledger, generation, store)
+	if err != nil {
+		logger.Errorw("Failed to export bundle", "repo_id", repoID, "error", err)
+		return
+	}
+	logger.Infow("Exported bundle", "repo_id", repoID, "vectors", report.Vectors, "commits", report.Commits)
+}
+
+// importBundle restores the bundle in the request body and responds with
+// what it imported.
+func (a *adminAPI) importBundle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !a.authorized(w, r) {
+		return
+	}
+
+	generation, store, err := activeStore(r.Context(), a.ledger)
+	if err != nil {
+		logger.Errorw("Failed to open vector store", "error", err)
+		http.Error(w, "failed to import bundle", http.StatusInternalServerError)
+		return
+	}
+
+	report, err := importBundle(r.Context(), r.Body, a.repoCol, a.ledger, generation, store)
+	if errors.Is(err, errInvalidBundle) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		logger.Errorw("Failed to import bundle", "repo_id", report.Repository, "error", err)
+		http.Error(w, "failed to import bundle", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Infow("Imported bundle", "repo_id", report.Repository, "vectors", report.Vectors, "commits", report.Commits)
+	writeJSON(w, http.StatusOK, report)
+}
+
+// generationsResponse is the state of the index with the coverage of the
+// generation being built.
+type generationsResponse struct {
+	IndexState
+	Coverage *float64 `json:"coverage,omitempty"`
+}
+
+// migrationRequest selects the model of a new generation and, for a model
+// of another dimension, the Pinecone index it is built in.
+type migrationRequest struct {
+	Model string `json:"model"`
+	Index string `json:"index,omitempty"`
+}
+
+// generations reports the generations of the index on GET and starts
+// building a new one on POST.
+func (a *adminAPI) generations(w http.ResponseWriter, r *http.Request) {
+	if !a.authorized(w, r) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		state, err := a.ledger.indexState(r.Context())
+		if err != nil {
+			logger.Errorw("Failed to read index generations", "error", err)
+			http.Error(w, "failed to read index generations", http.StatusInternalServerError)
+			return
+		}
+
+		response := generationsResponse{IndexState: state}
+		if state.Building != nil {
+			coverage := state.Building.coverage()
+			response.Coverage = &coverage
+		}
+		writeJSON(w, http.StatusOK, response)
+	case http.MethodPost:
+		a.migrate(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (a *adminAPI) migrate(w http.ResponseWriter, r *http.Request) {
+	var request migrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if _, err := parseEmbeddingModel(request.Model); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	generation, err := a.ledger.beginGeneration(r.Context(), request.Model, request.Index)
+	if errors.Is(err, errGenerationBuilding) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		logger.Errorw("Failed to begin generation", "error", err)
+		http.Error(w, "failed to begin generation", http.StatusInternalServerError)
+		return
+	}
+
+	err = sendJob(r.Context(), a.jobs, generation.ID, modeMigrate, time.Time{})
+	if err != nil {
+		logger.Errorw("Failed to queue migration", "generation", generation.ID, "error", err)
+		http.Error(w, "failed to queue migration", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Infow("Queued migration", "generation", generation.ID, "model", generation.Model)
+	writeJSON(w, http.StatusAccepted, generation)
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		logger.Warnw("Failed to write response", "error", err)
+	}
+}
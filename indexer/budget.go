@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var errBudgetExceeded = errors.New("monthly token budget exceeded")
+
+const globalUsageKey = "global"
+
+// tokenBudget enforces the monthly token budgets of a repository and of the
+// whole installation. Usage is tracked per calendar month in the usage collection.
+// Concurrent commits may overshoot a budget by the size of the requests in flight.
+type tokenBudget struct {
+	usageCol    *mongo.Collection
+	repoID      string
+	repoLimit   int64
+	globalLimit int64
+}
+
+type tokenUsage struct {
+	ID     string `bson:"_id"`
+	Month  string `bson:"month"`
+	Tokens int64  `bson:"tokens"`
+}
+
+func newTokenBudget(usageCol *mongo.Collection, repo Repository) *tokenBudget {
+	return &tokenBudget{
+		usageCol:    usageCol,
+		repoID:      repo.ID,
+		repoLimit:   repo.MonthlyTokenBudget,
+		globalLimit: config.Budget.GlobalMonthlyTokens,
+	}
+}
+
+func currentMonth() string {
+	return time.Now().UTC().Format("2006-01")
+}
+
+func usageKey(scope, month string) string {
+	return fmt.Sprintf("%s:%s", scope, month)
+}
+
+// reserve returns errBudgetExceeded if spending tokens would exceed either budget.
+func (b *tokenBudget) reserve(ctx context.Context, tokens int64) error {
+	if b == nil || b.usageCol == nil {
+		return nil
+	}
+
+	month := currentMonth()
+	if b.repoLimit > 0 {
+		used, err := b.used(ctx, usageKey(b.repoID, month))
+		if err != nil {
+			return err
+		}
+		if used+tokens > b.repoLimit {
+			return errBudgetExceeded
+		}
+	}
+
+	if b.globalLimit > 0 {
+		used, err := b.used(ctx, usageKey(globalUsageKey, month))
+		if err != nil {
+			return err
+		}
+		if used+tokens > b.globalLimit {
+			return errBudgetExceeded
+		}
+	}
+
+	return nil
+}
+
+// record adds tokens actually consumed to the repository and global usage.
+func (b *tokenBudget) record(ctx context.Context, tokens int64) error {
+	if b == nil || b.usageCol == nil || tokens == 0 {
+		return nil
+	}
+
+	month := currentMonth()
+	for _, scope := range []string{b.repoID, globalUsageKey} {
+		_, err := b.usageCol.UpdateOne(ctx,
+			bson.M{"_id": usageKey(scope, month)},
+			bson.M{"$inc": bson.M{"tokens": tokens}, "$set": bson.M{"month": month}},
+			options.Update().SetUpsert(true))
+		if err != nil {
+			return fmt.Errorf("failed to record token usage: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (b *tokenBudget) used(ctx context.Context, key string) (int64, error) {
+	var usage tokenUsage
+	err := b.usageCol.FindOne(ctx, bson.M{"_id": key}).Decode(&usage)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read token usage: %w", err)
+	}
+	return usage.Tokens, nil
+}
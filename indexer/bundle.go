@@ -0,0 +1,452 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// errInvalidBundle is returned for bundles that are malformed or
+// incompatible with the configured vector store.
+var errInvalidBundle = errors.New("invalid bundle")
+
+// bundleVersion is the version of the bundle format written by export.
+// Version 1 bundles, written before keyword postings were exported, still
+// import; their commits are found by keyword once indexed again.
+const bundleVersion = 2
+
+// bundleFetchBatchSize is the number of vector IDs fetched from the store
+// at a time while exporting.
+const bundleFetchBatchSize = 1000
+
+// The kinds of bundle lines. Records are Mongo documents as canonical
+// Extended JSON, so they restore exactly as they were exported.
+const (
+	bundleHeaderKind     = "header"
+	bundleVectorKind     = "vector"
+	bundleRepositoryKind = "repository"
+	bundleCommitKind     = "commit"
+	bundleDocumentKind   = "document"
+	bundlePersonKind     = "person"
+	bundleKeywordKind    = "keyword"
+)
+
+// bundleHeader is the first line of a bundle. It records what the vectors
+// were embedded with, so they are not imported into an incompatible index.
+type bundleHeader struct {
+	Version    int       `json:"version"`
+	Model      string    `json:"model"`
+	Dimension  int       `json:"dimension"`
+	Repository string    `json:"repository,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// bundleLine is one line of a gzip-compressed JSONL bundle.
+type bundleLine struct {
+	Kind   string          `json:"kind"`
+	Header *bundleHeader   `json:"header,omitempty"`
+	Vector *storedVector   `json:"vector,omitempty"`
+	Record json.RawMessage `json:"record,omitempty"`
+}
+
+// BundleReport counts what was exported or imported.
+type BundleReport struct {
+	Repository   string `json:"repository,omitempty"`
+	Model        string `json:"model"`
+	Dimension    int    `json:"dimension"`
+	Vectors      int64  `json:"vectors"`
+	Repositories int64  `json:"repositories"`
+	Commits      int64  `json:"commits"`
+	Documents    int64  `json:"documents"`
+	People       int64  `json:"people"`
+	Keywords     int64  `json:"keywords"`
+}
+
+func (r *BundleReport) count(kind string) {
+	switch kind {
+	case bundleVectorKind:
+		r.Vectors++
+	case bundleRepositoryKind:
+		r.Repositories++
+	case bundleCommitKind:
+		r.Commits++
+	case bundleDocumentKind:
+		r.Documents++
+	case bundlePersonKind:
+		r.People++
+	case bundleKeywordKind:
+		r.Keywords++
+	}
+}
+
+type bundleWriter struct {
+	gzip    *gzip.Writer
+	encoder *json.Encoder
+}
+
+func newBundleWriter(w io.Writer, header bundleHeader) (*bundleWriter, error) {
+	gz := gzip.NewWriter(w)
+	b := &bundleWriter{gzip: gz, encoder: json.NewEncoder(gz)}
+	if err := b.write(bundleLine{Kind: bundleHeaderKind, Header: &header}); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *bundleWriter) write(line bundleLine) error {
+	if err := b.encoder.Encode(line); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+	return nil
+}
+
+// close completes the bundle. A bundle that is not closed fails to import.
+func (b *bundleWriter) close() error {
+	if err := b.gzip.Close(); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+	return nil
+}
+
+type bundleReader struct {
+	header  bundleHeader
+	decoder *json.Decoder
+}
+
+func newBundleReader(r io.Reader) (*bundleReader, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidBundle, err)
+	}
+
+	b := &bundleReader{decoder: json.NewDecoder(gz)}
+	line, err := b.next()
+	if err == io.EOF || (err == nil && (line.Kind != bundleHeaderKind || line.Header == nil)) {
+		return nil, fmt.Errorf("%w: missing header", errInvalidBundle)
+	}
+	if err != nil {
+		return nil, err
+	}
+	b.header = *line.Header
+	return b, nil
+}
+
+// next returns the next line of the bundle, or io.EOF after the last one.
+func (b *bundleReader) next() (bundleLine, error) {
+	var line bundleLine
+	err := b.decoder.Decode(&line)
+	if err == io.EOF {
+		return line, err
+	}
+	if err != nil {
+		return line, fmt.Errorf("%w: %v", errInvalidBundle, err)
+	}
+	return line, nil
+}
+
+// checkBundleCompatibility refuses bundles whose vectors cannot be queried
+// alongside the ones embedded with the model into a store of the given
+// dimension.
+func checkBundleCompatibility(header bundleHeader, model string, dimension int) error {
+	if header.Version < 1 || header.Version > bundleVersion {
+		return fmt.Errorf("%w: unsupported version %d", errInvalidBundle, header.Version)
+	}
+	if header.Model != model {
+		return fmt.Errorf("%w: embedded with %s, the index embeds with %s", errInvalidBundle, header.Model, model)
+	}
+	if header.Dimension != dimension {
+		return fmt.Errorf("%w: %d-dimensional vectors, the vector store holds %d", errInvalidBundle, header.Dimension, dimension)
+	}
+	return nil
+}
+
+// exportBundle writes the vectors of a generation, ledger entries, people
+// profiles and keyword postings of a repository, or of the whole index if
+// repoID is empty, to w.
+func exportBundle(ctx context.Context, w io.Writer, repoID string, repoCol *mongo.Collection, ledger *ledger, generation Generation, store vectorStore) (BundleReport, error) {
+	report := BundleReport{Repository: repoID, Model: generation.Model}
+
+	filter := bson.M{}
+	repositoryFilter := bson.M{}
+	peopleFilter := bson.M{}
+	var peopleOptions []*options.FindOptions
+	if repoID != "" {
+		if _, err := getRepositoryByID(ctx, repoID, repoCol); err != nil {
+			return report, err
+		}
+
+		// Profiles only carry the contribution to the exported repository.
+		contribution := "repositories." + repoID
+		filter["repo_id"] = repoID
+		repositoryFilter["_id"] = repoID
+		peopleFilter[contribution] = bson.M{"$exists": true}
+		peopleOptions = append(peopleOptions, options.Find().SetProjection(bson.M{"name": 1, "contact": 1, contribution: 1}))
+	}
+
+	dimension, err := store.dimension(ctx)
+	if err != nil {
+		return report, err
+	}
+	report.Dimension = dimension
+
+	bundle, err := newBundleWriter(w, bundleHeader{
+		Version:    bundleVersion,
+		Model:      report.Model,
+		Dimension:  dimension,
+		Repository: repoID,
+		CreatedAt:  time.Now().UTC(),
+	})
+	if err != nil {
+		return report, err
+	}
+
+	ids, err := ledger.vectorIDsMatching(ctx, filter)
+	if err != nil {
+		return report, err
+	}
+	for start := 0; start < len(ids); start += bundleFetchBatchSize {
+		end := start + bundleFetchBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		vectors, err := store.fetch(ctx, ids[start:end])
+		if err != nil {
+			return report, err
+		}
+		for i := range vectors {
+			if err := bundle.write(bundleLine{Kind: bundleVectorKind, Vector: &vectors[i]}); err != nil {
+				return report, err
+			}
+			report.count(bundleVectorKind)
+		}
+	}
+
+	collections := []struct {
+		kind    string
+		col     *mongo.Collection
+		filter  bson.M
+		options []*options.FindOptions
+	}{
+		{bundleRepositoryKind, repoCol, repositoryFilter, nil},
+		{bundleCommitKind, ledger.commitCol, filter, nil},
+		{bundleDocumentKind, ledger.documentCol, filter, nil},
+		{bundlePersonKind, ledger.peopleCol, peopleFilter, peopleOptions},
+		// Postings are sorted by entry, so they are imported entry by entry.
+		{bundleKeywordKind, ledger.keywordCol, filter, []*options.FindOptions{options.Find().SetSort(bson.D{{Key: "entry", Value: 1}})}},
+	}
+	for _, c := range collections {
+		err := exportRecords(ctx, bundle, c.kind, c.col, c.filter, c.options, &report)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	return report, bundle.close()
+}
+
+func exportRecords(ctx context.Context, bundle *bundleWriter, kind string, col *mongo.Collection, filter bson.M, opts []*options.FindOptions, report *BundleReport) error {
+	cursor, err := col.Find(ctx, filter, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to list %s: %w", col.Name(), err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		record, err := bson.MarshalExtJSON(cursor.Current, true, false)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s record: %w", kind, err)
+		}
+		if err := bundle.write(bundleLine{Kind: kind, Record: record}); err != nil {
+			return err
+		}
+		report.count(kind)
+	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", col.Name(), err)
+	}
+	return nil
+}
+
+// importBundle restores a bundle into the store of a generation and the
+// ledger. The bundle is checked for compatibility with the generation before
+// anything is written, and records replace the ones with the same ID. The
+// keyword postings of an entry replace its previous ones, keeping the stats
+// of the keyword index.
+func importBundle(ctx context.Context, r io.Reader, repoCol *mongo.Collection, ledger *ledger, generation Generation, store vectorStore) (BundleReport, error) {
+	bundle, err := newBundleReader(r)
+	if err != nil {
+		return BundleReport{}, err
+	}
+
+	header := bundle.header
+	report := BundleReport{Repository: header.Repository, Model: header.Model, Dimension: header.Dimension}
+
+	dimension, err := store.dimension(ctx)
+	if err != nil {
+		return report, err
+	}
+	if err := checkBundleCompatibility(header, generation.Model, dimension); err != nil {
+		return report, err
+	}
+
+	var vectors []storedVector
+	flush := func() error {
+		if len(vectors) == 0 {
+			return nil
+		}
+		if err := store.upsert(ctx, vectors); err != nil {
+			return err
+		}
+		report.Vectors += int64(len(vectors))
+		vectors = vectors[:0]
+		return nil
+	}
+
+	var postings []KeywordPosting
+	flushKeywords := func() error {
+		if len(postings) == 0 {
+			return nil
+		}
+		if err := ledger.replaceKeywords(ctx, postings[0].Entry, postings); err != nil {
+			return err
+		}
+		report.Keywords += int64(len(postings))
+		postings = postings[:0]
+		return nil
+	}
+
+	for {
+		line, err := bundle.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return report, err
+		}
+
+		if line.Kind == bundleVectorKind {
+			if line.Vector == nil || len(line.Vector.Values) != dimension {
+				return report, fmt.Errorf("%w: malformed vector after %d vectors", errInvalidBundle, report.Vectors+int64(len(vectors)))
+			}
+			vectors = append(vectors, *line.Vector)
+			if len(vectors) == pineconeUpsertBatchSize {
+				if err := flush(); err != nil {
+					return report, err
+				}
+			}
+			continue
+		}
+
+		if err := flush(); err != nil {
+			return report, err
+		}
+
+		if line.Kind == bundleKeywordKind {
+			var posting KeywordPosting
+			if err := bson.UnmarshalExtJSON(line.Record, true, &posting); err != nil || posting.ID == "" || posting.Entry == "" {
+				return report, fmt.Errorf("%w: malformed keyword record", errInvalidBundle)
+			}
+			if len(postings) > 0 && postings[0].Entry != posting.Entry {
+				if err := flushKeywords(); err != nil {
+					return report, err
+				}
+			}
+			postings = append(postings, posting)
+			continue
+		}
+
+		if err := flushKeywords(); err != nil {
+			return report, err
+		}
+		if err := importRecord(ctx, line, repoCol, ledger, generation.ID); err != nil {
+			return report, err
+		}
+		report.count(line.Kind)
+	}
+
+	if err := flush(); err != nil {
+		return report, err
+	}
+	return report, flushKeywords()
+}
+
+func importRecord(ctx context.Context, line bundleLine, repoCol *mongo.Collection, ledger *ledger, generationID string) error {
+	if line.Kind == bundlePersonKind {
+		return importPerson(ctx, line.Record, ledger)
+	}
+
+	var col *mongo.Collection
+	switch line.Kind {
+	case bundleRepositoryKind:
+		col = repoCol
+	case bundleCommitKind:
+		col = ledger.commitCol
+	case bundleDocumentKind:
+		col = ledger.documentCol
+	default:
+		return fmt.Errorf("%w: unknown line kind %q", errInvalidBundle, line.Kind)
+	}
+
+	var record bson.D
+	if err := bson.UnmarshalExtJSON(line.Record, true, &record); err != nil {
+		return fmt.Errorf("%w: malformed %s record: %v", errInvalidBundle, line.Kind, err)
+	}
+
+	var id interface{}
+	generations := -1
+	for i, field := range record {
+		switch field.Key {
+		case "_id":
+			id = field.Value
+		case "generations":
+			generations = i
+		}
+	}
+	if id == nil {
+		return fmt.Errorf("%w: %s record has no ID", errInvalidBundle, line.Kind)
+	}
+
+	// The vectors were imported into the generation, whatever held them before.
+	if line.Kind != bundleRepositoryKind {
+		if generations < 0 {
+			record = append(record, bson.E{Key: "generations"})
+			generations = len(record) - 1
+		}
+		record[generations].Value = bson.A{generationID}
+	}
+
+	_, err := col.ReplaceOne(ctx, bson.M{"_id": id}, record, options.Replace().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("failed to restore %s record: %w", line.Kind, err)
+	}
+	return nil
+}
+
+// importPerson merges the contributions of a profile into the existing one,
+// so importing one repository keeps a person's other contributions.
+func importPerson(ctx context.Context, record json.RawMessage, ledger *ledger) error {
+	var person Person
+	if err := bson.UnmarshalExtJSON(record, true, &person); err != nil {
+		return fmt.Errorf("%w: malformed person record: %v", errInvalidBundle, err)
+	}
+
+	fields := bson.M{"name": person.Name, "contact": person.Contact}
+	for repoID, contribution := range person.Repositories {
+		fields["repositories."+repoID] = contribution
+	}
+
+	_, err := ledger.peopleCol.UpdateOne(ctx, bson.M{"_id": person.ID}, bson.M{"$set": fields}, options.Update().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("failed to restore person: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// memoryStore is a vector store that keeps its vectors in memory.
+type memoryStore struct {
+	dim     int
+	vectors []storedVector
+}
+
+func (s *memoryStore) dimension(ctx context.Context) (int, error) {
+	return s.dim, nil
+}
+
+func (s *memoryStore) fetch(ctx context.Context, ids []string) ([]storedVector, error) {
+	var vectors []storedVector
+	for _, id := range ids {
+		for _, vector := range s.vectors {
+			if vector.ID == id {
+				vectors = append(vectors, vector)
+			}
+		}
+	}
+	return vectors, nil
+}
+
+func (s *memoryStore) upsert(ctx context.Context, vectors []storedVector) error {
+	s.vectors = append(s.vectors, vectors...)
+	return nil
+}
+
+func (s *memoryStore) deleteAll(ctx context.Context) error {
+	s.vectors = nil
+	return nil
+}
+
+func writeTestBundle(t *testing.T, header bundleHeader, vectors ...storedVector) []byte {
+	var buf bytes.Buffer
+	bundle, err := newBundleWriter(&buf, header)
+	require.NoError(t, err)
+	for i := range vectors {
+		require.NoError(t, bundle.write(bundleLine{Kind: bundleVectorKind, Vector: &vectors[i]}))
+	}
+	require.NoError(t, bundle.close())
+	return buf.Bytes()
+}
+
+func testBundleHeader(dimension int) bundleHeader {
+	return bundleHeader{Version: bundleVersion, Model: embeddingModel.String(), Dimension: dimension}
+}
+
+func TestBundleRoundTrip(t *testing.T) {
+	vector := storedVector{
+		ID:       "repo:abc",
+		Values:   []float32{0.25, -1},
+		Metadata: map[string]interface{}{"repoId": "repo", "files": []interface{}{"main.go"}},
+	}
+	data := writeTestBundle(t, bundleHeader{Version: bundleVersion, Model: "model", Dimension: 2, Repository: "repo"}, vector)
+
+	bundle, err := newBundleReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, "model", bundle.header.Model)
+	require.Equal(t, "repo", bundle.header.Repository)
+
+	line, err := bundle.next()
+	require.NoError(t, err)
+	require.Equal(t, bundleVectorKind, line.Kind)
+	require.Equal(t, vector, *line.Vector)
+
+	_, err = bundle.next()
+	require.Equal(t, io.EOF, err)
+}
+
+func TestBundleRequiresHeader(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write([]byte(`{"kind":"vector","vector":{"id":"a","values":[1]}}` + "\n"))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	_, err = newBundleReader(&buf)
+	require.ErrorIs(t, err, errInvalidBundle)
+
+	_, err = newBundleReader(bytes.NewReader([]byte("not gzip")))
+	require.ErrorIs(t, err, errInvalidBundle)
+}
+
+func TestBundleCompatibility(t *testing.T) {
+	model := embeddingModel.String()
+	require.NoError(t, checkBundleCompatibility(testBundleHeader(1536), model, 1536))
+	require.NoError(t, checkBundleCompatibility(bundleHeader{Version: 1, Model: model, Dimension: 1536}, model, 1536))
+
+	tests := map[string]bundleHeader{
+		"version":     {Version: bundleVersion + 1, Model: embeddingModel.String(), Dimension: 1536},
+		"unversioned": {Model: embeddingModel.String(), Dimension: 1536},
+		"model":       {Version: bundleVersion, Model: "text-embedding-3-large", Dimension: 1536},
+		"dimension":   {Version: bundleVersion, Model: embeddingModel.String(), Dimension: 3072},
+	}
+	for name, header := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.ErrorIs(t, checkBundleCompatibility(header, model, 1536), errInvalidBundle)
+		})
+	}
+}
+
+func TestImportBundleChecksBeforeWriting(t *testing.T) {
+	vector := storedVector{ID: "a", Values: []float32{1, 2}}
+	generation := defaultIndexState().Active
+
+	store := &memoryStore{dim: 3}
+	_, err := importBundle(context.Background(), bytes.NewReader(writeTestBundle(t, testBundleHeader(2), vector)), nil, nil, generation, store)
+	require.ErrorIs(t, err, errInvalidBundle)
+	require.Empty(t, store.vectors)
+
+	store = &memoryStore{dim: 2}
+	short := storedVector{ID: "b", Values: []float32{1}}
+	_, err = importBundle(context.Background(), bytes.NewReader(writeTestBundle(t, testBundleHeader(2), short)), nil, nil, generation, store)
+	require.ErrorIs(t, err, errInvalidBundle)
+	require.Empty(t, store.vectors)
+
+	report, err := importBundle(context.Background(), bytes.NewReader(writeTestBundle(t, testBundleHeader(2), vector)), nil, nil, generation, store)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), report.Vectors)
+	require.Equal(t, []storedVector{vector}, store.vectors)
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// languageByExtension maps file extensions to languages or ecosystems.
+var languageByExtension = map[string]string{
+	".go":     "Go",
+	".ts":     "TypeScript",
+	".tsx":    "TypeScript",
+	".js":     "JavaScript",
+	".jsx":    "JavaScript",
+	".mjs":    "JavaScript",
+	".vue":    "Vue",
+	".py":     "Python",
+	".cs":     "C#",
+	".csproj": "C#",
+	".fs":     "F#",
+	".java":   "Java",
+	".kt":     "Kotlin",
+	".swift":  "Swift",
+	".rb":     "Ruby",
+	".rs":     "Rust",
+	".php":    "PHP",
+	".c":      "C",
+	".h":      "C",
+	".cpp":    "C++",
+	".cc":     "C++",
+	".hpp":    "C++",
+	".sh":     "Shell",
+	".bash":   "Shell",
+	".ps1":    "PowerShell",
+	".sql":    "SQL",
+	".html":   "HTML",
+	".css":    "CSS",
+	".scss":   "CSS",
+	".tf":     "Terraform",
+	".tfvars": "Terraform",
+	".bicep":  "Bicep",
+	".proto":  "Protobuf",
+	".yaml":   "YAML",
+	".yml":    "YAML",
+	".md":     "Markdown",
+}
+
+// languageByName recognises files by name, for files whose extension does
+// not tell the ecosystem.
+var languageByName = map[string]string{
+	"Dockerfile":         "Docker",
+	"docker-compose.yml": "Docker",
+	"Chart.yaml":         "Helm",
+	"go.mod":             "Go",
+	"package.json":       "JavaScript",
+	"angular.json":       "Angular",
+	"Makefile":           "Make",
+}
+
+// languageBySuffix recognises framework conventions in file names.
+var languageBySuffix = map[string]string{
+	".component.ts": "Angular",
+	".module.ts":    "Angular",
+	".service.ts":   "Angular",
+}
+
+// languageByInterpreter maps shebang interpreters to languages.
+var languageByInterpreter = map[string]string{
+	"sh":      "Shell",
+	"bash":    "Shell",
+	"zsh":     "Shell",
+	"python":  "Python",
+	"python3": "Python",
+	"node":    "JavaScript",
+	"pwsh":    "PowerShell",
+	"ruby":    "Ruby",
+	"perl":    "Perl",
+}
+
+// fileLanguage classifies a file by name and, failing that, by its shebang.
+func fileLanguage(file, shebang string) string {
+	base := path.Base(file)
+	if language, ok := languageByName[base]; ok {
+		return language
+	}
+	if strings.HasPrefix(file, "templates/") || strings.Contains(file, "/templates/") {
+		if ext := path.Ext(base); ext == ".yaml" || ext == ".tpl" {
+			return "Helm"
+		}
+	}
+	for suffix, language := range languageBySuffix {
+		if strings.HasSuffix(base, suffix) {
+			return language
+		}
+	}
+	if language, ok := languageByExtension[strings.ToLower(path.Ext(base))]; ok {
+		return language
+	}
+	return shebangLanguage(shebang)
+}
+
+func shebangLanguage(shebang string) string {
+	fields := strings.Fields(strings.TrimPrefix(shebang, "#!"))
+	if len(fields) == 0 {
+		return ""
+	}
+
+	interpreter := path.Base(fields[0])
+	if interpreter == "env" && len(fields) > 1 {
+		interpreter = fields[1]
+	}
+	return languageByInterpreter[interpreter]
+}
+
+var (
+	diffHeaderRE = regexp.MustCompile(`^diff --git a/.* b/(.*)$`)
+	firstHunkRE  = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+1(?:,\d+)? @@`)
+)
+
+// diffShebangs returns the shebang lines visible in a diff, by file. Only
+// hunks starting at the first line of a file can show it.
+func diffShebangs(diff string) map[string]string {
+	shebangs := map[string]string{}
+	file := ""
+	firstLine := false
+
+	for _, line := range strings.Split(diff, "\n") {
+		if match := diffHeaderRE.FindStringSubmatch(line); match != nil {
+			file = match[1]
+			firstLine = false
+			continue
+		}
+		if firstHunkRE.MatchString(line) {
+			firstLine = true
+			continue
+		}
+		if firstLine {
+			firstLine = false
+			content := strings.TrimLeft(line, "+ ")
+			if strings.HasPrefix(content, "#!") && !strings.HasPrefix(line, "-") {
+				shebangs[file] = content
+			}
+		}
+	}
+
+	return shebangs
+}
+
+// Component is a unit of a repository, identified by its manifest.
+type Component struct {
+	Name      string `json:"name" bson:"name"`
+	Path      string `json:"path" bson:"path"`
+	Ecosystem string `json:"ecosystem" bson:"ecosystem"`
+}
+
+// componentIndex maps directories to the component whose manifest they contain.
+type componentIndex struct {
+	mutex sync.RWMutex
+	byDir map[string]Component
+}
+
+func newComponentIndex() *componentIndex {
+	return &componentIndex{byDir: map[string]Component{}}
+}
+
+func (c *componentIndex) add(components []Component) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, component := range components {
+		c.byDir[component.Path] = component
+	}
+}
+
+// lookup returns the component of the nearest manifest above a file.
+func (c *componentIndex) lookup(file string) (Component, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	dir := path.Dir(file)
+	for {
+		if component, ok := c.byDir[dir]; ok {
+			return component, true
+		}
+		if dir == "." || dir == "/" {
+			return Component{}, false
+		}
+		dir = path.Dir(dir)
+	}
+}
+
+// classifyFiles returns the sorted, distinct languages and components of
+// the files changed by a commit.
+func classifyFiles(files []string, diff string, components *componentIndex) ([]string, []string) {
+	shebangs := diffShebangs(diff)
+	languages := map[string]bool{}
+	names := map[string]bool{}
+
+	for _, file := range files {
+		if language := fileLanguage(file, shebangs[file]); language != "" {
+			languages[language] = true
+		}
+		if components == nil {
+			continue
+		}
+		if component, ok := components.lookup(file); ok {
+			names[component.Name] = true
+		}
+	}
+
+	return sortedKeys(languages), sortedKeys(names)
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// manifestEcosystem returns the ecosystem of a manifest file, or "" if the
+// file is not a manifest.
+func manifestEcosystem(file string) string {
+	switch base := path.Base(file); {
+	case base == "go.mod":
+		return "go"
+	case base == "package.json":
+		return "npm"
+	case base == "Chart.yaml":
+		return "helm"
+	case strings.HasSuffix(base, ".csproj"):
+		return "dotnet"
+	default:
+		return ""
+	}
+}
+
+var (
+	goModuleRE  = regexp.MustCompile(`(?m)^module\s+(\S+)`)
+	chartNameRE = regexp.MustCompile(`(?m)^name:\s*["']?([^"'\s]+)`)
+)
+
+// readComponents finds the manifests at a commit and names a component after each.
+func readComponents(r *git.Repository, hash plumbing.Hash) ([]Component, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	commit, err := r.CommitObject(hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commit %s: %w", hash, err)
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tree of %s: %w", hash, err)
+	}
+
+	var components []Component
+	err = tree.Files().ForEach(func(file *object.File) error {
+		ecosystem := manifestEcosystem(file.Name)
+		if ecosystem == "" || strings.Contains(file.Name, "node_modules/") {
+			return nil
+		}
+
+		content := ""
+		if ecosystem != "dotnet" {
+			var err error
+			content, err = file.Contents()
+			if err != nil {
+				return err
+			}
+		}
+		components = append(components, Component{
+			Name:      componentName(file.Name, ecosystem, content),
+			Path:      path.Dir(file.Name),
+			Ecosystem: ecosystem,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifests of %s: %w", hash, err)
+	}
+
+	return components, nil
+}
+
+// componentName reads the name a manifest declares, falling back to the
+// manifest's directory.
+func componentName(file, ecosystem, content string) string {
+	name := ""
+	switch ecosystem {
+	case "go":
+		if match := goModuleRE.FindStringSubmatch(content); match != nil {
+			name = match[1]
+		}
+	case "npm":
+		var manifest struct {
+			Name string `json:"name"`
+		}
+		if json.Unmarshal([]byte(content), &manifest) == nil {
+			name = manifest.Name
+		}
+	case "helm":
+		if match := chartNameRE.FindStringSubmatch(content); match != nil {
+			name = match[1]
+		}
+	case "dotnet":
+		name = strings.TrimSuffix(path.Base(file), ".csproj")
+	}
+
+	if name != "" {
+		return name
+	}
+	if dir := path.Dir(file); dir != "." {
+		return dir
+	}
+	return path.Base(file)
+}
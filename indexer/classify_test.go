@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileLanguage(t *testing.T) {
+	for file, expected := range map[string]string{
+		"infra/main.tf":                        "Terraform",
+		"web/src/app/app.component.ts":         "Angular",
+		"web/src/main.ts":                      "TypeScript",
+		"charts/api/templates/deployment.yaml": "Helm",
+		"charts/api/Chart.yaml":                "Helm",
+		"build/Dockerfile":                     "Docker",
+		"src/Api/Api.csproj":                   "C#",
+		"scripts/release":                      "",
+	} {
+		require.Equal(t, expected, fileLanguage(file, ""), file)
+	}
+
+	require.Equal(t, "Python", fileLanguage("scripts/release", "#!/usr/bin/env python3"))
+	require.Equal(t, "Shell", fileLanguage("scripts/release", "#!/bin/bash -e"))
+}
+
+func TestDiffShebangs(t *testing.T) {
+	diff := `diff --git a/scripts/release b/scripts/release
+new file mode 100755
+--- /dev/null
++++ b/scripts/release
+@@ -0,0 +1,2 @@
++#!/usr/bin/env python3
++print("release")
+diff --git a/scripts/deploy b/scripts/deploy
+--- a/scripts/deploy
++++ b/scripts/deploy
+@@ -10,2 +10,3 @@
+ #!/bin/bash
++echo deploy
+`
+
+	require.Equal(t, map[string]string{"scripts/release": "#!/usr/bin/env python3"}, diffShebangs(diff))
+}
+
+func TestClassifyFilesByNearestManifest(t *testing.T) {
+	components := newComponentIndex()
+	components.add([]Component{
+		{Name: "github.com/example/service", Path: ".", Ecosystem: "go"},
+		{Name: "@example/web", Path: "web", Ecosystem: "npm"},
+		{Name: "api", Path: "charts/api", Ecosystem: "helm"},
+	})
+
+	languages, names := classifyFiles([]string{
+		"cmd/server/main.go",
+		"web/src/app/app.component.ts",
+		"charts/api/values.yaml",
+	}, "", components)
+
+	require.Equal(t, []string{"Angular", "Go", "YAML"}, languages)
+	require.Equal(t, []string{"@example/web", "api", "github.com/example/service"}, names)
+}
+
+func TestComponentName(t *testing.T) {
+	require.Equal(t, "github.com/example/service", componentName("go.mod", "go", "module github.com/example/service\n\ngo 1.19\n"))
+	require.Equal(t, "@example/web", componentName("web/package.json", "npm", `{"name": "@example/web"}`))
+	require.Equal(t, "api", componentName("charts/api/Chart.yaml", "helm", "apiVersion: v2\nname: api\n"))
+	require.Equal(t, "Example.Api", componentName("src/Example.Api.csproj", "dotnet", ""))
+	require.Equal(t, "web", componentName("web/package.json", "npm", "{}"))
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// codeOwnersLocations are the places GitHub and GitLab look for a CODEOWNERS
+// file, in the order they are searched.
+var codeOwnersLocations = []string{".github/CODEOWNERS", "CODEOWNERS", "docs/CODEOWNERS"}
+
+// CodeOwners are the rules of the CODEOWNERS file of a branch.
+type CodeOwners struct {
+	Path  string          `json:"path" bson:"path"`
+	Rules []CodeOwnerRule `json:"rules" bson:"rules"`
+}
+
+// CodeOwnerRule assigns owners to the paths matching a pattern.
+type CodeOwnerRule struct {
+	Pattern string   `json:"pattern" bson:"pattern"`
+	Owners  []string `json:"owners" bson:"owners"`
+}
+
+// readCodeOwners parses the CODEOWNERS file at a commit. It returns nil if
+// the commit has none.
+func readCodeOwners(r *git.Repository, hash plumbing.Hash) (*CodeOwners, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	commit, err := r.CommitObject(hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commit %s: %w", hash, err)
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tree of %s: %w", hash, err)
+	}
+
+	for _, location := range codeOwnersLocations {
+		file, err := tree.File(location)
+		if err == object.ErrFileNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", location, err)
+		}
+
+		content, err := file.Contents()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", location, err)
+		}
+
+		return &CodeOwners{Path: location, Rules: parseCodeOwners(content)}, nil
+	}
+
+	return nil, nil
+}
+
+// parseCodeOwners parses CODEOWNERS content into rules, in file order.
+// GitLab section headers are skipped; their rules apply like any other.
+func parseCodeOwners(content string) []CodeOwnerRule {
+	rules := []CodeOwnerRule{}
+
+	for _, line := range strings.Split(content, "\n") {
+		if i := strings.Index(line, " #"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+
+		if line == "" || strings.HasPrefix(line, "#") || isCodeOwnersSection(line) {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		rules = append(rules, CodeOwnerRule{
+			Pattern: strings.ReplaceAll(fields[0], `\#`, "#"),
+			Owners:  fields[1:],
+		})
+	}
+
+	return rules
+}
+
+func isCodeOwnersSection(line string) bool {
+	line = strings.TrimPrefix(line, "^")
+	return strings.HasPrefix(line, "[") && strings.Contains(line, "]")
+}
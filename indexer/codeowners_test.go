@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCodeOwners(t *testing.T) {
+	content := `# Default owners
+*       @MicrosoftDocs/docs-team
+
+/articles/aks/   @aks-docs jane@example.com # AKS articles
+
+[Networking]
+articles/virtual-network/** @vnet-docs
+docs/\#private.md @security
+`
+
+	rules := parseCodeOwners(content)
+	require.Equal(t, []CodeOwnerRule{
+		{Pattern: "*", Owners: []string{"@MicrosoftDocs/docs-team"}},
+		{Pattern: "/articles/aks/", Owners: []string{"@aks-docs", "jane@example.com"}},
+		{Pattern: "articles/virtual-network/**", Owners: []string{"@vnet-docs"}},
+		{Pattern: "docs/#private.md", Owners: []string{"@security"}},
+	}, rules)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const usage = `Usage:
+  indexer                          receive indexing jobs from the queue
+  indexer dry-run <url> [walker]   estimate commits, chunks, tokens and cost
+  indexer export <file> [repo-id]  export the index, or one repository, to a bundle
+  indexer import <file>            restore a bundle into the configured vector store
+  indexer config print             print the effective configuration, secrets redacted`
+
+func runCommand(ctx context.Context, args []string) error {
+	if args[0] == "config" {
+		return runConfig(args[1:])
+	}
+	if err := config.validate(false); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "dry-run":
+		return runDryRun(ctx, args[1:])
+	case "export":
+		return runExport(ctx, args[1:])
+	case "import":
+		return runImport(ctx, args[1:])
+	default:
+		return fmt.Errorf("unknown command %q\n%s", args[0], usage)
+	}
+}
+
+// runConfig prints the configuration, which may be invalid, and then
+// reports what is wrong with it.
+func runConfig(args []string) error {
+	if len(args) != 1 || args[0] != "print" {
+		return fmt.Errorf("unknown config command\n%s", usage)
+	}
+	if err := printConfig(os.Stdout, config); err != nil {
+		return err
+	}
+	return config.validate(false)
+}
+
+func runDryRun(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing repository URL\n%s", usage)
+	}
+
+	repo := Repository{URL: args[0]}
+	if len(args) > 1 {
+		repo.Walker = args[1]
+	}
+
+	job := newDryRunJob(repo)
+	err := processRepository(ctx, job, "")
+	if err != nil {
+		return err
+	}
+
+	return printReport(job.report())
+}
+
+func runExport(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing bundle file\n%s", usage)
+	}
+
+	var repoID string
+	if len(args) > 1 {
+		repoID = args[1]
+	}
+
+	return withBundleStores(ctx, func(repoCol *mongo.Collection, ledger *ledger, generation Generation, store vectorStore) error {
+		file, err := os.Create(args[0])
+		if err != nil {
+			return fmt.Errorf("failed to create bundle: %w", err)
+		}
+		defer file.Close()
+
+		report, err := exportBundle(ctx, file, repoID, repoCol, ledger, generation, store)
+		if err != nil {
+			os.Remove(args[0])
+			return err
+		}
+		return printReport(report)
+	})
+}
+
+func runImport(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing bundle file\n%s", usage)
+	}
+
+	return withBundleStores(ctx, func(repoCol *mongo.Collection, ledger *ledger, generation Generation, store vectorStore) error {
+		file, err := os.Open(args[0])
+		if err != nil {
+			return fmt.Errorf("failed to open bundle: %w", err)
+		}
+		defer file.Close()
+
+		report, err := importBundle(ctx, file, repoCol, ledger, generation, store)
+		if err != nil {
+			return err
+		}
+		return printReport(report)
+	})
+}
+
+// withBundleStores connects to the database and the vector store of the
+// active generation, which a bundle is exported from or imported into.
+func withBundleStores(ctx context.Context, run func(*mongo.Collection, *ledger, Generation, vectorStore) error) error {
+	client, err := connectDatabase(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer client.Disconnect(ctx)
+
+	repoDB := client.Database("repositoryDB")
+	ledger := newLedger(repoDB)
+	generation, store, err := activeStore(ctx, ledger)
+	if err != nil {
+		return err
+	}
+	return run(repoDB.Collection("repositories"), ledger, generation, store)
+}
+
+func printReport(report interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v3"
+)
+
+// configFileEnv names the YAML file the configuration is read from. Without
+// it the configuration comes from the defaults and the environment.
+const configFileEnv = "CONFIG_FILE"
+
+const redacted = "<redacted>"
+
+// config is the configuration of the indexer, loaded at startup.
+var config = defaultConfig()
+
+// Config is the configuration of the indexer. Every field can be set in the
+// YAML file and overridden by the environment variable in its env tag.
+// Fields tagged secret are redacted when the configuration is printed.
+type Config struct {
+	LogLevel    string            `yaml:"log_level" env:"LOG_LEVEL"`
+	Tracing     TracingConfig     `yaml:"tracing"`
+	Database    DatabaseConfig    `yaml:"database"`
+	ServiceBus  ServiceBusConfig  `yaml:"service_bus"`
+	OpenAI      OpenAIConfig      `yaml:"openai"`
+	VectorStore VectorStoreConfig `yaml:"vector_store"`
+	Git         GitConfig         `yaml:"git"`
+	Worker      WorkerConfig      `yaml:"worker"`
+	Budget      BudgetConfig      `yaml:"budget"`
+	Privacy     PrivacyConfig     `yaml:"privacy"`
+	Generations GenerationsConfig `yaml:"generations"`
+	HTTP        HTTPConfig        `yaml:"http"`
+}
+
+type TracingConfig struct {
+	// OTLPEndpoint is the base URL spans are exported to over OTLP/HTTP.
+	OTLPEndpoint string `yaml:"otlp_endpoint" env:"OTEL_EXPORTER_OTLP_ENDPOINT"`
+}
+
+type DatabaseConfig struct {
+	ConnectionString string `yaml:"connection_string" env:"COSMOS_DB_CONNECTION_STRING" secret:"true"`
+}
+
+type ServiceBusConfig struct {
+	ConnectionString string `yaml:"connection_string" env:"AZURE_SERVICE_BUS_CONNECTION_STRING" secret:"true"`
+	QueueName        string `yaml:"queue_name" env:"QUEUE_NAME"`
+}
+
+type OpenAIConfig struct {
+	APIKey string `yaml:"api_key" env:"OPEN_AI_KEY" secret:"true"`
+}
+
+type VectorStoreConfig struct {
+	Kind           string `yaml:"kind" env:"VECTOR_STORE"`
+	PineconeURL    string `yaml:"pinecone_url" env:"PINECONE_API_URL"`
+	PineconeAPIKey string `yaml:"pinecone_api_key" env:"PINECONE_API_KEY" secret:"true"`
+}
+
+type GitConfig struct {
+	// TempFolder holds the clones, the system temporary directory if empty.
+	TempFolder string `yaml:"temp_folder" env:"TEMP_FOLDER"`
+	CloneDepth int    `yaml:"clone_depth" env:"GIT_CLONE_DEPTH"`
+}
+
+type WorkerConfig struct {
+	// Concurrency is the number of repositories indexed at once.
+	Concurrency int `yaml:"concurrency" env:"INDEXER_CONCURRENCY"`
+	// CommitConcurrency is the number of commits of a job processed at once.
+	CommitConcurrency  int `yaml:"commit_concurrency" env:"COMMIT_CONCURRENCY"`
+	LockRenewalSeconds int `yaml:"lock_renewal_seconds" env:"LOCK_RENEWAL_SECONDS"`
+}
+
+type BudgetConfig struct {
+	// GlobalMonthlyTokens caps the tokens of all repositories together, 0
+	// for no cap.
+	GlobalMonthlyTokens int64 `yaml:"global_monthly_tokens" env:"GLOBAL_MONTHLY_TOKEN_BUDGET"`
+}
+
+// minPseudonymSecretLength keeps pseudonym secrets from being guessed.
+const minPseudonymSecretLength = 16
+
+type PrivacyConfig struct {
+	// PseudonymSecret keys the pseudonyms of contributors. Repositories with
+	// the pseudonym privacy mode are not indexed without it.
+	PseudonymSecret string `yaml:"pseudonym_secret" env:"PSEUDONYM_SECRET" secret:"true"`
+}
+
+type GenerationsConfig struct {
+	RetentionMinutes int `yaml:"retention_minutes" env:"GENERATION_RETENTION_MINUTES"`
+}
+
+type HTTPConfig struct {
+	// Port serves the metrics, the health endpoints and the admin API.
+	Port string `yaml:"port" env:"METRICS_PORT"`
+	// AdminToken enables the admin API, which is disabled without one.
+	AdminToken string `yaml:"admin_token" env:"ADMIN_API_TOKEN" secret:"true"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		LogLevel:    "info",
+		VectorStore: VectorStoreConfig{Kind: "pinecone"},
+		Git:         GitConfig{CloneDepth: 20000},
+		Worker: WorkerConfig{
+			Concurrency:        1,
+			CommitConcurrency:  50,
+			LockRenewalSeconds: 30,
+		},
+		Generations: GenerationsConfig{RetentionMinutes: 60},
+		HTTP:        HTTPConfig{Port: "9090"},
+	}
+}
+
+// loadConfig reads the defaults, then the file at CONFIG_FILE, then the
+// environment. Unknown keys in the file are errors, so typos do not go
+// unnoticed.
+func loadConfig() (Config, error) {
+	c := defaultConfig()
+	if path := os.Getenv(configFileEnv); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return c, fmt.Errorf("failed to read config file: %w", err)
+		}
+
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&c); err != nil && err != io.EOF {
+			return c, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	}
+
+	return c, applyEnv(reflect.ValueOf(&c).Elem())
+}
+
+// applyEnv overrides fields by the environment variables in their env tags.
+// Empty variables are ignored.
+func applyEnv(v reflect.Value) error {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			if err := applyEnv(field); err != nil {
+				return err
+			}
+			continue
+		}
+
+		name := v.Type().Field(i).Tag.Get("env")
+		value := os.Getenv(name)
+		if name == "" || value == "" {
+			continue
+		}
+
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(value)
+		case reflect.Int, reflect.Int64:
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("%s must be an integer, got %q", name, value)
+			}
+			field.SetInt(n)
+		}
+	}
+	return nil
+}
+
+// validate reports every invalid setting at once. The connections to the
+// database and the queue are only required by the worker.
+func (c Config) validate(worker bool) error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	if worker {
+		check(c.Database.ConnectionString != "", "database.connection_string (COSMOS_DB_CONNECTION_STRING) is required")
+		check(c.ServiceBus.ConnectionString != "", "service_bus.connection_string (AZURE_SERVICE_BUS_CONNECTION_STRING) is required")
+		check(c.ServiceBus.QueueName != "", "service_bus.queue_name (QUEUE_NAME) is required")
+	}
+
+	_, err := zapcore.ParseLevel(c.LogLevel)
+	check(err == nil, "log_level (LOG_LEVEL) must be debug, info, warn or error, got %q", c.LogLevel)
+	check(c.Tracing.OTLPEndpoint == "" || validURL(c.Tracing.OTLPEndpoint),
+		"tracing.otlp_endpoint (OTEL_EXPORTER_OTLP_ENDPOINT) must be an http or https URL, got %q", c.Tracing.OTLPEndpoint)
+	check(c.VectorStore.Kind == "pinecone", "vector_store.kind (VECTOR_STORE) must be pinecone, got %q", c.VectorStore.Kind)
+	check(c.VectorStore.PineconeURL == "" || validURL(c.VectorStore.PineconeURL),
+		"vector_store.pinecone_url (PINECONE_API_URL) must be an http or https URL, got %q", c.VectorStore.PineconeURL)
+	check(c.Git.CloneDepth > 0, "git.clone_depth (GIT_CLONE_DEPTH) must be positive, got %d", c.Git.CloneDepth)
+	check(c.Worker.Concurrency > 0, "worker.concurrency (INDEXER_CONCURRENCY) must be positive, got %d", c.Worker.Concurrency)
+	check(c.Worker.CommitConcurrency > 0, "worker.commit_concurrency (COMMIT_CONCURRENCY) must be positive, got %d", c.Worker.CommitConcurrency)
+	check(c.Worker.LockRenewalSeconds > 0, "worker.lock_renewal_seconds (LOCK_RENEWAL_SECONDS) must be positive, got %d", c.Worker.LockRenewalSeconds)
+	check(c.Budget.GlobalMonthlyTokens >= 0, "budget.global_monthly_tokens (GLOBAL_MONTHLY_TOKEN_BUDGET) must not be negative, got %d", c.Budget.GlobalMonthlyTokens)
+	check(c.Privacy.PseudonymSecret == "" || len(c.Privacy.PseudonymSecret) >= minPseudonymSecretLength,
+		"privacy.pseudonym_secret (PSEUDONYM_SECRET) must be at least %d characters", minPseudonymSecretLength)
+	check(c.Generations.RetentionMinutes > 0, "generations.retention_minutes (GENERATION_RETENTION_MINUTES) must be positive, got %d", c.Generations.RetentionMinutes)
+	port, err := strconv.Atoi(c.HTTP.Port)
+	check(err == nil && port > 0 && port < 65536, "http.port (METRICS_PORT) must be a port number, got %q", c.HTTP.Port)
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+func validURL(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// redacted returns a copy of the configuration with the secrets that are
+// set replaced.
+func (c Config) redacted() Config {
+	redactSecrets(reflect.ValueOf(&c).Elem())
+	return c
+}
+
+func redactSecrets(v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			redactSecrets(field)
+		} else if v.Type().Field(i).Tag.Get("secret") == "true" && field.String() != "" {
+			field.SetString(redacted)
+		}
+	}
+}
+
+// printConfig writes the configuration as YAML with its secrets redacted.
+func printConfig(w io.Writer, c Config) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(c.redacted()); err != nil {
+		return fmt.Errorf("failed to print config: %w", err)
+	}
+	return encoder.Close()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// useConfig replaces the configuration with the defaults changed by modify
+// for the duration of a test.
+func useConfig(t *testing.T, modify func(c *Config)) {
+	previous := config
+	config = defaultConfig()
+	modify(&config)
+	t.Cleanup(func() { config = previous })
+}
+
+func writeConfigFile(t *testing.T, content string) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	t.Setenv(configFileEnv, path)
+}
+
+func TestConfigEnvironmentOverridesFile(t *testing.T) {
+	writeConfigFile(t, "git:\n  clone_depth: 100\nworker:\n  concurrency: 4\nservice_bus:\n  queue_name: jobs\n")
+	t.Setenv("INDEXER_CONCURRENCY", "8")
+	t.Setenv("QUEUE_NAME", "")
+
+	c, err := loadConfig()
+	require.NoError(t, err)
+	require.Equal(t, 100, c.Git.CloneDepth)
+	require.Equal(t, 8, c.Worker.Concurrency)
+	require.Equal(t, "jobs", c.ServiceBus.QueueName)
+	require.Equal(t, 50, c.Worker.CommitConcurrency)
+}
+
+func TestConfigRejectsInvalidInput(t *testing.T) {
+	writeConfigFile(t, "git:\n  clone_dept: 100\n")
+	_, err := loadConfig()
+	require.ErrorContains(t, err, "clone_dept")
+
+	writeConfigFile(t, "")
+	t.Setenv("LOCK_RENEWAL_SECONDS", "soon")
+	_, err = loadConfig()
+	require.EqualError(t, err, `LOCK_RENEWAL_SECONDS must be an integer, got "soon"`)
+}
+
+func TestConfigValidation(t *testing.T) {
+	require.NoError(t, defaultConfig().validate(false))
+
+	c := defaultConfig()
+	c.Worker.CommitConcurrency = 0
+	c.VectorStore.Kind = "qdrant"
+	err := c.validate(true)
+	require.ErrorContains(t, err, "database.connection_string (COSMOS_DB_CONNECTION_STRING) is required")
+	require.ErrorContains(t, err, "service_bus.queue_name (QUEUE_NAME) is required")
+	require.ErrorContains(t, err, "worker.commit_concurrency (COMMIT_CONCURRENCY) must be positive, got 0")
+	require.ErrorContains(t, err, `vector_store.kind (VECTOR_STORE) must be pinecone, got "qdrant"`)
+}
+
+func TestConfigPrintRedactsSecrets(t *testing.T) {
+	c := defaultConfig()
+	c.OpenAI.APIKey = "sk-secret"
+	c.ServiceBus.QueueName = "jobs"
+
+	var out bytes.Buffer
+	require.NoError(t, printConfig(&out, c))
+	require.NotContains(t, out.String(), "sk-secret")
+	require.Contains(t, out.String(), "api_key: "+redacted)
+	require.Contains(t, out.String(), "queue_name: jobs")
+	require.Contains(t, out.String(), "pseudonym_secret: \"\"")
+	require.Equal(t, "sk-secret", c.OpenAI.APIKey)
+}
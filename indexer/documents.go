@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// defaultDocumentGlobs select the documents that are embedded as they are at
+// the head of a branch when a repository does not configure its own.
+var defaultDocumentGlobs = []string{"**/README.md", "docs/**", "adr/**"}
+
+const (
+	maxDocumentSize         = 1 << 20
+	maxDocumentContributors = 3
+)
+
+// errBinaryDocument marks files matching the document globs that are not
+// text. They are skipped rather than counted as failures.
+var errBinaryDocument = errors.New("document is binary")
+
+// documentFile is a file at a branch head that matches the document globs.
+type documentFile struct {
+	Path string
+	Blob plumbing.Hash
+}
+
+// snapshotDocuments embeds the current content of the documents at a branch
+// head. A document is only embedded again when its blob hash changed, and
+// documents that no longer exist at the head are removed.
+func snapshotDocuments(ctx context.Context, r *git.Repository, branch string, head plumbing.Hash, job *indexJob) error {
+	globs, err := compileDocumentGlobs(job.repo.DocumentGlobs)
+	if err != nil {
+		return err
+	}
+
+	files, err := listDocuments(r, head, globs)
+	if err != nil {
+		return err
+	}
+
+	log := loggerFrom(ctx)
+	log.Infow("Snapshotting documents", "branch", branch, "documents", len(files))
+	seen := make(map[string]bool, len(files))
+	for _, file := range files {
+		if stopRequested(ctx) {
+			return errShuttingDown
+		}
+		seen[file.Path] = true
+
+		err := snapshotDocument(ctx, r, branch, file, job)
+		if errors.Is(err, errBudgetExceeded) {
+			return err
+		}
+		if errors.Is(err, errBinaryDocument) {
+			// A document that became binary is removed with the stale ones.
+			delete(seen, file.Path)
+			log.Debugw("Skipping binary document", "branch", branch, "path", file.Path)
+			continue
+		}
+		if err != nil {
+			job.documentFailed()
+			log.Warnw("Failed to snapshot document", "branch", branch, "path", file.Path, "error", err)
+		}
+	}
+
+	if job.dryRun {
+		return nil
+	}
+	return removeStaleDocuments(ctx, job, branch, seen)
+}
+
+func snapshotDocument(ctx context.Context, r *git.Repository, branch string, file documentFile, job *indexJob) error {
+	id := documentID(job.repo.ID, branch, file.Path)
+	previous, err := job.ledger.document(ctx, id)
+	if err != nil {
+		return err
+	}
+	if previous != nil && previous.Blob == file.Blob.String() {
+		return nil
+	}
+
+	content, err := readBlob(r, file.Blob)
+	if err != nil {
+		return err
+	}
+	content = job.repo.Privacy.maskEmails(job.scrub(content))
+
+	contributors, err := job.ledger.topContributors(ctx, job.repo.ID, file.Path, maxDocumentContributors)
+	if err != nil {
+		return err
+	}
+
+	inputs := documentEmbeddingInputs(file.Path, branch, job.repo.URL, contributors, content)
+	if job.dryRun {
+		var tokens int64
+		for _, input := range inputs {
+			tokens += estimateTokens(input.Text)
+		}
+		job.documentProcessed(len(inputs), tokens)
+		return nil
+	}
+
+	metadata := map[string]interface{}{
+		"repoId":  job.repo.ID,
+		"repoUrl": job.repo.URL,
+		"kind":    "document",
+		"path":    file.Path,
+		"branch":  branch,
+		"blob":    file.Blob.String(),
+		"files":   []interface{}{file.Path},
+	}
+	languages, components := classifyFiles([]string{file.Path}, "", job.components)
+	metadata["languages"] = stringsToInterfaces(languages)
+	metadata["components"] = stringsToInterfaces(components)
+
+	names := make([]string, 0, len(contributors))
+	for _, contributor := range contributors {
+		names = append(names, contributor.String())
+	}
+	metadata["contributors"] = stringsToInterfaces(names)
+	if len(contributors) > 0 {
+		if job.repo.Privacy.sharesPerson() {
+			metadata["person"] = contributors[0].Person
+		}
+		metadata["author"] = contributors[0].Author
+		metadata["contact"] = contributors[0].Contact
+	}
+
+	embeddings, tokens, err := generateEmbeddings(ctx, job, inputs, metadata)
+	if err != nil {
+		if errors.Is(err, errBudgetExceeded) {
+			return err
+		}
+		return fmt.Errorf("failed to generate embeddings: %w", err)
+	}
+
+	err = job.store().storeEmbeddings(ctx, embeddings)
+	if err != nil {
+		return fmt.Errorf("failed to store embeddings in Pinecone: %w", err)
+	}
+
+	vectorIDs := make([]string, 0, len(embeddings))
+	for _, embedding := range embeddings {
+		vectorIDs = append(vectorIDs, embedding.Id)
+	}
+
+	// A shorter document needs fewer chunks than its previous version.
+	if previous != nil {
+		err = job.deleteVectors(ctx, subtractStrings(previous.VectorIDs, vectorIDs))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = job.ledger.recordDocument(ctx, DocumentEntry{
+		ID:           id,
+		RepoID:       job.repo.ID,
+		Branch:       branch,
+		Path:         file.Path,
+		Blob:         file.Blob.String(),
+		Contributors: contributors,
+		VectorIDs:    vectorIDs,
+		Generations:  []string{job.index.Active.ID},
+	})
+	if err != nil {
+		return err
+	}
+
+	job.documentProcessed(len(embeddings), tokens)
+	return nil
+}
+
+func removeStaleDocuments(ctx context.Context, job *indexJob, branch string, seen map[string]bool) error {
+	documents, err := job.ledger.documents(ctx, job.repo.ID, branch)
+	if err != nil {
+		return err
+	}
+
+	for _, document := range documents {
+		if seen[document.Path] {
+			continue
+		}
+
+		loggerFrom(ctx).Infow("Removing document", "branch", branch, "path", document.Path)
+		err = job.deleteVectors(ctx, document.VectorIDs)
+		if err != nil {
+			return err
+		}
+		err = job.ledger.deleteDocument(ctx, document.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// listDocuments returns the files at a commit that match one of the globs.
+func listDocuments(r *git.Repository, hash plumbing.Hash, globs []*regexp.Regexp) ([]documentFile, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	commit, err := r.CommitObject(hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commit %s: %w", hash, err)
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tree of %s: %w", hash, err)
+	}
+
+	var files []documentFile
+	err = tree.Files().ForEach(func(file *object.File) error {
+		if file.Mode.IsFile() && file.Size <= maxDocumentSize && matchesAny(globs, file.Name) {
+			files = append(files, documentFile{Path: file.Name, Blob: file.Hash})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files of %s: %w", hash, err)
+	}
+
+	return files, nil
+}
+
+// readBlob returns the content of a blob. Binary content is rejected.
+func readBlob(r *git.Repository, hash plumbing.Hash) (string, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	blob, err := r.BlobObject(hash)
+	if err != nil {
+		return "", fmt.Errorf("failed to get blob %s: %w", hash, err)
+	}
+
+	reader, err := blob.Reader()
+	if err != nil {
+		return "", fmt.Errorf("failed to read blob %s: %w", hash, err)
+	}
+	defer reader.Close()
+
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return "", fmt.Errorf("failed to read blob %s: %w", hash, err)
+	}
+	if bytes.IndexByte(content, 0) >= 0 {
+		return "", fmt.Errorf("%w: blob %s", errBinaryDocument, hash)
+	}
+
+	return string(content), nil
+}
+
+func documentEmbeddingInputs(path, branch, repoURL string, contributors []DocumentContributor, content string) []embeddingInput {
+	header := fmt.Sprintf("Document: %s\nBranch: %s\nRepoURL:\n%s\n", path, branch, repoURL)
+	if len(contributors) > 0 {
+		names := make([]string, 0, len(contributors))
+		for _, contributor := range contributors {
+			names = append(names, contributor.String())
+		}
+		header += fmt.Sprintf("Author: %s\nEmail: %s\nTop-Contributors: %s\n", contributors[0].Author, contributors[0].Contact, strings.Join(names, ", "))
+	}
+
+	key := documentKey(branch, path)
+	chunks := chunkString(content, maxDiffStringLength)
+	if len(chunks) == 0 {
+		chunks = []string{""}
+	}
+
+	inputs := make([]embeddingInput, 0, chunkCutoffThreshold+1)
+	for i, chunk := range chunks {
+		if i > chunkCutoffThreshold {
+			break
+		}
+
+		id := key
+		if i != 0 {
+			id = fmt.Sprintf("%s-%d", key, i)
+		}
+		inputs = append(inputs, embeddingInput{ID: id, Text: header + "Content:\n" + chunk})
+	}
+
+	return inputs
+}
+
+// documentKey identifies a document within its repository. Paths are hashed
+// to keep vector IDs short.
+func documentKey(branch, path string) string {
+	hash := sha256.Sum256([]byte(branch + "\x00" + path))
+	return "doc-" + hex.EncodeToString(hash[:8])
+}
+
+func documentID(repoID, branch, path string) string {
+	return fmt.Sprintf("%s:%s:%s", repoID, branch, path)
+}
+
+func compileDocumentGlobs(globs []string) ([]*regexp.Regexp, error) {
+	if len(globs) == 0 {
+		globs = defaultDocumentGlobs
+	}
+
+	compiled := make([]*regexp.Regexp, 0, len(globs))
+	for _, glob := range globs {
+		re, err := regexp.Compile(documentGlobRegexp(glob))
+		if err != nil {
+			return nil, fmt.Errorf("invalid document glob %q: %w", glob, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// documentGlobRegexp translates a glob into a regular expression over the
+// full path. "*" and "?" stay within a directory, "**" spans directories.
+func documentGlobRegexp(glob string) string {
+	glob = strings.TrimPrefix(glob, "/")
+
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			expr.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case glob[i] == '*':
+			expr.WriteString("[^/]*")
+		case glob[i] == '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	expr.WriteString("$")
+	return expr.String()
+}
+
+func matchesAny(globs []*regexp.Regexp, path string) bool {
+	for _, glob := range globs {
+		if glob.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+func subtractStrings(values, remove []string) []string {
+	removed := make(map[string]bool, len(remove))
+	for _, value := range remove {
+		removed[value] = true
+	}
+
+	var result []string
+	for _, value := range values {
+		if !removed[value] {
+			result = append(result, value)
+		}
+	}
+	return result
+}
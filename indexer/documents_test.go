@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDocumentGlobs(t *testing.T) {
+	globs, err := compileDocumentGlobs(nil)
+	require.NoError(t, err)
+
+	for path, expected := range map[string]bool{
+		"README.md":                 true,
+		"src/api/README.md":         true,
+		"docs/index.md":             true,
+		"docs/guides/setup.md":      true,
+		"adr/0001-use-pinecone.md":  true,
+		"src/docs/index.md":         false,
+		"READMEs.md":                false,
+		"src/api/README.md.orig":    false,
+		"adrenaline/0001-notes.txt": false,
+	} {
+		require.Equal(t, expected, matchesAny(globs, path), path)
+	}
+
+	globs, err = compileDocumentGlobs([]string{"architecture/*.md"})
+	require.NoError(t, err)
+	require.True(t, matchesAny(globs, "architecture/overview.md"))
+	require.False(t, matchesAny(globs, "architecture/legacy/overview.md"))
+}
+
+func TestDocumentEmbeddingInputs(t *testing.T) {
+	contributors := []DocumentContributor{
+		{Person: "a", Author: "Jane Doe", Contact: "jane@example.com", Commits: 4},
+		{Person: "b", Author: "John Roe", Contact: "john@example.com", Commits: 1},
+	}
+
+	inputs := documentEmbeddingInputs("docs/index.md", "main", "https://example.com/repo.git", contributors, "# Overview")
+	require.Len(t, inputs, 1)
+	require.Equal(t, documentKey("main", "docs/index.md"), inputs[0].ID)
+	require.Contains(t, inputs[0].Text, "Author: Jane Doe\nEmail: jane@example.com\n")
+	require.Contains(t, inputs[0].Text, "Top-Contributors: Jane Doe <jane@example.com> (4 commits), John Roe <john@example.com> (1 commits)")
+	require.Contains(t, inputs[0].Text, "Content:\n# Overview")
+	require.NotEqual(t, documentKey("master", "docs/index.md"), inputs[0].ID)
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// errGenerationBuilding is returned when a migration is requested while
+// another one is still building.
+var errGenerationBuilding = errors.New("another generation is being built")
+
+const (
+	indexStateID        = "index"
+	initialGenerationID = "gen-1"
+)
+
+// Generation is one version of the vector index: the vectors of all ledger
+// entries embedded with one model into one namespace of a Pinecone index.
+type Generation struct {
+	ID    string `json:"id" bson:"id"`
+	Model string `json:"model" bson:"model"`
+	// Index is the URL of the Pinecone index, empty for PINECONE_API_URL.
+	// A model with another dimension needs an index of its own.
+	Index     string `json:"index,omitempty" bson:"index,omitempty"`
+	Namespace string `json:"namespace" bson:"namespace"`
+
+	// Entries and Migrated count the ledger entries while the generation
+	// is built.
+	Entries  int64 `json:"entries,omitempty" bson:"entries,omitempty"`
+	Migrated int64 `json:"migrated,omitempty" bson:"migrated,omitempty"`
+
+	CreatedAt   time.Time  `json:"created_at" bson:"created_at"`
+	ActivatedAt *time.Time `json:"activated_at,omitempty" bson:"activated_at,omitempty"`
+	RetiredAt   *time.Time `json:"retired_at,omitempty" bson:"retired_at,omitempty"`
+}
+
+// embeddingModel returns the model the generation embeds with.
+func (g Generation) embeddingModel() (openai.EmbeddingModel, error) {
+	return parseEmbeddingModel(g.Model)
+}
+
+// coverage returns the percentage of ledger entries migrated into the generation.
+func (g Generation) coverage() float64 {
+	if g.Entries == 0 {
+		return 100
+	}
+	return float64(g.Migrated) / float64(g.Entries) * 100
+}
+
+// IndexState records the generations of the vector index. Chat and indexing
+// jobs read the active generation; a migration builds the next one, and
+// retired generations are kept until they are garbage-collected.
+type IndexState struct {
+	ID       string       `json:"-" bson:"_id"`
+	Active   Generation   `json:"active" bson:"active"`
+	Building *Generation  `json:"building,omitempty" bson:"building,omitempty"`
+	Retired  []Generation `json:"retired,omitempty" bson:"retired,omitempty"`
+}
+
+// defaultIndexState is the state of an index that was never migrated: all
+// vectors are in the default namespace of PINECONE_API_URL.
+func defaultIndexState() IndexState {
+	return IndexState{
+		ID:     indexStateID,
+		Active: Generation{ID: initialGenerationID, Model: embeddingModel.String()},
+	}
+}
+
+// live returns every generation that holds vectors, so deletes reach all of them.
+func (s IndexState) live() []Generation {
+	generations := []Generation{s.Active}
+	if s.Building != nil {
+		generations = append(generations, *s.Building)
+	}
+	return append(generations, s.Retired...)
+}
+
+func (s IndexState) retired(id string) (Generation, bool) {
+	for _, generation := range s.Retired {
+		if generation.ID == id {
+			return generation, true
+		}
+	}
+	return Generation{}, false
+}
+
+// nextGenerationID numbers generations after the highest one in the state.
+func (s IndexState) nextGenerationID() string {
+	highest := 0
+	for _, generation := range s.live() {
+		n, err := strconv.Atoi(strings.TrimPrefix(generation.ID, "gen-"))
+		if err == nil && n > highest {
+			highest = n
+		}
+	}
+	return fmt.Sprintf("gen-%d", highest+1)
+}
+
+func parseEmbeddingModel(name string) (openai.EmbeddingModel, error) {
+	var model openai.EmbeddingModel
+	if err := model.UnmarshalText([]byte(name)); err != nil || model == openai.Unknown {
+		return model, fmt.Errorf("unknown embedding model %q", name)
+	}
+	return model, nil
+}
+
+// indexState returns the generations of the index.
+func (l *ledger) indexState(ctx context.Context) (IndexState, error) {
+	if l == nil {
+		return defaultIndexState(), nil
+	}
+
+	var state IndexState
+	err := l.generationCol.FindOne(ctx, bson.M{"_id": indexStateID}).Decode(&state)
+	if err == mongo.ErrNoDocuments {
+		return defaultIndexState(), nil
+	}
+	if err != nil {
+		return state, fmt.Errorf("failed to read index generations: %w", err)
+	}
+	return state, nil
+}
+
+// beginGeneration starts building a generation with the model. Requesting
+// the generation that is being built again returns it, so an interrupted
+// migration can be resumed.
+func (l *ledger) beginGeneration(ctx context.Context, model, index string) (Generation, error) {
+	state, err := l.indexState(ctx)
+	if err != nil {
+		return Generation{}, err
+	}
+	if building := state.Building; building != nil {
+		if building.Model == model && building.Index == index {
+			return *building, nil
+		}
+		return Generation{}, errGenerationBuilding
+	}
+
+	id := state.nextGenerationID()
+	generation := Generation{
+		ID:        id,
+		Model:     model,
+		Index:     index,
+		Namespace: id,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	_, err = l.generationCol.UpdateOne(ctx,
+		bson.M{"_id": indexStateID, "building": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"building": generation}, "$setOnInsert": bson.M{"active": state.Active}},
+		options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// Another request started a generation since the state was read.
+		return Generation{}, errGenerationBuilding
+	}
+	if err != nil {
+		return Generation{}, fmt.Errorf("failed to begin generation: %w", err)
+	}
+	return generation, nil
+}
+
+// recordProgress counts the ledger entries and those migrated into the
+// generation being built.
+func (l *ledger) recordProgress(ctx context.Context, id string) error {
+	var entries, migrated int64
+	for _, col := range []*mongo.Collection{l.commitCol, l.documentCol} {
+		total, err := col.CountDocuments(ctx, bson.M{})
+		if err != nil {
+			return fmt.Errorf("failed to count %s: %w", col.Name(), err)
+		}
+		done, err := col.CountDocuments(ctx, bson.M{"generations": id})
+		if err != nil {
+			return fmt.Errorf("failed to count %s: %w", col.Name(), err)
+		}
+		entries += total
+		migrated += done
+	}
+
+	_, err := l.generationCol.UpdateOne(ctx,
+		bson.M{"_id": indexStateID, "building.id": id},
+		bson.M{"$set": bson.M{"building.entries": entries, "building.migrated": migrated}})
+	if err != nil {
+		return fmt.Errorf("failed to record migration progress: %w", err)
+	}
+	return nil
+}
+
+// activateGeneration switches reads to the generation being built and
+// retires the active one. The switch is a single conditional update, so
+// readers see either generation but never a mix.
+func (l *ledger) activateGeneration(ctx context.Context, id string) (Generation, error) {
+	state, err := l.indexState(ctx)
+	if err != nil {
+		return Generation{}, err
+	}
+	if state.Building == nil || state.Building.ID != id {
+		return Generation{}, fmt.Errorf("generation %s is not being built", id)
+	}
+
+	now := time.Now().UTC()
+	activated := *state.Building
+	activated.ActivatedAt = &now
+	retired := state.Active
+	retired.RetiredAt = &now
+
+	result, err := l.generationCol.UpdateOne(ctx,
+		bson.M{"_id": indexStateID, "building.id": id, "active.id": retired.ID},
+		bson.M{
+			"$set":   bson.M{"active": activated},
+			"$unset": bson.M{"building": ""},
+			"$push":  bson.M{"retired": retired},
+		})
+	if err != nil {
+		return Generation{}, fmt.Errorf("failed to activate generation: %w", err)
+	}
+	if result.ModifiedCount == 0 {
+		return Generation{}, fmt.Errorf("generation %s was activated concurrently", id)
+	}
+	return retired, nil
+}
+
+// markMigrated records that the vectors of ledger entries are in a generation.
+func (l *ledger) markMigrated(ctx context.Context, col *mongo.Collection, ids []interface{}, generationID string) error {
+	_, err := col.UpdateMany(ctx,
+		bson.M{"_id": bson.M{"$in": ids}},
+		bson.M{"$addToSet": bson.M{"generations": generationID}})
+	if err != nil {
+		return fmt.Errorf("failed to mark %s as migrated: %w", col.Name(), err)
+	}
+	return nil
+}
+
+// dropGeneration forgets a retired generation whose vectors were deleted.
+func (l *ledger) dropGeneration(ctx context.Context, id string) error {
+	for _, col := range []*mongo.Collection{l.commitCol, l.documentCol} {
+		_, err := col.UpdateMany(ctx, bson.M{"generations": id}, bson.M{"$pull": bson.M{"generations": id}})
+		if err != nil {
+			return fmt.Errorf("failed to update %s: %w", col.Name(), err)
+		}
+	}
+
+	_, err := l.generationCol.UpdateOne(ctx,
+		bson.M{"_id": indexStateID},
+		bson.M{"$pull": bson.M{"retired": bson.M{"id": id}}})
+	if err != nil {
+		return fmt.Errorf("failed to drop generation: %w", err)
+	}
+	return nil
+}
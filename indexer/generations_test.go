@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultIndexStateReadsInitialGeneration(t *testing.T) {
+	state := defaultIndexState()
+	require.Equal(t, initialGenerationID, state.Active.ID)
+	require.Empty(t, state.Active.Namespace)
+	require.Equal(t, pineconeStore{}, newPineconeStore(state.Active))
+
+	model, err := state.Active.embeddingModel()
+	require.NoError(t, err)
+	require.Equal(t, openai.AdaEmbeddingV2, model)
+}
+
+func TestIndexStateLiveGenerations(t *testing.T) {
+	state := IndexState{
+		Active:   Generation{ID: "gen-2"},
+		Building: &Generation{ID: "gen-4"},
+		Retired:  []Generation{{ID: "gen-1"}, {ID: "gen-3"}},
+	}
+
+	var ids []string
+	for _, generation := range state.live() {
+		ids = append(ids, generation.ID)
+	}
+	require.Equal(t, []string{"gen-2", "gen-4", "gen-1", "gen-3"}, ids)
+	require.Equal(t, "gen-5", state.nextGenerationID())
+
+	retired, ok := state.retired("gen-3")
+	require.True(t, ok)
+	require.Equal(t, "gen-3", retired.ID)
+	_, ok = state.retired("gen-2")
+	require.False(t, ok)
+
+	require.Equal(t, "gen-2", defaultIndexState().nextGenerationID())
+}
+
+func TestGenerationCoverage(t *testing.T) {
+	require.Equal(t, float64(100), Generation{}.coverage())
+	require.Equal(t, float64(25), Generation{Entries: 8, Migrated: 2}.coverage())
+}
+
+func TestParseEmbeddingModel(t *testing.T) {
+	model, err := parseEmbeddingModel("text-embedding-ada-002")
+	require.NoError(t, err)
+	require.Equal(t, openai.AdaEmbeddingV2, model)
+
+	_, err = parseEmbeddingModel("no-such-model")
+	require.Error(t, err)
+}
@@ -14,29 +14,112 @@ import (
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/storer"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/sync/semaphore"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
-func indexRepository(ctx context.Context, repoID string, repoCol *mongo.Collection) error {
+func indexRepository(ctx context.Context, repoID string, repoCol, usageCol *mongo.Collection, ledger *ledger) error {
 	repo, err := getRepositoryByID(ctx, repoID, repoCol)
 	if err != nil {
 		return fmt.Errorf("failed to get repository: %w", err)
 	}
 
-	err = processRepository(ctx, repo, "")
+	job := newIndexJob(repo, newTokenBudget(usageCol, repo), ledger)
+	return runIndexJob(ctx, job, repoCol)
+}
+
+// indexPushedCommits fetches the pushed branches and indexes the commits
+// added since the heads of the previous run. A job paused by its budget is
+// resumed without fetching, since commits above its checkpoint would be
+// skipped; they are fetched by the next push.
+func indexPushedCommits(ctx context.Context, repoID string, repoCol, usageCol *mongo.Collection, ledger *ledger) error {
+	repo, err := takePendingPush(ctx, repoID, repoCol)
+	if err != nil {
+		return fmt.Errorf("failed to get repository: %w", err)
+	}
+	if repo.IndexingStatus == statusDeleting {
+		return nil
+	}
+
+	if repo.Checkpoint == "" && repo.PendingPush != nil {
+		err = gitFetch(ctx, extractFolderName(repo.URL), repo.PendingPush.Branches)
+		if err != nil {
+			return fmt.Errorf("failed to fetch branches: %w", err)
+		}
+	}
+
+	job := newIndexJob(repo, newTokenBudget(usageCol, repo), ledger)
+	job.since = repo.Heads
+	return runIndexJob(ctx, job, repoCol)
+}
+
+// runIndexJob processes a repository, resuming from its checkpoint, and
+// stores the outcome.
+func runIndexJob(ctx context.Context, job *indexJob, repoCol *mongo.Collection) error {
+	repo := job.repo
+	index, err := job.ledger.indexState(ctx)
+	if err != nil {
+		return err
+	}
+	job.index = index
+
+	err = processRepository(ctx, job, repo.Checkpoint)
+	if errors.Is(err, errBudgetExceeded) {
+		loggerFrom(ctx).Infow("Pausing repository", "checkpoint", job.checkpoint(), "reason", err.Error())
+		return updateRepository(ctx, repo, bson.M{
+			"status":     statusPausedBudget,
+			"checkpoint": job.checkpoint(),
+			"last_job":   job.report(),
+		}, repoCol)
+	}
+	if errors.Is(err, errShuttingDown) {
+		loggerFrom(ctx).Infow("Checkpointing repository for shutdown", "checkpoint", job.checkpoint())
+		updateErr := updateRepository(ctx, repo, bson.M{
+			"checkpoint": job.checkpoint(),
+			"last_job":   job.report(),
+		}, repoCol)
+		if updateErr != nil {
+			return updateErr
+		}
+		return err
+	}
 	if err != nil {
 		return fmt.Errorf("failed to process repository: %w", err)
 	}
 
-	_, err = repoCol.UpdateOne(ctx, bson.M{"_id": repo.ID}, bson.M{"$set": bson.M{"status": "indexed"}})
+	fields := bson.M{
+		"status":     statusIndexed,
+		"checkpoint": "",
+		"last_job":   job.report(),
+	}
+	for branch, codeOwners := range job.codeOwners {
+		fields["code_owners."+branch] = codeOwners
+	}
+	for branch, head := range job.heads {
+		fields["heads."+branch] = head
+	}
+	return updateRepository(ctx, repo, fields, repoCol)
+}
+
+// estimateRepository performs a dry-run of the indexing job and stores the
+// resulting estimate on the repository without requesting any embeddings.
+func estimateRepository(ctx context.Context, repoID string, repoCol *mongo.Collection) error {
+	repo, err := getRepositoryByID(ctx, repoID, repoCol)
 	if err != nil {
-		return fmt.Errorf("failed to update repository status: %w", err)
+		return fmt.Errorf("failed to get repository: %w", err)
 	}
 
-	return nil
+	job := newDryRunJob(repo)
+	err = processRepository(ctx, job, "")
+	if err != nil {
+		return fmt.Errorf("failed to process repository: %w", err)
+	}
+
+	return updateRepository(ctx, repo, bson.M{"estimate": job.report()}, repoCol)
 }
 
 var mutex = &sync.Mutex{}
@@ -51,9 +134,12 @@ func getNextReference(refIter storer.ReferenceIter) (*plumbing.Reference, error)
 	return ref, nil
 }
 
-func gitClone(repoURL, folderName, referenceName string, depth int) error {
+func gitClone(ctx context.Context, repoURL, folderName, referenceName string, depth int) (err error) {
+	_, span := tracer.Start(ctx, "git.clone", trace.WithAttributes(attribute.String("repo.url", repoURL)))
+	defer func() { endSpan(span, err) }()
+
 	tmpDir := filepath.Join(tempDir(), folderName)
-	err := os.MkdirAll(tmpDir, 0755)
+	err = os.MkdirAll(tmpDir, 0755)
 	if err != nil {
 		return err
 	}
@@ -62,36 +148,73 @@ func gitClone(repoURL, folderName, referenceName string, depth int) error {
 
 	output, err := cmd.CombinedOutput()
 	if err != nil {
-		fmt.Println(string(output))
+		loggerFrom(ctx).Errorw("git clone failed", "output", string(output))
 		return err
 	}
 
 	return nil
 }
 
+// gitFetch updates the branches of an existing clone. A repository that was
+// not cloned yet is cloned by the job itself.
+func gitFetch(ctx context.Context, folderName string, branches []string) (err error) {
+	dir := filepath.Join(tempDir(), folderName)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil
+	}
+
+	_, span := tracer.Start(ctx, "git.fetch", trace.WithAttributes(attribute.StringSlice("git.branches", branches)))
+	defer func() { endSpan(span, err) }()
+
+	for _, branch := range branches {
+		refspec := fmt.Sprintf("+refs/heads/%s:refs/heads/%s", branch, branch)
+		cmd := exec.Command("git", "-C", dir, "fetch", "--update-head-ok", "origin", refspec)
+
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			loggerFrom(ctx).Errorw("git fetch failed", "branch", branch, "output", string(output))
+			return err
+		}
+	}
+
+	return nil
+}
+
 func tempDir() string {
-	tmpFolder := os.Getenv("TEMP_FOLDER")
+	tmpFolder := config.Git.TempFolder
 	if tmpFolder == "" {
 		tmpFolder = os.TempDir()
 	}
 	return tmpFolder
 }
 
-func processRepository(ctx context.Context, repo Repository, lastCommit string) error {
+func processRepository(ctx context.Context, job *indexJob, lastCommit string) error {
+	repo := job.repo
 	if repo.URL == "" {
 		return fmt.Errorf("repository URL is empty")
 	}
 
+	if err := repo.Privacy.validate(); err != nil {
+		return err
+	}
+
+	scrubber, err := newScrubber(repo.ScrubPatterns)
+	if err != nil {
+		return err
+	}
+	job.scrubber = scrubber
+
 	folderName := extractFolderName(repo.URL)
-	fmt.Printf("Cloning repository: %s\n", repo.URL)
+	log := loggerFrom(ctx)
+	log.Infow("Cloning repository", "url", repo.URL)
 
-	r, err := openOrCloneRepo(repo.URL, folderName, "main", 20000)
+	r, err := openOrCloneRepo(ctx, repo.URL, folderName, "main", config.Git.CloneDepth)
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("Cloning completed: %s\n", repo.URL)
-	return processBranches(ctx, r, lastCommit, repo)
+	log.Infow("Cloning completed", "url", repo.URL)
+	return processBranches(ctx, r, lastCommit, job)
 }
 
 func extractFolderName(url string) string {
@@ -99,11 +222,11 @@ func extractFolderName(url string) string {
 	return folderName[:len(folderName)-4]
 }
 
-func openOrCloneRepo(url, folderName, branch string, depth int) (*git.Repository, error) {
+func openOrCloneRepo(ctx context.Context, url, folderName, branch string, depth int) (*git.Repository, error) {
 	tempFolderPath := filepath.Join(tempDir(), folderName)
 
 	if _, err := os.Stat(tempFolderPath); os.IsNotExist(err) {
-		err = gitClone(url, folderName, branch, depth)
+		err = gitClone(ctx, url, folderName, branch, depth)
 		if err != nil {
 			return nil, err
 		}
@@ -112,15 +235,22 @@ func openOrCloneRepo(url, folderName, branch string, depth int) (*git.Repository
 	return git.PlainOpen(tempFolderPath)
 }
 
-func processBranches(ctx context.Context, r *git.Repository, lastCommit string, repo Repository) error {
+func processBranches(ctx context.Context, r *git.Repository, lastCommit string, job *indexJob) error {
+	walker, err := newCommitWalker(job.repo.Walker, r, filepath.Join(tempDir(), extractFolderName(job.repo.URL)))
+	if err != nil {
+		return err
+	}
+
 	refIter, err := r.Branches()
 	if err != nil {
 		return fmt.Errorf("failed to get branches: %w", err)
 	}
 
+	log := loggerFrom(ctx)
 	var wg sync.WaitGroup
-	sem := semaphore.NewWeighted(50)
+	sem := semaphore.NewWeighted(int64(config.Worker.CommitConcurrency))
 	branchesRemaining := true
+	heads := map[string]plumbing.Hash{}
 
 	for branchesRemaining {
 		ref, err := getNextReference(refIter)
@@ -133,61 +263,126 @@ func processBranches(ctx context.Context, r *git.Repository, lastCommit string,
 			continue
 		}
 
-		fmt.Printf("Processing branch: %s\n", ref.Name().Short())
-		mutex.Lock()
-		iter, err := r.Log(&git.LogOptions{From: ref.Hash()})
-		mutex.Unlock()
+		log.Infow("Processing branch", "branch", ref.Name().Short())
+		if job.components != nil {
+			components, err := readComponents(r, ref.Hash())
+			if err != nil {
+				log.Warnw("Failed to read manifests", "branch", ref.Name().Short(), "error", err)
+			}
+			job.components.add(components)
+		}
 
-		commitRemaining := true
 		reachedCheckpoint := lastCommit == ""
+		since := previousHead(ctx, r, job, ref.Name().Short())
 
-		for commitRemaining {
-			commit, err := getNextCommit(iter)
-			commitRemaining, reachedCheckpoint, err = handleCommit(ctx, commit, err, r, &wg, sem, repo.URL, lastCommit, reachedCheckpoint)
+		// A job paused while snapshotting documents has recorded its commits.
+		if lastCommit != checkpointDocuments {
+			err = walker.Walk(ctx, ref.Hash(), since, func(commit *Commit) error {
+				var err error
+				reachedCheckpoint, err = handleCommit(ctx, commit, &wg, sem, job, lastCommit, reachedCheckpoint)
+				return err
+			})
 			if err != nil {
+				wg.Wait()
 				return err
 			}
 		}
+
+		heads[ref.Name().Short()] = ref.Hash()
+		job.setHead(ref.Name().Short(), ref.Hash().String())
+		if !job.dryRun {
+			codeOwners, err := readCodeOwners(r, ref.Hash())
+			if err != nil {
+				log.Warnw("Failed to read CODEOWNERS", "branch", ref.Name().Short(), "error", err)
+			} else if codeOwners != nil {
+				job.setCodeOwners(ref.Name().Short(), codeOwners)
+			}
+		}
+	}
+
+	wg.Wait()
+	if job.isPaused() {
+		return job.pauseReason()
+	}
+
+	// Documents are snapshotted once all commits are recorded, so their top
+	// contributors include the commits of this run.
+	for branch, head := range heads {
+		err = snapshotDocuments(ctx, r, branch, head, job)
+		if errors.Is(err, errBudgetExceeded) || errors.Is(err, errShuttingDown) {
+			log.Infow("Stopping document snapshot", "branch", branch, "reason", err.Error())
+			job.pause(job.nextSequence(), checkpointDocuments, err)
+			return err
+		}
+		if err != nil {
+			log.Warnw("Failed to snapshot documents", "branch", branch, "error", err)
+		}
 	}
 	return nil
 }
 
-func getNextCommit(iter object.CommitIter) (*object.Commit, error) {
-	mutex.Lock()
-	commit, err := iter.Next()
-	mutex.Unlock()
-	if err != nil {
-		return nil, err
+// previousHead returns the head of a branch indexed by the previous run, so
+// its history is not walked again, or the zero hash to walk the whole
+// history. A head missing from the clone, such as one rewritten by a force
+// push, is logged and the whole history walked.
+func previousHead(ctx context.Context, r *git.Repository, job *indexJob, branch string) plumbing.Hash {
+	sha, ok := job.since[branch]
+	if !ok || sha == "" {
+		return plumbing.ZeroHash
+	}
+
+	hash := plumbing.NewHash(sha)
+	if _, err := r.CommitObject(hash); err != nil {
+		loggerFrom(ctx).Warnw("Previous head not found, walking the whole branch", "branch", branch, "sha", sha, "error", err)
+		return plumbing.ZeroHash
 	}
-	return commit, nil
+	return hash
 }
 
-func handleCommit(ctx context.Context, commit *object.Commit, err error, r *git.Repository, wg *sync.WaitGroup, sem *semaphore.Weighted, repoURL, lastCommit string, reachedCheckpoint bool) (bool, bool, error) {
-	if reachedCheckpoint == false && commit != nil && commit.Hash.String() != lastCommit {
-		fmt.Printf("Skipping commit: %s\n", commit.Hash.String())
-	} else {
-		reachedCheckpoint = true
-		if err != nil || commit == nil {
-			return false, reachedCheckpoint, err
-		}
+func handleCommit(ctx context.Context, commit *Commit, wg *sync.WaitGroup, sem *semaphore.Weighted, job *indexJob, lastCommit string, reachedCheckpoint bool) (bool, error) {
+	commitsTotal.WithLabelValues("walked").Inc()
+	if !reachedCheckpoint && commit.Hash != lastCommit {
+		loggerFrom(ctx).Debugw("Skipping commit", "sha", commit.Hash)
+		commitsTotal.WithLabelValues("skipped").Inc()
+		return false, nil
+	}
 
-		if err := sem.Acquire(ctx, 1); err != nil {
-			return false, reachedCheckpoint, fmt.Errorf("failed to acquire semaphore: %w", err)
-		}
+	if job.isPaused() {
+		return true, job.pauseReason()
+	}
 
-		wg.Add(1)
-		go func() {
-			defer sem.Release(1)
-			defer wg.Done()
+	if err := sem.Acquire(ctx, 1); err != nil {
+		return true, fmt.Errorf("failed to acquire semaphore: %w", err)
+	}
 
-			fmt.Printf("Processing commit: %s\n", commit.Hash.String())
-			err := processCommit(ctx, commit, repoURL)
-			if err != nil {
-				fmt.Printf("Warning: failed to process commit: %s\n", err.Error())
-			}
-		}()
+	sequence := job.nextSequence()
+	if stopRequested(ctx) {
+		sem.Release(1)
+		job.pause(sequence, commit.Hash, errShuttingDown)
+		return true, errShuttingDown
 	}
-	return true, reachedCheckpoint, nil
+	wg.Add(1)
+	go func() {
+		defer sem.Release(1)
+		defer wg.Done()
+
+		log := loggerFrom(ctx).With("sha", commit.Hash)
+		log.Info("Processing commit")
+
+		commitCtx, span := tracer.Start(ctx, "commit.process", trace.WithAttributes(attribute.String("git.sha", commit.Hash)))
+		err := processCommit(commitCtx, commit, job)
+		endSpan(span, err)
+		if errors.Is(err, errBudgetExceeded) {
+			job.pause(sequence, commit.Hash, errBudgetExceeded)
+			return
+		}
+		if err != nil {
+			job.commitFailed()
+			commitsTotal.WithLabelValues("failed").Inc()
+			log.Warnw("Failed to process commit", "error", err)
+		}
+	}()
+	return true, nil
 }
 
 func isMasterOrMainBranch(branch string) bool {
@@ -198,51 +393,168 @@ func commitToJSON(username string, email string, diff string) string {
 	return fmt.Sprintf(`{"username": "%s", "email": "%s", "diff": "%s"}`, username, email, diff)
 }
 
-func getDiff(commit *object.Commit) (string, error) {
-	if commit == nil || commit.NumParents() == 0 {
-		return "", nil
+func processCommit(ctx context.Context, commit *Commit, job *indexJob) error {
+	err := commit.resolveDiff()
+	if err != nil {
+		return fmt.Errorf("failed to get diff: %w", err)
 	}
 
-	previousCommit, err := commit.Parent(0)
-	if err != nil {
-		return "", fmt.Errorf("error getting parent commit: %w", err)
+	privacy := job.repo.Privacy
+	authorName, email := privacy.contributor(commit.Author.Name, commit.Author.Email)
+	author := object.Signature{Name: authorName, Email: email, When: commit.Author.When}
+
+	commitID := commit.Hash
+	commitMsg := privacy.maskEmails(job.scrub(commit.Message))
+	diffString := privacy.maskEmails(job.scrub(commit.Diff))
+	metadata := map[string]interface{}{
+		"repoId":    job.repo.ID,
+		"repoUrl":   job.repo.URL,
+		"sha":       commitID,
+		"author":    author.Name,
+		"contact":   email,
+		"timestamp": float64(commit.Author.When.Unix()),
+	}
+	if privacy.sharesPerson() {
+		metadata["person"] = personID(commit.Author.Email)
 	}
 
-	if previousCommit == nil {
-		return "", errors.New("previousCommit is nil")
+	files := make([]string, 0, len(commit.Files))
+	for _, file := range commit.Files {
+		files = append(files, file.Name)
 	}
+	metadata["files"] = stringsToInterfaces(limitStrings(files, maxMetadataFiles))
+
+	languages, components := classifyFiles(files, commit.Diff, job.components)
+	metadata["languages"] = stringsToInterfaces(languages)
+	metadata["components"] = stringsToInterfaces(components)
+
+	inputs := embeddingInputs(commitMsg, author, email, diffString, commitID, job.repo.URL)
+	summary := ""
+	if job.repo.Summaries {
+		var summaryTokens int64
+		if job.dryRun {
+			summaryTokens = estimateSummaryTokens(commitMsg, diffString)
+		} else {
+			summary, summaryTokens, err = summarizeCommit(ctx, job, commitID, commitMsg, diffString)
+			if err != nil {
+				return err
+			}
+		}
+		job.summaryGenerated(summaryTokens)
 
-	diff, err := previousCommit.Patch(commit)
+		inputs = []embeddingInput{summaryEmbeddingInput(commitMsg, summary, author.Name, email, commitID, job.repo.URL)}
+		metadata["summary"] = summary
+		metadata["diff"] = trimDiff(diffString, summaryDiffExcerpt)
+	}
+
+	if job.dryRun {
+		var tokens int64
+		for _, input := range inputs {
+			tokens += estimateTokens(input.Text)
+		}
+		job.commitProcessed(len(inputs), tokens)
+		return nil
+	}
+
+	embeddings, tokens, err := generateEmbeddings(ctx, job, inputs, metadata)
 	if err != nil {
-		return "", fmt.Errorf("error getting diff: %w", err)
+		if errors.Is(err, errBudgetExceeded) {
+			return err
+		}
+		return fmt.Errorf("failed to generate embeddings: %w", err)
 	}
 
-	diffString := diff.String()
-	if len(diffString) > 32000 {
-		diffString = diffString[:32000]
+	err = job.store().storeEmbeddings(ctx, embeddings)
+	if err != nil {
+		return fmt.Errorf("failed to store embeddings in Pinecone: %w", err)
+	}
+
+	vectorIDs := make([]string, 0, len(embeddings))
+	for _, embedding := range embeddings {
+		vectorIDs = append(vectorIDs, embedding.Id)
+	}
+
+	entry := LedgerEntry{
+		ID:         ledgerID(job.repo.ID, commitID),
+		RepoID:     job.repo.ID,
+		SHA:        commitID,
+		Person:     personID(commit.Author.Email),
+		Author:     author.Name,
+		Contact:    email,
+		Timestamp:  commit.Author.When,
+		Files:      files,
+		Languages:  languages,
+		Components: components,
+		VectorIDs:  vectorIDs,
+		Summary:    summary,
+
+		Generations: []string{job.index.Active.ID},
+	}
+	if err := job.ledger.record(ctx, entry); err != nil {
+		return err
+	}
+	if err := job.ledger.indexKeywords(ctx, entry, keywordFrequencies(commitMsg, files, diffString)); err != nil {
+		return err
 	}
-	return diffString, nil
+
+	job.commitProcessed(len(embeddings), tokens)
+	commitsTotal.WithLabelValues("embedded").Inc()
+	return nil
+}
+
+// maxMetadataFiles bounds the file list stored with a vector, since Pinecone
+// limits the size of metadata.
+const maxMetadataFiles = 50
+
+func limitStrings(values []string, limit int) []string {
+	if len(values) > limit {
+		return values[:limit]
+	}
+	return values
 }
 
-func processCommit(ctx context.Context, commit *object.Commit, repoURL string) error {
-	author := commit.Author
-	email := author.Email
-	diffString, err := getDiff(commit)
+func stringsToInterfaces(values []string) []interface{} {
+	result := make([]interface{}, len(values))
+	for i, value := range values {
+		result[i] = value
+	}
+	return result
+}
+
+// purgeRepository deletes every vector, ledger entry and profile contribution
+// of a repository, and finally the repository itself.
+func purgeRepository(ctx context.Context, repoID string, repoCol *mongo.Collection, ledger *ledger) error {
+	ids, err := ledger.vectorIDs(ctx, repoID)
 	if err != nil {
-		return fmt.Errorf("failed to get diff: %w", err)
+		return err
+	}
+
+	state, err := ledger.indexState(ctx)
+	if err != nil {
+		return err
 	}
 
-	commitID := commit.Hash.String()
-	commitMsg := commit.Message
-	embeddings, err := generateEmbeddings(commitMsg, author, email, diffString, commitID, repoURL)
+	for _, generation := range state.live() {
+		store := newPineconeStore(generation)
+		err = store.deleteVectors(ctx, ids)
+		if err != nil {
+			return err
+		}
+
+		err = store.deleteRepositoryVectors(ctx, repoID)
+		if err != nil {
+			return err
+		}
+	}
 
+	err = ledger.purge(ctx, repoID)
 	if err != nil {
-		return fmt.Errorf("failed to generate embeddings: %w", err)
+		return err
 	}
 
-	err = storeEmbeddings(commitID, embeddings)
+	_, err = repoCol.DeleteOne(ctx, bson.M{"_id": repoID})
 	if err != nil {
-		return fmt.Errorf("failed to store embeddings in Pinecone: %w", err)
+		return fmt.Errorf("failed to delete repository: %w", err)
 	}
 
 	return nil
@@ -2,10 +2,20 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 func TestProcessRepository(t *testing.T) {
@@ -15,8 +25,8 @@ func TestProcessRepository(t *testing.T) {
 		URL: "https://github.com/MicrosoftDocs/azure-docs.git",
 	}
 
-	err := processRepository(ctx, repo, "9b7fd5df4b4491048261010f34db86402f320a41")
-	//err := processRepository(ctx, repo, "")
+	err := processRepository(ctx, newIndexJob(repo, nil, nil), "9b7fd5df4b4491048261010f34db86402f320a41")
+	//err := processRepository(ctx, newIndexJob(repo, nil, nil), "")
 	require.NoError(t, err)
 }
 
@@ -29,3 +39,87 @@ type defaultGitRepoCloner struct{}
 func (d *defaultGitRepoCloner) PlainCloneContext(ctx context.Context, path string, isBare bool, o *git.CloneOptions) (*git.Repository, error) {
 	return git.PlainCloneContext(ctx, path, isBare, o)
 }
+
+// TestPurgeRepository needs a MongoDB server at TEST_MONGODB_URI; Pinecone
+// is replaced by a server recording the delete requests.
+func TestPurgeRepository(t *testing.T) {
+	uri := os.Getenv("TEST_MONGODB_URI")
+	if uri == "" {
+		t.Skip("TEST_MONGODB_URI is not set")
+	}
+	ctx := context.Background()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	require.NoError(t, err)
+	defer client.Disconnect(ctx)
+	db := client.Database(fmt.Sprintf("purge_test_%d", time.Now().UnixNano()))
+	defer db.Drop(ctx)
+
+	var mu sync.Mutex
+	var deletes []map[string]interface{}
+	pinecone := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if r.URL.Path != "/vectors/delete" || json.NewDecoder(r.Body).Decode(&body) != nil {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		deletes = append(deletes, body)
+		mu.Unlock()
+		w.Write([]byte("{}"))
+	}))
+	defer pinecone.Close()
+
+	previous := config
+	defer func() { config = previous }()
+	config.VectorStore.PineconeURL = pinecone.URL
+
+	ledger := newLedger(db)
+	repoCol := db.Collection("repositories")
+	for _, repoID := range []string{"purged", "kept"} {
+		entry := LedgerEntry{
+			ID:        ledgerID(repoID, "abc"),
+			RepoID:    repoID,
+			SHA:       "abc",
+			Person:    personID(repoID + "@example.com"),
+			Timestamp: time.Now(),
+			VectorIDs: []string{repoID + ":abc"},
+		}
+		require.NoError(t, ledger.record(ctx, entry))
+		require.NoError(t, ledger.indexKeywords(ctx, entry, map[string]int{"parser": 2}))
+		require.NoError(t, ledger.recordDocument(ctx, DocumentEntry{
+			ID:        repoID + ":main:README.md",
+			RepoID:    repoID,
+			Branch:    "main",
+			Path:      "README.md",
+			VectorIDs: []string{repoID + ":doc"},
+		}))
+		require.NoError(t, ledger.saveSummary(ctx, repoID, ledgerID(repoID, "def"), "A summary."))
+		_, err := repoCol.InsertOne(ctx, bson.M{"_id": repoID})
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, purgeRepository(ctx, "purged", repoCol, ledger))
+
+	require.Len(t, deletes, 2)
+	require.ElementsMatch(t, []interface{}{"purged:abc", "purged:doc"}, deletes[0]["ids"])
+	require.Equal(t, map[string]interface{}{"repoId": map[string]interface{}{"$eq": "purged"}}, deletes[1]["filter"])
+
+	count := func(col *mongo.Collection, filter bson.M) int64 {
+		n, err := col.CountDocuments(ctx, filter)
+		require.NoError(t, err)
+		return n
+	}
+	for _, repoID := range []string{"purged", "kept"} {
+		want := int64(0)
+		if repoID == "kept" {
+			want = 1
+		}
+		require.Equal(t, want, count(ledger.commitCol, bson.M{"repo_id": repoID}), repoID)
+		require.Equal(t, want, count(ledger.documentCol, bson.M{"repo_id": repoID}), repoID)
+		require.Equal(t, want, count(ledger.keywordCol, bson.M{"repo_id": repoID}), repoID)
+		require.Equal(t, want, count(ledger.summaryCol, bson.M{"repo_id": repoID}), repoID)
+		require.Equal(t, want, count(ledger.peopleCol, bson.M{"_id": personID(repoID + "@example.com")}), repoID)
+		require.Equal(t, want, count(repoCol, bson.M{"_id": repoID}), repoID)
+	}
+}
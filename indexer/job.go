@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// indexJob carries the per-run state shared by the commits of one indexing job.
+type indexJob struct {
+	repo   Repository
+	dryRun bool
+	budget *tokenBudget
+	ledger *ledger
+	stats  jobStats
+
+	// index holds the generations of the index when the job started. New
+	// vectors go to the active one; deletes reach all of them.
+	index IndexState
+
+	codeOwners map[string]*CodeOwners
+	components *componentIndex
+
+	// since holds the branch heads of the previous run. Walking a branch
+	// leaves out their history, so only new commits are indexed.
+	since map[string]string
+	heads map[string]string
+
+	scrubber        *scrubber
+	redactionsMutex sync.Mutex
+	redactions      map[string]int64
+
+	pauseMutex  sync.Mutex
+	paused      bool
+	pausedAt    int64
+	pausedAtSHA string
+	pausedBy    error
+	sequence    int64
+}
+
+// JobReport summarises an indexing job or a dry-run estimate.
+type JobReport struct {
+	DryRun        bool    `json:"dry_run" bson:"dry_run"`
+	Commits       int64   `json:"commits" bson:"commits"`
+	Failed        int64   `json:"failed" bson:"failed"`
+	Chunks        int64   `json:"chunks" bson:"chunks"`
+	Tokens        int64   `json:"tokens" bson:"tokens"`
+	Model         string  `json:"model" bson:"model"`
+	EstimatedCost float64 `json:"estimated_cost_usd" bson:"estimated_cost_usd"`
+
+	Summaries     int64  `json:"summaries,omitempty" bson:"summaries,omitempty"`
+	SummaryTokens int64  `json:"summary_tokens,omitempty" bson:"summary_tokens,omitempty"`
+	SummaryModel  string `json:"summary_model,omitempty" bson:"summary_model,omitempty"`
+
+	Documents       int64 `json:"documents,omitempty" bson:"documents,omitempty"`
+	FailedDocuments int64 `json:"failed_documents,omitempty" bson:"failed_documents,omitempty"`
+
+	Redactions map[string]int64 `json:"redactions,omitempty" bson:"redactions,omitempty"`
+}
+
+type jobStats struct {
+	commits int64
+	failed  int64
+	chunks  int64
+	tokens  int64
+
+	summaries     int64
+	summaryTokens int64
+
+	documents       int64
+	failedDocuments int64
+}
+
+func newIndexJob(repo Repository, budget *tokenBudget, ledger *ledger) *indexJob {
+	return &indexJob{
+		repo:       repo,
+		budget:     budget,
+		ledger:     ledger,
+		index:      defaultIndexState(),
+		components: newComponentIndex(),
+	}
+}
+
+func newDryRunJob(repo Repository) *indexJob {
+	return &indexJob{
+		repo:   repo,
+		dryRun: true,
+		index:  defaultIndexState(),
+	}
+}
+
+// nextSequence numbers commits in the order they are dispatched.
+func (j *indexJob) nextSequence() int64 {
+	return atomic.AddInt64(&j.sequence, 1)
+}
+
+// checkpointDocuments is the checkpoint of a job paused after recording its
+// commits, while snapshotting documents. Resuming it only snapshots them;
+// the documents snapshotted before the pause are unchanged and skipped.
+const checkpointDocuments = "documents"
+
+// pause stops the job because the budget ran out or the worker is shutting
+// down. The earliest dispatched commit that was refused becomes the
+// checkpoint to resume from, and its reason the reason of the pause.
+func (j *indexJob) pause(sequence int64, sha string, reason error) {
+	j.pauseMutex.Lock()
+	defer j.pauseMutex.Unlock()
+
+	if !j.paused || sequence < j.pausedAt {
+		j.pausedAt = sequence
+		j.pausedAtSHA = sha
+		j.pausedBy = reason
+	}
+	j.paused = true
+}
+
+func (j *indexJob) isPaused() bool {
+	j.pauseMutex.Lock()
+	defer j.pauseMutex.Unlock()
+	return j.paused
+}
+
+// pauseReason returns errBudgetExceeded or errShuttingDown for a paused job.
+func (j *indexJob) pauseReason() error {
+	j.pauseMutex.Lock()
+	defer j.pauseMutex.Unlock()
+	return j.pausedBy
+}
+
+func (j *indexJob) checkpoint() string {
+	j.pauseMutex.Lock()
+	defer j.pauseMutex.Unlock()
+	return j.pausedAtSHA
+}
+
+// store returns the store new vectors are written to.
+func (j *indexJob) store() pineconeStore {
+	return newPineconeStore(j.index.Active)
+}
+
+// deleteVectors deletes vectors from every generation of the index.
+func (j *indexJob) deleteVectors(ctx context.Context, ids []string) error {
+	for _, generation := range j.index.live() {
+		if err := newPineconeStore(generation).deleteVectors(ctx, ids); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (j *indexJob) commitProcessed(chunks int, tokens int64) {
+	atomic.AddInt64(&j.stats.commits, 1)
+	atomic.AddInt64(&j.stats.chunks, int64(chunks))
+	atomic.AddInt64(&j.stats.tokens, tokens)
+}
+
+// setHead records the head a branch was indexed up to.
+func (j *indexJob) setHead(branch, sha string) {
+	if j.heads == nil {
+		j.heads = map[string]string{}
+	}
+	j.heads[branch] = sha
+}
+
+// documentProcessed counts an embedded document. Its chunks and tokens are
+// part of the job's totals.
+func (j *indexJob) documentProcessed(chunks int, tokens int64) {
+	atomic.AddInt64(&j.stats.documents, 1)
+	atomic.AddInt64(&j.stats.chunks, int64(chunks))
+	atomic.AddInt64(&j.stats.tokens, tokens)
+}
+
+func (j *indexJob) documentFailed() {
+	atomic.AddInt64(&j.stats.failedDocuments, 1)
+}
+
+// setCodeOwners keeps the CODEOWNERS rules found at the head of a branch.
+// Email owners are subject to the repository's privacy policy.
+func (j *indexJob) setCodeOwners(branch string, codeOwners *CodeOwners) {
+	for i, rule := range codeOwners.Rules {
+		for k, owner := range rule.Owners {
+			codeOwners.Rules[i].Owners[k] = j.repo.Privacy.maskEmails(owner)
+		}
+	}
+
+	if j.codeOwners == nil {
+		j.codeOwners = map[string]*CodeOwners{}
+	}
+	j.codeOwners[branch] = codeOwners
+}
+
+// summaryGenerated counts a commit summary. Reused summaries cost no tokens.
+func (j *indexJob) summaryGenerated(tokens int64) {
+	atomic.AddInt64(&j.stats.summaries, 1)
+	atomic.AddInt64(&j.stats.summaryTokens, tokens)
+}
+
+func (j *indexJob) commitFailed() {
+	atomic.AddInt64(&j.stats.failed, 1)
+}
+
+// scrub removes secrets from text before it is embedded or stored.
+func (j *indexJob) scrub(text string) string {
+	scrubbed, counts := j.scrubber.scrub(text)
+	if len(counts) == 0 {
+		return scrubbed
+	}
+
+	j.redactionsMutex.Lock()
+	defer j.redactionsMutex.Unlock()
+	if j.redactions == nil {
+		j.redactions = map[string]int64{}
+	}
+	for name, count := range counts {
+		j.redactions[name] += int64(count)
+	}
+	return scrubbed
+}
+
+func (j *indexJob) report() JobReport {
+	j.redactionsMutex.Lock()
+	redactions := make(map[string]int64, len(j.redactions))
+	for name, count := range j.redactions {
+		redactions[name] = count
+	}
+	j.redactionsMutex.Unlock()
+
+	tokens := atomic.LoadInt64(&j.stats.tokens)
+	summaryTokens := atomic.LoadInt64(&j.stats.summaryTokens)
+	report := JobReport{
+		DryRun:        j.dryRun,
+		Commits:       atomic.LoadInt64(&j.stats.commits),
+		Failed:        atomic.LoadInt64(&j.stats.failed),
+		Chunks:        atomic.LoadInt64(&j.stats.chunks),
+		Tokens:        tokens,
+		Model:         j.index.Active.Model,
+		EstimatedCost: estimateCost(j.index.Active.Model, tokens),
+		Summaries:     atomic.LoadInt64(&j.stats.summaries),
+		SummaryTokens: summaryTokens,
+		Redactions:    redactions,
+
+		Documents:       atomic.LoadInt64(&j.stats.documents),
+		FailedDocuments: atomic.LoadInt64(&j.stats.failedDocuments),
+	}
+	if report.Summaries > 0 {
+		report.SummaryModel = summaryModel
+		report.EstimatedCost += float64(summaryTokens) / 1000 * chatPricePer1KTokens[summaryModel]
+	}
+	return report
+}
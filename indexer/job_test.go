@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDryRunReportsWithoutEmbedding(t *testing.T) {
+	source := createTestRepo(t, 5)
+	remote := filepath.Join(t.TempDir(), "sample.git")
+	output, err := exec.Command("git", "clone", "-q", "--bare", source, remote).CombinedOutput()
+	require.NoError(t, err, string(output))
+
+	useConfig(t, func(c *Config) { c.Git.TempFolder = t.TempDir() })
+
+	job := newDryRunJob(Repository{URL: "file://" + remote, Walker: gitLogWalker})
+	err = processRepository(context.Background(), job, "")
+	require.NoError(t, err)
+
+	report := job.report()
+	require.True(t, report.DryRun)
+	require.Equal(t, int64(5), report.Commits)
+	require.Equal(t, int64(5), report.Documents)
+	require.Equal(t, int64(10), report.Chunks)
+	require.Greater(t, report.Tokens, int64(0))
+	require.Greater(t, report.EstimatedCost, 0.0)
+	require.Zero(t, report.Summaries)
+
+	job = newDryRunJob(Repository{URL: "file://" + remote, Walker: gitLogWalker, Summaries: true})
+	err = processRepository(context.Background(), job, "")
+	require.NoError(t, err)
+
+	summarized := job.report()
+	require.Equal(t, int64(5), summarized.Summaries)
+	require.Greater(t, summarized.SummaryTokens, int64(0))
+	// The summaries' tokens are counted once, as summary tokens, and the
+	// summaries embed less than the diffs.
+	require.Less(t, summarized.Tokens, report.Tokens)
+	require.Greater(t, summarized.EstimatedCost, report.EstimatedCost)
+}
+
+func TestResumeAtDocumentsSkipsCommits(t *testing.T) {
+	source := createTestRepo(t, 5)
+	remote := filepath.Join(t.TempDir(), "sample.git")
+	output, err := exec.Command("git", "clone", "-q", "--bare", source, remote).CombinedOutput()
+	require.NoError(t, err, string(output))
+
+	useConfig(t, func(c *Config) { c.Git.TempFolder = t.TempDir() })
+
+	job := newDryRunJob(Repository{URL: "file://" + remote})
+	err = processRepository(context.Background(), job, checkpointDocuments)
+	require.NoError(t, err)
+
+	report := job.report()
+	require.Zero(t, report.Commits)
+	require.Equal(t, int64(5), report.Documents)
+	require.NotEmpty(t, job.heads["main"])
+}
+
+func TestDocumentSnapshotSkipsBinaryFiles(t *testing.T) {
+	source := createTestRepo(t, 2)
+	run := func(args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = source
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=Jane Doe", "GIT_AUTHOR_EMAIL=jane@example.com",
+			"GIT_COMMITTER_NAME=Jane Doe", "GIT_COMMITTER_EMAIL=jane@example.com")
+		output, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(output))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(source, "docs", "diagram.png"), []byte("\x89PNG\r\n\x1a\n\x00\x00"), 0644))
+	run("add", "-A")
+	run("commit", "-q", "-m", "Add diagram")
+
+	remote := filepath.Join(t.TempDir(), "sample.git")
+	output, err := exec.Command("git", "clone", "-q", "--bare", source, remote).CombinedOutput()
+	require.NoError(t, err, string(output))
+
+	useConfig(t, func(c *Config) { c.Git.TempFolder = t.TempDir() })
+
+	job := newDryRunJob(Repository{URL: "file://" + remote})
+	err = processRepository(context.Background(), job, checkpointDocuments)
+	require.NoError(t, err)
+
+	report := job.report()
+	require.Equal(t, int64(2), report.Documents)
+	require.Zero(t, report.FailedDocuments)
+}
+
+func TestPauseKeepsEarliestCheckpoint(t *testing.T) {
+	job := newIndexJob(Repository{}, nil, nil)
+	require.False(t, job.isPaused())
+
+	job.pause(7, "seven", errBudgetExceeded)
+	job.pause(3, "three", errShuttingDown)
+	job.pause(5, "five", errBudgetExceeded)
+
+	require.True(t, job.isPaused())
+	require.Equal(t, "three", job.checkpoint())
+	require.Equal(t, errShuttingDown, job.pauseReason())
+}
+
+func TestIncrementalJobStopsAtPreviousHead(t *testing.T) {
+	source := createTestRepo(t, 5)
+	remote := filepath.Join(t.TempDir(), "sample.git")
+	output, err := exec.Command("git", "clone", "-q", "--bare", source, remote).CombinedOutput()
+	require.NoError(t, err, string(output))
+
+	previousHead, err := exec.Command("git", "-C", source, "rev-parse", "HEAD~2").Output()
+	require.NoError(t, err)
+
+	useConfig(t, func(c *Config) { c.Git.TempFolder = t.TempDir() })
+
+	job := newDryRunJob(Repository{URL: "file://" + remote})
+	job.since = map[string]string{"main": strings.TrimSpace(string(previousHead))}
+	err = processRepository(context.Background(), job, "")
+	require.NoError(t, err)
+
+	require.Equal(t, int64(2), job.report().Commits)
+	require.NotEqual(t, job.since["main"], job.heads["main"])
+}
+
+func TestIncrementalJobWalksMergedBranch(t *testing.T) {
+	source := createTestRepo(t, 5)
+	git := func(env []string, args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = source
+		cmd.Env = append(append(os.Environ(),
+			"GIT_AUTHOR_NAME=Jane Doe", "GIT_AUTHOR_EMAIL=jane@example.com",
+			"GIT_COMMITTER_NAME=Jane Doe", "GIT_COMMITTER_EMAIL=jane@example.com"), env...)
+		output, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(output))
+		return strings.TrimSpace(string(output))
+	}
+
+	previousHead := git(nil, "rev-parse", "HEAD")
+
+	// A branch forked before the previous head, with commits dated before
+	// it, is merged after the previous run.
+	old := []string{"GIT_AUTHOR_DATE=2020-01-01T00:00:00Z", "GIT_COMMITTER_DATE=2020-01-01T00:00:00Z"}
+	git(nil, "checkout", "-q", "-b", "feature", "HEAD~2")
+	for i := 0; i < 2; i++ {
+		require.NoError(t, os.WriteFile(filepath.Join(source, "feature.md"), []byte(strings.Repeat("feature\n", i+1)), 0644))
+		git(old, "add", "-A")
+		git(old, "commit", "-q", "-m", "Add feature")
+	}
+	git(nil, "checkout", "-q", "main")
+	git(nil, "merge", "-q", "--no-ff", "-m", "Merge feature", "feature")
+
+	remote := filepath.Join(t.TempDir(), "sample.git")
+	output, err := exec.Command("git", "clone", "-q", "--bare", source, remote).CombinedOutput()
+	require.NoError(t, err, string(output))
+
+	for _, walker := range []string{goGitWalker, gitLogWalker} {
+		useConfig(t, func(c *Config) { c.Git.TempFolder = t.TempDir() })
+
+		job := newDryRunJob(Repository{URL: "file://" + remote, Walker: walker})
+		job.since = map[string]string{"main": previousHead}
+		err = processRepository(context.Background(), job, "")
+		require.NoError(t, err)
+
+		require.Equal(t, int64(3), job.report().Commits, walker)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The keyword index complements the vectors for questions naming exact
+// identifiers, such as function names, error codes or config keys, which
+// embeddings match poorly. It holds one posting per term and commit, with
+// the term frequency and the length of the commit, and the corpus size for
+// BM25 in a single stats document. The chat service searches it.
+
+// maxKeywordTerms bounds the distinct terms indexed per commit. Large diffs
+// keep their most frequent terms.
+const maxKeywordTerms = 1000
+
+// keywordStatsID is the ID of the stats document of the keyword index.
+const keywordStatsID = "corpus"
+
+// KeywordPosting records that a commit contains a term.
+type KeywordPosting struct {
+	ID     string `bson:"_id"`
+	Term   string `bson:"term"`
+	Entry  string `bson:"entry"`
+	RepoID string `bson:"repo_id"`
+	TF     int    `bson:"tf"`
+	Length int    `bson:"length"`
+}
+
+// keywordTermRE matches identifiers, keeping dotted and dashed names such as
+// pinecone.top_k or ERR-CONN-42 together.
+var keywordTermRE = regexp.MustCompile(`[\p{L}\p{N}_]+(?:[.\-][\p{L}\p{N}_]+)*`)
+
+// keywordStopWords are words too common to find anything with.
+var keywordStopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "by": true,
+	"for": true, "from": true, "if": true, "in": true, "is": true, "it": true, "of": true, "on": true,
+	"or": true, "the": true, "this": true, "to": true, "with": true,
+}
+
+// keywordTerms tokenizes text into lower case terms. Dotted and dashed names
+// are indexed whole and by their parts, so both pinecone.top_k and top_k
+// find them. The chat service tokenizes questions the same way.
+func keywordTerms(text string) []string {
+	var terms []string
+	add := func(term string) {
+		if len(term) >= 2 && len(term) <= 64 && !keywordStopWords[term] {
+			terms = append(terms, term)
+		}
+	}
+
+	for _, token := range keywordTermRE.FindAllString(strings.ToLower(text), -1) {
+		add(token)
+		if strings.ContainsAny(token, ".-") {
+			for _, part := range strings.FieldsFunc(token, func(r rune) bool { return r == '.' || r == '-' }) {
+				add(part)
+			}
+		}
+	}
+	return terms
+}
+
+// keywordFrequencies counts the terms of a commit's message, file paths and
+// diff, keeping the most frequent ones.
+func keywordFrequencies(message string, files []string, diff string) map[string]int {
+	frequencies := map[string]int{}
+	for _, text := range append([]string{message, diff}, files...) {
+		for _, term := range keywordTerms(text) {
+			frequencies[term]++
+		}
+	}
+	if len(frequencies) <= maxKeywordTerms {
+		return frequencies
+	}
+
+	terms := make([]string, 0, len(frequencies))
+	for term := range frequencies {
+		terms = append(terms, term)
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		if frequencies[terms[i]] != frequencies[terms[j]] {
+			return frequencies[terms[i]] > frequencies[terms[j]]
+		}
+		return terms[i] < terms[j]
+	})
+	for _, term := range terms[maxKeywordTerms:] {
+		delete(frequencies, term)
+	}
+	return frequencies
+}
+
+// ensureKeywordIndexes indexes the postings by term for searches and by
+// entry and repository for updates.
+func (l *ledger) ensureKeywordIndexes(ctx context.Context) error {
+	_, err := l.keywordCol.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "term", Value: 1}}},
+		{Keys: bson.D{{Key: "entry", Value: 1}}},
+		{Keys: bson.D{{Key: "repo_id", Value: 1}}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create keyword indexes: %w", err)
+	}
+	return nil
+}
+
+// indexKeywords replaces the postings of a ledger entry by the terms of its
+// commit.
+func (l *ledger) indexKeywords(ctx context.Context, entry LedgerEntry, frequencies map[string]int) error {
+	if l == nil || len(frequencies) == 0 {
+		return nil
+	}
+
+	total := 0
+	for _, tf := range frequencies {
+		total += tf
+	}
+	postings := make([]KeywordPosting, 0, len(frequencies))
+	for term, tf := range frequencies {
+		postings = append(postings, KeywordPosting{
+			ID:     term + " " + entry.ID,
+			Term:   term,
+			Entry:  entry.ID,
+			RepoID: entry.RepoID,
+			TF:     tf,
+			Length: total,
+		})
+	}
+	return l.replaceKeywords(ctx, entry.ID, postings)
+}
+
+// replaceKeywords replaces the postings of a ledger entry and updates the
+// corpus stats. The postings of an entry share its length.
+func (l *ledger) replaceKeywords(ctx context.Context, entryID string, postings []KeywordPosting) error {
+	if len(postings) == 0 {
+		return nil
+	}
+
+	documents, length := 1, 0
+	var previous KeywordPosting
+	err := l.keywordCol.FindOne(ctx, bson.M{"entry": entryID}).Decode(&previous)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return fmt.Errorf("failed to read keyword postings: %w", err)
+	}
+	if err == nil {
+		if _, err := l.keywordCol.DeleteMany(ctx, bson.M{"entry": entryID}); err != nil {
+			return fmt.Errorf("failed to delete keyword postings: %w", err)
+		}
+		documents, length = 0, -previous.Length
+	}
+
+	records := make([]interface{}, 0, len(postings))
+	for _, posting := range postings {
+		records = append(records, posting)
+	}
+	if _, err := l.keywordCol.InsertMany(ctx, records, options.InsertMany().SetOrdered(false)); err != nil {
+		return fmt.Errorf("failed to insert keyword postings: %w", err)
+	}
+
+	return l.updateKeywordStats(ctx, documents, length+postings[0].Length)
+}
+
+// purgeKeywords removes the postings of a repository from the index.
+func (l *ledger) purgeKeywords(ctx context.Context, repoID string) error {
+	cursor, err := l.keywordCol.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"repo_id": repoID}}},
+		{{Key: "$group", Value: bson.M{"_id": "$entry", "length": bson.M{"$first": "$length"}}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "documents": bson.M{"$sum": 1}, "length": bson.M{"$sum": "$length"}}}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to count keyword postings: %w", err)
+	}
+	var totals []struct {
+		Documents int `bson:"documents"`
+		Length    int `bson:"length"`
+	}
+	if err := cursor.All(ctx, &totals); err != nil {
+		return fmt.Errorf("failed to decode keyword postings: %w", err)
+	}
+
+	if _, err := l.keywordCol.DeleteMany(ctx, bson.M{"repo_id": repoID}); err != nil {
+		return fmt.Errorf("failed to delete keyword postings: %w", err)
+	}
+	if len(totals) == 0 {
+		return nil
+	}
+	return l.updateKeywordStats(ctx, -totals[0].Documents, -totals[0].Length)
+}
+
+func (l *ledger) updateKeywordStats(ctx context.Context, documents, length int) error {
+	_, err := l.keywordStatsCol.UpdateOne(ctx,
+		bson.M{"_id": keywordStatsID},
+		bson.M{"$inc": bson.M{"documents": documents, "length": length}},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("failed to update keyword stats: %w", err)
+	}
+	return nil
+}
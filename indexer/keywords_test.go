@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeywordTermsKeepIdentifiersWhole(t *testing.T) {
+	require.Equal(t,
+		[]string{"fix", "pinecone.top_k", "pinecone", "top_k", "err-conn-42", "err", "conn", "42", "getuserbyid"},
+		keywordTerms("Fix pinecone.top_k for ERR-CONN-42 in getUserById()."))
+}
+
+func TestKeywordFrequenciesKeepMostFrequentTerms(t *testing.T) {
+	frequencies := keywordFrequencies("Retry on ErrTimeout", []string{"client/retry.go"}, "+ return ErrTimeout")
+	require.Equal(t, map[string]int{"retry": 2, "errtimeout": 2, "return": 1, "client": 1, "retry.go": 1, "go": 1}, frequencies)
+
+	var diff strings.Builder
+	for i := 0; i < maxKeywordTerms+10; i++ {
+		fmt.Fprintf(&diff, "term%d ", i)
+	}
+	frequencies = keywordFrequencies("term7 term7", nil, diff.String())
+	require.Len(t, frequencies, maxKeywordTerms)
+	require.Equal(t, 3, frequencies["term7"])
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ledger records every indexed commit together with the vectors stored for
+// it, and maintains the people profiles derived from those commits.
+type ledger struct {
+	commitCol     *mongo.Collection
+	peopleCol     *mongo.Collection
+	documentCol   *mongo.Collection
+	generationCol *mongo.Collection
+
+	// summaryCol keeps the summaries of commits that are not recorded yet,
+	// so a failure after a summary was generated does not pay for it twice.
+	summaryCol *mongo.Collection
+
+	keywordCol      *mongo.Collection
+	keywordStatsCol *mongo.Collection
+}
+
+// LedgerEntry is the record of one indexed commit.
+type LedgerEntry struct {
+	ID        string    `json:"id" bson:"_id"`
+	RepoID    string    `json:"repo_id" bson:"repo_id"`
+	SHA       string    `json:"sha" bson:"sha"`
+	Person    string    `json:"person" bson:"person"`
+	Author    string    `json:"author" bson:"author"`
+	Contact   string    `json:"contact" bson:"contact"`
+	Timestamp time.Time `json:"timestamp" bson:"timestamp"`
+	Files     []string  `json:"files,omitempty" bson:"files,omitempty"`
+	VectorIDs []string  `json:"vector_ids" bson:"vector_ids"`
+	Summary   string    `json:"summary,omitempty" bson:"summary,omitempty"`
+
+	Languages  []string `json:"languages,omitempty" bson:"languages,omitempty"`
+	Components []string `json:"components,omitempty" bson:"components,omitempty"`
+
+	// Generations lists the index generations holding the vectors.
+	Generations []string `json:"generations,omitempty" bson:"generations,omitempty"`
+}
+
+// Person is the profile of a contributor, aggregated over all repositories.
+type Person struct {
+	ID           string                  `json:"id" bson:"_id"`
+	Name         string                  `json:"name" bson:"name"`
+	Contact      string                  `json:"contact" bson:"contact"`
+	Repositories map[string]Contribution `json:"repositories" bson:"repositories"`
+}
+
+// Contribution summarises what a person contributed to one repository.
+// Languages and components count the commits touching them.
+type Contribution struct {
+	Commits    int64            `json:"commits" bson:"commits"`
+	LastCommit time.Time        `json:"last_commit" bson:"last_commit"`
+	Languages  map[string]int64 `json:"languages,omitempty" bson:"languages,omitempty"`
+	Components map[string]int64 `json:"components,omitempty" bson:"components,omitempty"`
+}
+
+// DocumentEntry is the record of a document embedded at a branch head.
+type DocumentEntry struct {
+	ID           string                `json:"id" bson:"_id"`
+	RepoID       string                `json:"repo_id" bson:"repo_id"`
+	Branch       string                `json:"branch" bson:"branch"`
+	Path         string                `json:"path" bson:"path"`
+	Blob         string                `json:"blob" bson:"blob"`
+	Contributors []DocumentContributor `json:"contributors" bson:"contributors"`
+	VectorIDs    []string              `json:"vector_ids" bson:"vector_ids"`
+	Generations  []string              `json:"generations,omitempty" bson:"generations,omitempty"`
+}
+
+// DocumentContributor is one of the people who changed a document most often.
+type DocumentContributor struct {
+	Person  string `json:"person" bson:"_id"`
+	Author  string `json:"author" bson:"author"`
+	Contact string `json:"contact" bson:"contact"`
+	Commits int64  `json:"commits" bson:"commits"`
+}
+
+func (c DocumentContributor) String() string {
+	return fmt.Sprintf("%s <%s> (%d commits)", c.Author, c.Contact, c.Commits)
+}
+
+func newLedger(db *mongo.Database) *ledger {
+	return &ledger{
+		commitCol:     db.Collection("commits"),
+		peopleCol:     db.Collection("people"),
+		documentCol:   db.Collection("documents"),
+		generationCol: db.Collection("generations"),
+
+		summaryCol: db.Collection("summaries"),
+
+		keywordCol:      db.Collection("keywords"),
+		keywordStatsCol: db.Collection("keyword_stats"),
+	}
+}
+
+func ledgerID(repoID, sha string) string {
+	return fmt.Sprintf("%s:%s", repoID, sha)
+}
+
+// personID identifies a contributor without storing the raw email address.
+// It is keyed by the pseudonym secret, so it cannot be reversed by hashing
+// known addresses; without a secret it is a plain hash and only fit for the
+// ledger.
+func personID(email string) string {
+	mac := hmac.New(sha256.New, []byte(config.Privacy.PseudonymSecret))
+	mac.Write([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return hex.EncodeToString(mac.Sum(nil)[:12])
+}
+
+// fieldKey makes a name usable as a MongoDB field name, which must not
+// contain dots or start with a dollar sign.
+func fieldKey(name string) string {
+	return strings.NewReplacer(".", "_", "$", "_").Replace(name)
+}
+
+// record stores the ledger entry of a commit. The profile of its author is
+// only updated the first time a commit is recorded, so re-indexing a
+// repository does not count contributions twice.
+func (l *ledger) record(ctx context.Context, entry LedgerEntry) error {
+	if l == nil {
+		return nil
+	}
+
+	result, err := l.commitCol.ReplaceOne(ctx, bson.M{"_id": entry.ID}, entry, options.Replace().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("failed to record commit: %w", err)
+	}
+	if entry.Summary != "" {
+		if _, err := l.summaryCol.DeleteOne(ctx, bson.M{"_id": entry.ID}); err != nil {
+			return fmt.Errorf("failed to delete pending summary: %w", err)
+		}
+	}
+	if result.UpsertedCount == 0 {
+		return nil
+	}
+
+	contribution := "repositories." + entry.RepoID
+	counts := bson.M{contribution + ".commits": 1}
+	for _, language := range entry.Languages {
+		counts[contribution+".languages."+fieldKey(language)] = 1
+	}
+	for _, component := range entry.Components {
+		counts[contribution+".components."+fieldKey(component)] = 1
+	}
+
+	_, err = l.peopleCol.UpdateOne(ctx,
+		bson.M{"_id": entry.Person},
+		bson.M{
+			"$set": bson.M{"name": entry.Author, "contact": entry.Contact},
+			"$inc": counts,
+			"$max": bson.M{contribution + ".last_commit": entry.Timestamp},
+		},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("failed to update person profile: %w", err)
+	}
+
+	return nil
+}
+
+// summary returns the commit summary stored by an earlier run, if any,
+// whether or not that run recorded the commit.
+func (l *ledger) summary(ctx context.Context, id string) (string, error) {
+	if l == nil {
+		return "", nil
+	}
+
+	for _, col := range []*mongo.Collection{l.summaryCol, l.commitCol} {
+		var entry LedgerEntry
+		err := col.FindOne(ctx, bson.M{"_id": id}, options.FindOne().SetProjection(bson.M{"summary": 1})).Decode(&entry)
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read commit summary: %w", err)
+		}
+		if entry.Summary != "" {
+			return entry.Summary, nil
+		}
+	}
+	return "", nil
+}
+
+// saveSummary keeps a generated summary until its commit is recorded.
+func (l *ledger) saveSummary(ctx context.Context, repoID, id, summary string) error {
+	if l == nil {
+		return nil
+	}
+
+	_, err := l.summaryCol.UpdateOne(ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"repo_id": repoID, "summary": summary}},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("failed to save commit summary: %w", err)
+	}
+	return nil
+}
+
+// vectorIDs returns the IDs of all vectors recorded for a repository.
+func (l *ledger) vectorIDs(ctx context.Context, repoID string) ([]string, error) {
+	return l.vectorIDsMatching(ctx, bson.M{"repo_id": repoID})
+}
+
+// vectorIDsMatching returns the IDs of the vectors recorded for the commits
+// and documents matching filter.
+func (l *ledger) vectorIDsMatching(ctx context.Context, filter bson.M) ([]string, error) {
+	var ids []string
+	for _, col := range []*mongo.Collection{l.commitCol, l.documentCol} {
+		cursor, err := col.Find(ctx, filter, options.Find().SetProjection(bson.M{"vector_ids": 1}))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", col.Name(), err)
+		}
+
+		var entries []struct {
+			VectorIDs []string `bson:"vector_ids"`
+		}
+		if err := cursor.All(ctx, &entries); err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", col.Name(), err)
+		}
+
+		for _, entry := range entries {
+			ids = append(ids, entry.VectorIDs...)
+		}
+	}
+	return ids, nil
+}
+
+// topContributors returns the people with the most recorded commits
+// touching a file, most active first.
+func (l *ledger) topContributors(ctx context.Context, repoID, path string, limit int) ([]DocumentContributor, error) {
+	if l == nil {
+		return nil, nil
+	}
+
+	cursor, err := l.commitCol.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"repo_id": repoID, "files": path}}},
+		{{Key: "$sort", Value: bson.M{"timestamp": 1}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":     "$person",
+			"author":  bson.M{"$last": "$author"},
+			"contact": bson.M{"$last": "$contact"},
+			"commits": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "commits", Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$limit", Value: limit}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate contributors: %w", err)
+	}
+
+	var contributors []DocumentContributor
+	if err := cursor.All(ctx, &contributors); err != nil {
+		return nil, fmt.Errorf("failed to decode contributors: %w", err)
+	}
+	return contributors, nil
+}
+
+// document returns the record of a document, or nil if it was never embedded.
+func (l *ledger) document(ctx context.Context, id string) (*DocumentEntry, error) {
+	if l == nil {
+		return nil, nil
+	}
+
+	var entry DocumentEntry
+	err := l.documentCol.FindOne(ctx, bson.M{"_id": id}).Decode(&entry)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read document: %w", err)
+	}
+	return &entry, nil
+}
+
+// documents returns the documents recorded for a repository, limited to a
+// branch unless it is empty.
+func (l *ledger) documents(ctx context.Context, repoID, branch string) ([]DocumentEntry, error) {
+	if l == nil {
+		return nil, nil
+	}
+
+	filter := bson.M{"repo_id": repoID}
+	if branch != "" {
+		filter["branch"] = branch
+	}
+
+	cursor, err := l.documentCol.Find(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list documents: %w", err)
+	}
+
+	var entries []DocumentEntry
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, fmt.Errorf("failed to decode documents: %w", err)
+	}
+	return entries, nil
+}
+
+func (l *ledger) recordDocument(ctx context.Context, entry DocumentEntry) error {
+	if l == nil {
+		return nil
+	}
+
+	_, err := l.documentCol.ReplaceOne(ctx, bson.M{"_id": entry.ID}, entry, options.Replace().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("failed to record document: %w", err)
+	}
+	return nil
+}
+
+func (l *ledger) deleteDocument(ctx context.Context, id string) error {
+	_, err := l.documentCol.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return fmt.Errorf("failed to delete document: %w", err)
+	}
+	return nil
+}
+
+// purge removes the ledger entries, documents and keyword postings of a
+// repository and its contributions from all people profiles. Profiles left without
+// contributions are deleted.
+func (l *ledger) purge(ctx context.Context, repoID string) error {
+	_, err := l.commitCol.DeleteMany(ctx, bson.M{"repo_id": repoID})
+	if err != nil {
+		return fmt.Errorf("failed to delete commits: %w", err)
+	}
+
+	_, err = l.documentCol.DeleteMany(ctx, bson.M{"repo_id": repoID})
+	if err != nil {
+		return fmt.Errorf("failed to delete documents: %w", err)
+	}
+
+	_, err = l.summaryCol.DeleteMany(ctx, bson.M{"repo_id": repoID})
+	if err != nil {
+		return fmt.Errorf("failed to delete summaries: %w", err)
+	}
+
+	if err := l.purgeKeywords(ctx, repoID); err != nil {
+		return err
+	}
+
+	contribution := "repositories." + repoID
+	_, err = l.peopleCol.UpdateMany(ctx,
+		bson.M{contribution: bson.M{"$exists": true}},
+		bson.M{"$unset": bson.M{contribution: ""}})
+	if err != nil {
+		return fmt.Errorf("failed to remove contributions: %w", err)
+	}
+
+	_, err = l.peopleCol.DeleteMany(ctx, bson.M{"repositories": bson.M{}})
+	if err != nil {
+		return fmt.Errorf("failed to delete empty profiles: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// correlationIDProperty carries the ID the repository service assigns to a
+// job, so its log lines can be followed across both services.
+const correlationIDProperty = "correlation_id"
+
+// logLevel is the minimum level of the logger, set from the configuration
+// once it is loaded.
+var logLevel = zap.NewAtomicLevel()
+
+// logger writes JSON lines to stderr.
+var logger = newLogger()
+
+func newLogger() *zap.SugaredLogger {
+	zapConfig := zap.NewProductionConfig()
+	zapConfig.EncoderConfig.TimeKey = "time"
+	zapConfig.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	zapConfig.Level = logLevel
+
+	l, err := zapConfig.Build()
+	if err != nil {
+		panic(err)
+	}
+	return l.Sugar()
+}
+
+// setLogLevel sets the minimum level of the logger. Unknown levels are
+// rejected when the configuration is validated and ignored here.
+func setLogLevel(name string) {
+	if level, err := zapcore.ParseLevel(name); err == nil {
+		logLevel.SetLevel(level)
+	}
+}
+
+// newCorrelationID returns a random ID for a job the indexer queues itself.
+func newCorrelationID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(id)
+}
+
+type loggerKey struct{}
+
+// withLogger attaches a logger to the context of a job.
+func withLogger(ctx context.Context, l *zap.SugaredLogger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// loggerFrom returns the logger of the job running in ctx, which carries its
+// correlation ID, or the global logger outside of a job.
+func loggerFrom(ctx context.Context) *zap.SugaredLogger {
+	if l, ok := ctx.Value(loggerKey{}).(*zap.SugaredLogger); ok {
+		return l
+	}
+	return logger
+}
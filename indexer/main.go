@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"time"
 
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -13,36 +12,71 @@ import (
 )
 
 func main() {
-	serviceBusConnectionString := os.Getenv("AZURE_SERVICE_BUS_CONNECTION_STRING")
-	queueName := os.Getenv("QUEUE_NAME")
+	var err error
+	config, err = loadConfig()
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	setLogLevel(config.LogLevel)
+
+	if len(os.Args) > 1 {
+		if err := runCommand(context.Background(), os.Args[1:]); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	mongoDBConnectionString := os.Getenv("COSMOS_DB_CONNECTION_STRING")
-	clientOptions := options.Client().ApplyURI(mongoDBConnectionString)
-	client, err := mongo.Connect(context.Background(), clientOptions)
+	if err := config.validate(true); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	defer logger.Sync()
+
+	shutdownTracing, err := initTracing(context.Background())
+	if err != nil {
+		panic(err)
+	}
+	defer shutdownTracing(context.Background())
+
+	client, err := connectDatabase(context.Background())
 	if err != nil {
 		panic(err)
 	}
 	defer client.Disconnect(context.Background())
 
-	repoCol := client.Database("repositoryDB").Collection("repositories")
+	repoDB := client.Database("repositoryDB")
+	repoCol := repoDB.Collection("repositories")
+	usageCol := repoDB.Collection("usage")
 
-	ns, err := servicebus.NewNamespace(servicebus.NamespaceWithConnectionString(serviceBusConnectionString))
+	ns, err := servicebus.NewNamespace(servicebus.NamespaceWithConnectionString(config.ServiceBus.ConnectionString))
 	if err != nil {
 		panic(err)
 	}
 
-	queue, err := ns.NewQueue(queueName)
+	queue, err := ns.NewQueue(config.ServiceBus.QueueName)
 	if err != nil {
 		panic(err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
-	handler := NewMessageHandler(repoCol)
-	err = queue.Receive(ctx, handler)
+	ledger := newLedger(repoDB)
+	if err := ledger.ensureKeywordIndexes(context.Background()); err != nil {
+		logger.Warnw("Keyword searches may be slow", "error", err)
+	}
 
-	defer cancel()
+	handler := NewMessageHandler(repoCol, usageCol, ledger, queue, queue)
+	worker := newWorker(queue, handler)
+	serveMetrics(worker, newAdminAPI(repoCol, handler.ledger, queue))
 
+	err = worker.run()
 	if err != nil {
-		fmt.Println("Error processing messages:", err)
+		logger.Errorw("Error processing messages", "error", err)
 	}
 }
+
+// connectDatabase connects to the configured database.
+func connectDatabase(ctx context.Context) (*mongo.Client, error) {
+	clientOptions := options.Client().ApplyURI(config.Database.ConnectionString)
+	return mongo.Connect(ctx, clientOptions)
+}
@@ -2,34 +2,258 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	servicebus "github.com/Azure/azure-service-bus-go"
 	"github.com/pinecone-io/go-pinecone/pinecone_grpc"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type MessageHandler struct {
 	servicebus.Handler
 	repoCol  *mongo.Collection
+	usageCol *mongo.Collection
+	ledger   *ledger
 	pcClient pinecone_grpc.VectorServiceClient
+
+	locks       lockRenewer
+	lockRenewal time.Duration
+	jobs        jobSender
+
+	// repositories holds a mutex per repository, so two jobs of the same
+	// repository never run at once.
+	repositories sync.Map
+
+	stop       chan struct{}
+	drainMutex sync.Mutex
+	draining   bool
+	active     sync.WaitGroup
+}
+
+// lockRenewer renews the lock of a message, as a servicebus.Queue does.
+type lockRenewer interface {
+	RenewLocks(ctx context.Context, messages ...*servicebus.Message) error
+}
+
+// jobSender queues follow-up jobs, as a servicebus.Queue does.
+type jobSender interface {
+	Send(ctx context.Context, msg *servicebus.Message) error
 }
 
-func NewMessageHandler(repoCol *mongo.Collection) *MessageHandler {
+// modeProperty selects what the indexer does with the repository in a
+// message. Migrate and collect jobs carry a generation ID instead.
+const (
+	modeProperty    = "mode"
+	modeIndex       = "index"
+	modeDryRun      = "dry-run"
+	modePurge       = "purge"
+	modeIncremental = "incremental"
+	modeMigrate     = "migrate"
+	modeCollect     = "collect"
+)
+
+func NewMessageHandler(repoCol, usageCol *mongo.Collection, ledger *ledger, locks lockRenewer, jobs jobSender) *MessageHandler {
 	return &MessageHandler{
-		repoCol: repoCol,
+		repoCol:     repoCol,
+		usageCol:    usageCol,
+		ledger:      ledger,
+		locks:       locks,
+		jobs:        jobs,
+		lockRenewal: time.Duration(config.Worker.LockRenewalSeconds) * time.Second,
+		stop:        make(chan struct{}),
 	}
 }
 
-func (h *MessageHandler) Handle(ctx context.Context, msg *servicebus.Message) error {
+// Handle runs the job of a message. Jobs do not run on the receive context,
+// which is cancelled on shutdown while running jobs still store their
+// checkpoint. Jobs interrupted by a shutdown are rescheduled.
+func (h *MessageHandler) Handle(_ context.Context, msg *servicebus.Message) error {
+	ctx := withStop(context.Background(), h.stop)
+	if !h.begin() {
+		return h.reschedule(ctx, msg)
+	}
+	defer h.active.Done()
+
 	repoID := string(msg.Data)
+	mode, _ := msg.UserProperties[modeProperty].(string)
+	if mode == "" {
+		mode = modeIndex
+	}
+	correlationID, _ := msg.UserProperties[correlationIDProperty].(string)
+	observeQueueLag(mode, msg)
+
+	ctx = otel.GetTextMapPropagator().Extract(ctx, messageCarrier(msg.UserProperties))
+	ctx, span := tracer.Start(ctx, "indexer.job", trace.WithAttributes(
+		attribute.String("repo.id", repoID),
+		attribute.String("job.mode", mode),
+		attribute.String("correlation.id", correlationID),
+	))
+	log := logger.With(correlationIDProperty, correlationID, "repo_id", repoID, "mode", mode)
+	ctx = withLogger(ctx, log)
+	log.Info("Received job")
+
+	stopRenewal := h.renewLock(ctx, msg)
+	err := h.run(ctx, repoID, mode)
+	stopRenewal()
+	endSpan(span, err)
 
-	err := indexRepository(ctx, repoID, h.repoCol)
+	jobsTotal.WithLabelValues(mode, jobResult(err)).Inc()
+	if errors.Is(err, errShuttingDown) {
+		log.Info("Job interrupted by shutdown, returning it to the queue")
+		return h.reschedule(ctx, msg)
+	}
 	if err != nil {
-		fmt.Println("Error indexing repository:", err)
+		log.Errorw("Job failed", "error", err)
 	} else {
-		fmt.Printf("Repository %s indexed successfully\n", repoID)
+		log.Info("Job completed")
 	}
 
 	return msg.Complete(ctx)
 }
+
+func (h *MessageHandler) run(ctx context.Context, repoID, mode string) error {
+	unlock := h.lockRepository(repoID)
+	defer unlock()
+	if stopRequested(ctx) {
+		return errShuttingDown
+	}
+
+	switch mode {
+	case modeDryRun:
+		return estimateRepository(ctx, repoID, h.repoCol)
+	case modePurge:
+		return purgeRepository(ctx, repoID, h.repoCol, h.ledger)
+	case modeIncremental:
+		return indexPushedCommits(ctx, repoID, h.repoCol, h.usageCol, h.ledger)
+	case modeMigrate:
+		return migrateIndex(ctx, repoID, h.ledger, h.usageCol, h.jobs)
+	case modeCollect:
+		return collectGeneration(ctx, repoID, h.ledger, h.usageCol, h.jobs)
+	default:
+		return indexRepository(ctx, repoID, h.repoCol, h.usageCol, h.ledger)
+	}
+}
+
+// reschedule queues the job of a message again and completes the message.
+// Abandoning it instead would count as a failed delivery, and a job
+// interrupted by a few deployments would end up dead-lettered. The job
+// resumes from its checkpoint. The message is only abandoned if the job
+// cannot be queued.
+func (h *MessageHandler) reschedule(ctx context.Context, msg *servicebus.Message) error {
+	if err := h.requeue(ctx, msg); err != nil {
+		loggerFrom(ctx).Warnw("Failed to reschedule job, abandoning it", "error", err)
+		return msg.Abandon(ctx)
+	}
+	return msg.Complete(ctx)
+}
+
+// requeue sends a copy of a message, keeping its mode, correlation ID and
+// trace context.
+func (h *MessageHandler) requeue(ctx context.Context, msg *servicebus.Message) error {
+	if h.jobs == nil {
+		return errors.New("no job queue")
+	}
+
+	job := servicebus.NewMessage(msg.Data)
+	job.UserProperties = msg.UserProperties
+	if err := h.jobs.Send(ctx, job); err != nil {
+		return fmt.Errorf("failed to requeue job: %w", err)
+	}
+	return nil
+}
+
+// begin registers a job, unless the handler is draining.
+func (h *MessageHandler) begin() bool {
+	h.drainMutex.Lock()
+	defer h.drainMutex.Unlock()
+	if h.draining {
+		return false
+	}
+	h.active.Add(1)
+	return true
+}
+
+// drain asks running jobs to checkpoint and waits until they returned.
+func (h *MessageHandler) drain() {
+	h.drainMutex.Lock()
+	if !h.draining {
+		h.draining = true
+		close(h.stop)
+	}
+	h.drainMutex.Unlock()
+	h.active.Wait()
+}
+
+// lockRepository waits until no other job of the repository runs.
+func (h *MessageHandler) lockRepository(repoID string) func() {
+	value, _ := h.repositories.LoadOrStore(repoID, &sync.Mutex{})
+	mutex := value.(*sync.Mutex)
+	mutex.Lock()
+	return mutex.Unlock
+}
+
+// renewLock keeps the message locked while its job runs. Service Bus
+// releases a lock after the lock duration of the queue, at most five
+// minutes, and would then deliver the message to another worker.
+func (h *MessageHandler) renewLock(ctx context.Context, msg *servicebus.Message) func() {
+	if h.locks == nil {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(h.lockRenewal)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				renewCtx, cancel := context.WithTimeout(ctx, lockRenewalTimeout)
+				err := h.locks.RenewLocks(renewCtx, msg)
+				cancel()
+				if err != nil {
+					loggerFrom(ctx).Warnw("Failed to renew message lock", "error", err)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// sendJob queues a job, to be received no earlier than at unless it is zero.
+// The job joins the trace of ctx under a new correlation ID.
+func sendJob(ctx context.Context, jobs jobSender, id, mode string, at time.Time) error {
+	properties := map[string]interface{}{
+		modeProperty:          mode,
+		correlationIDProperty: newCorrelationID(),
+	}
+	otel.GetTextMapPropagator().Inject(ctx, messageCarrier(properties))
+
+	msg := servicebus.NewMessageFromString(id)
+	msg.UserProperties = properties
+	if !at.IsZero() {
+		msg.ScheduleAt(at)
+	}
+
+	if err := jobs.Send(ctx, msg); err != nil {
+		return fmt.Errorf("failed to queue %s job: %w", mode, err)
+	}
+	return nil
+}
+
+func jobResult(err error) string {
+	if errors.Is(err, errShuttingDown) {
+		return "interrupted"
+	}
+	if err != nil {
+		return "failed"
+	}
+	return "succeeded"
+}
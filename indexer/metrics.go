@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	servicebus "github.com/Azure/azure-service-bus-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	commitsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "indexer_commits_total",
+		Help: "Commits seen by the indexer, by result: walked, skipped, embedded or failed.",
+	}, []string{"result"})
+
+	tokensTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "indexer_tokens_total",
+		Help: "OpenAI tokens consumed, by kind: embedding or summary.",
+	}, []string{"kind"})
+
+	embeddingDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "indexer_embedding_duration_seconds",
+		Help:    "Latency of OpenAI embedding requests.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	upsertDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "indexer_vector_upsert_duration_seconds",
+		Help:    "Latency of Pinecone vector upserts.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	queueLag = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "indexer_queue_lag_seconds",
+		Help:    "Time between a message becoming available on the queue and the indexer receiving it, by mode.",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 10),
+	}, []string{"mode"})
+
+	jobsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "indexer_jobs_total",
+		Help: "Jobs handled by the indexer, by mode and result.",
+	}, []string{"mode", "result"})
+)
+
+// serveMetrics exposes the Prometheus metrics, the liveness and readiness
+// endpoints of the worker and the admin API on the configured port.
+func serveMetrics(w *worker, admin *adminAPI) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", w.liveness)
+	mux.HandleFunc("/readyz", w.readiness)
+	mux.HandleFunc("/api/export", admin.export)
+	mux.HandleFunc("/api/import", admin.importBundle)
+	mux.HandleFunc("/api/generations", admin.generations)
+	go func() {
+		if err := http.ListenAndServe(":"+config.HTTP.Port, mux); err != nil {
+			logger.Errorw("Error serving metrics", "error", err)
+		}
+	}()
+}
+
+// observeQueueLag records how long a message waited on the queue. Scheduled
+// messages only become available at their scheduled time.
+func observeQueueLag(mode string, msg *servicebus.Message) {
+	if msg.SystemProperties == nil || msg.SystemProperties.EnqueuedTime == nil {
+		return
+	}
+
+	available := *msg.SystemProperties.EnqueuedTime
+	if scheduled := msg.SystemProperties.ScheduledEnqueueTime; scheduled != nil && scheduled.After(available) {
+		available = *scheduled
+	}
+	queueLag.WithLabelValues(mode).Observe(time.Since(available).Seconds())
+}
+
+func observeDuration(histogram prometheus.Observer, start time.Time) {
+	histogram.Observe(time.Since(start).Seconds())
+}
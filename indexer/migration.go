@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// migrationBatchSize is the number of ledger entries re-embedded at a time.
+const migrationBatchSize = 50
+
+// migrateIndex builds a generation from the text stored with the vectors of
+// the active one while chat keeps reading the active one. Once every ledger
+// entry is migrated, reads switch to the new generation and the previous
+// one is collected after the configured retention.
+func migrateIndex(ctx context.Context, id string, ledger *ledger, usageCol *mongo.Collection, jobs jobSender) error {
+	state, err := ledger.indexState(ctx)
+	if err != nil {
+		return err
+	}
+	if state.Building == nil || state.Building.ID != id {
+		loggerFrom(ctx).Infow("Generation is not being built, skipping migration", "generation", id)
+		return nil
+	}
+
+	target := *state.Building
+	budget := newTokenBudget(usageCol, Repository{ID: "generation:" + target.ID})
+	loggerFrom(ctx).Infow("Migrating index", "from", state.Active.ID, "to", target.ID, "model", target.Model)
+	err = copyEntries(ctx, ledger, state.Active, target, budget)
+	if err != nil {
+		return err
+	}
+
+	retired, err := ledger.activateGeneration(ctx, target.ID)
+	if err != nil {
+		return err
+	}
+	loggerFrom(ctx).Infow("Activated generation", "generation", target.ID, "retired", retired.ID)
+
+	retention := time.Duration(config.Generations.RetentionMinutes) * time.Minute
+	err = sendJob(ctx, jobs, retired.ID, modeCollect, time.Now().Add(retention))
+	if err != nil {
+		return err
+	}
+
+	// Commits indexed while the switch happened went to the retired generation.
+	return copyEntries(ctx, ledger, retired, target, budget)
+}
+
+// collectGeneration deletes the vectors of a retired generation. Jobs that
+// started before the switch may have written to it until now, so their
+// entries are copied to the active generation first.
+func collectGeneration(ctx context.Context, id string, ledger *ledger, usageCol *mongo.Collection, jobs jobSender) error {
+	state, err := ledger.indexState(ctx)
+	if err != nil {
+		return err
+	}
+	retired, ok := state.retired(id)
+	if !ok {
+		return nil
+	}
+
+	// Entries missing from the active generation belong to the migration
+	// in progress; collect once it is done.
+	if state.Building != nil {
+		retention := time.Duration(config.Generations.RetentionMinutes) * time.Minute
+		return sendJob(ctx, jobs, id, modeCollect, time.Now().Add(retention))
+	}
+
+	budget := newTokenBudget(usageCol, Repository{ID: "generation:" + state.Active.ID})
+	err = copyEntries(ctx, ledger, retired, state.Active, budget)
+	if err != nil {
+		return err
+	}
+
+	store, err := newVectorStore(retired)
+	if err != nil {
+		return err
+	}
+	err = store.deleteAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	loggerFrom(ctx).Infow("Collected generation", "generation", id)
+	return ledger.dropGeneration(ctx, id)
+}
+
+// copyEntries re-embeds the vectors of the ledger entries that are not in
+// the target generation yet, from the text stored with them in the source
+// generation. Vectors missing from the source cannot be copied and are
+// skipped, so a single entry never blocks a migration.
+func copyEntries(ctx context.Context, ledger *ledger, source, target Generation, budget *tokenBudget) error {
+	model, err := target.embeddingModel()
+	if err != nil {
+		return err
+	}
+	from, err := newVectorStore(source)
+	if err != nil {
+		return err
+	}
+	to, err := newVectorStore(target)
+	if err != nil {
+		return err
+	}
+
+	client := newOpenAIClient()
+	filter := bson.M{"generations": bson.M{"$ne": target.ID}}
+	batch := options.Find().SetProjection(bson.M{"vector_ids": 1}).SetLimit(migrationBatchSize)
+	for _, col := range []*mongo.Collection{ledger.commitCol, ledger.documentCol} {
+		for {
+			if stopRequested(ctx) {
+				return errShuttingDown
+			}
+
+			cursor, err := col.Find(ctx, filter, batch)
+			if err != nil {
+				return fmt.Errorf("failed to list %s: %w", col.Name(), err)
+			}
+			var entries []struct {
+				ID        interface{} `bson:"_id"`
+				VectorIDs []string    `bson:"vector_ids"`
+			}
+			if err := cursor.All(ctx, &entries); err != nil {
+				return fmt.Errorf("failed to decode %s: %w", col.Name(), err)
+			}
+			if len(entries) == 0 {
+				break
+			}
+
+			ids := make([]interface{}, 0, len(entries))
+			var vectorIDs []string
+			for _, entry := range entries {
+				ids = append(ids, entry.ID)
+				vectorIDs = append(vectorIDs, entry.VectorIDs...)
+			}
+
+			vectors, err := from.fetch(ctx, vectorIDs)
+			if err != nil {
+				return err
+			}
+			if missing := len(vectorIDs) - len(vectors); missing > 0 {
+				loggerFrom(ctx).Warnw("Vectors missing from source generation", "generation", source.ID, "missing", missing)
+			}
+
+			vectors, err = reembedVectors(ctx, client, model, budget, vectors)
+			if err != nil {
+				return err
+			}
+			err = to.upsert(ctx, vectors)
+			if err != nil {
+				return err
+			}
+
+			err = ledger.markMigrated(ctx, col, ids, target.ID)
+			if err != nil {
+				return err
+			}
+			err = ledger.recordProgress(ctx, target.ID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// reembedVectors embeds the text stored in the metadata of the vectors with
+// the model. The metadata is kept as it is.
+func reembedVectors(ctx context.Context, client *openai.Client, model openai.EmbeddingModel, budget *tokenBudget, vectors []storedVector) ([]storedVector, error) {
+	texts := make([]string, 0, len(vectors))
+	embedded := make([]storedVector, 0, len(vectors))
+	var estimated int64
+	for _, vector := range vectors {
+		text, _ := vector.Metadata["text"].(string)
+		if text == "" {
+			loggerFrom(ctx).Warnw("Vector has no text to re-embed", "id", vector.ID)
+			continue
+		}
+		texts = append(texts, text)
+		embedded = append(embedded, storedVector{ID: vector.ID, Metadata: vector.Metadata})
+		estimated += estimateTokens(text)
+	}
+	if len(texts) == 0 {
+		return nil, nil
+	}
+
+	err := budget.reserve(ctx, estimated)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	response, err := requestEmbeddings(ctx, client, createEmbeddingRequest(model, texts...))
+	observeDuration(embeddingDuration, start)
+	if err != nil {
+		return nil, err
+	}
+	if len(response.Data) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(response.Data))
+	}
+
+	used := int64(response.Usage.TotalTokens)
+	tokensTotal.WithLabelValues("embedding").Add(float64(used))
+	err = budget.record(ctx, used)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, data := range response.Data {
+		embedded[data.Index].Values = data.Embedding
+	}
+	return embedded, nil
+}
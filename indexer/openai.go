@@ -3,116 +3,122 @@ package main
 import (
 	"context"
 	"fmt"
-	"os"
+	"time"
 
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/pinecone-io/go-pinecone/pinecone_grpc"
 	"github.com/sashabaranov/go-openai"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/protobuf/types/known/structpb"
 )
 
 const maxDiffStringLength = 8000
 const chunkCutoffThreshold = 1
 
-func generateEmbeddings(commitMsg string, author object.Signature, email, diffString string, commitId string, repoURL string) ([]*pinecone_grpc.Vector, error) {
-	client := newOpenAIClient()
-
-	if len(diffString) < maxDiffStringLength {
-		return generateSingleEmbedding(client, commitMsg, author, email, diffString, commitId, repoURL)
-	}
+// embeddingModel is the model of the initial index generation.
+const embeddingModel = openai.AdaEmbeddingV2
 
-	return generateChunkedEmbeddings(client, commitMsg, author, email, diffString, commitId, repoURL)
+// embeddingPricePer1KTokens lists the USD price of 1000 input tokens per model.
+var embeddingPricePer1KTokens = map[string]float64{
+	openai.AdaEmbeddingV2.String(): 0.0001,
 }
 
-func newOpenAIClient() *openai.Client {
-	return openai.NewClient(os.Getenv("OPEN_AI_KEY"))
+// embeddingInput is a single text that is embedded and stored as one vector.
+type embeddingInput struct {
+	ID   string
+	Text string
 }
 
-func generateSingleEmbedding(client *openai.Client, commitMsg string, author object.Signature, email, diffString string, commitId string, repoURL string) ([]*pinecone_grpc.Vector, error) {
-	embeddings := make([]*pinecone_grpc.Vector, 0)
-	input := fmt.Sprintf("Author: %s\nRepoURL:\n%s\nCommit-Message:\n%s\nEmail: %s\nCommitId: \n%s\nDiff: %s", author.Name, repoURL, commitMsg, email, commitId, diffString)
-	metadata := &structpb.Struct{
-		Fields: map[string]*structpb.Value{
-			"text": &structpb.Value{
-				Kind: &structpb.Value_StringValue{
-					StringValue: input,
-				},
-			},
-		},
+func embeddingInputs(commitMsg string, author object.Signature, email, diffString string, commitId string, repoURL string) []embeddingInput {
+	if len(diffString) < maxDiffStringLength {
+		input := fmt.Sprintf("Author: %s\nRepoURL:\n%s\nCommit-Message:\n%s\nEmail: %s\nCommitId: \n%s\nDiff: %s", author.Name, repoURL, commitMsg, email, commitId, diffString)
+		return []embeddingInput{{ID: commitId, Text: input}}
 	}
 
-	embeddingReq := createEmbeddingRequest(input)
+	chunks := chunkString(diffString, maxDiffStringLength)
+	inputs := make([]embeddingInput, 0, chunkCutoffThreshold+1)
+	for i, chunk := range chunks {
+		if i > chunkCutoffThreshold {
+			break
+		}
 
-	response, err := requestEmbeddings(client, embeddingReq)
+		embeddingsId := commitId
+		if i != 0 {
+			embeddingsId = fmt.Sprintf("%s-%d", commitId, i)
+		}
 
-	if err != nil {
-		return nil, err
+		input := fmt.Sprintf("Author: %s\nRepo-URL:\n%s\nCommit-Message:%s\nEmail: %s\nChunk:\n%d\nCommitId: \n%s\nDiff: %s", author.Name, repoURL, commitMsg, email, i, commitId, chunk)
+		inputs = append(inputs, embeddingInput{ID: embeddingsId, Text: input})
 	}
 
-	embeddingsId := commitId
+	return inputs
+}
 
-	embedding := &pinecone_grpc.Vector{
-		Id:       embeddingsId,
-		Values:   response.Data[0].Embedding,
-		Metadata: metadata,
+// generateEmbeddings embeds the inputs and attaches the shared metadata plus
+// the embedded text to every vector.
+func generateEmbeddings(ctx context.Context, job *indexJob, inputs []embeddingInput, metadata map[string]interface{}) ([]*pinecone_grpc.Vector, int64, error) {
+	model, err := job.index.Active.embeddingModel()
+	if err != nil {
+		return nil, 0, err
 	}
 
-	embeddings = append(embeddings, embedding)
+	client := newOpenAIClient()
+	embeddings := make([]*pinecone_grpc.Vector, 0, len(inputs))
+	var tokens int64
 
-	return embeddings, nil
-}
+	for _, input := range inputs {
+		err := job.budget.reserve(ctx, estimateTokens(input.Text))
+		if err != nil {
+			return nil, tokens, err
+		}
 
-func generateChunkedEmbeddings(client *openai.Client, commitMsg string, author object.Signature, email, diffString string, commitId string, repoURL string) ([]*pinecone_grpc.Vector, error) {
-	chunks := chunkString(diffString, maxDiffStringLength)
-	embeddings := make([]*pinecone_grpc.Vector, 0)
+		start := time.Now()
+		response, err := requestEmbeddings(ctx, client, createEmbeddingRequest(model, input.Text))
+		observeDuration(embeddingDuration, start)
+		if err != nil {
+			return nil, tokens, err
+		}
 
-	for i, chunk := range chunks {
-		if i <= chunkCutoffThreshold {
-			input := fmt.Sprintf("Author: %s\nRepo-URL:\n%s\nCommit-Message:%s\nEmail: %s\nChunk:\n%d\nCommitId: \n%s\nDiff: %s", author.Name, repoURL, commitMsg, email, i, commitId, chunk)
-			embeddingReq := createEmbeddingRequest(input)
-
-			response, err := requestEmbeddings(client, embeddingReq)
-			if err != nil {
-				return nil, err
-			}
-
-			embeddingsId := commitId
-			if i != 0 {
-				embeddingsId = fmt.Sprintf("%s-%d", commitId, i)
-			}
-
-			metadata := &structpb.Struct{
-				Fields: map[string]*structpb.Value{
-					"text": &structpb.Value{
-						Kind: &structpb.Value_StringValue{
-							StringValue: input,
-						},
-					},
-				},
-			}
-
-			embedding := &pinecone_grpc.Vector{
-				Id:       embeddingsId,
-				Values:   response.Data[0].Embedding,
-				Metadata: metadata,
-			}
-
-			embeddings = append(embeddings, embedding)
+		used := int64(response.Usage.TotalTokens)
+		tokens += used
+		tokensTotal.WithLabelValues("embedding").Add(float64(used))
+		err = job.budget.record(ctx, used)
+		if err != nil {
+			return nil, tokens, err
 		}
+
+		vectorMetadata, err := structpb.NewStruct(metadata)
+		if err != nil {
+			return nil, tokens, fmt.Errorf("failed to build vector metadata: %w", err)
+		}
+		vectorMetadata.Fields["text"] = structpb.NewStringValue(input.Text)
+
+		embeddings = append(embeddings, &pinecone_grpc.Vector{
+			Id:       vectorID(job.repo.ID, input.ID),
+			Values:   response.Data[0].Embedding,
+			Metadata: vectorMetadata,
+		})
 	}
 
-	return embeddings, nil
+	return embeddings, tokens, nil
 }
 
-func createEmbeddingRequest(input string) openai.EmbeddingRequest {
+func newOpenAIClient() *openai.Client {
+	return openai.NewClient(config.OpenAI.APIKey)
+}
+
+func createEmbeddingRequest(model openai.EmbeddingModel, input ...string) openai.EmbeddingRequest {
 	return openai.EmbeddingRequest{
-		Input: []string{input},
-		Model: openai.AdaEmbeddingV2,
+		Input: input,
+		Model: model,
 	}
 }
 
-func requestEmbeddings(client *openai.Client, embeddingReq openai.EmbeddingRequest) (*openai.EmbeddingResponse, error) {
-	response, err := client.CreateEmbeddings(context.Background(), embeddingReq)
+func requestEmbeddings(ctx context.Context, client *openai.Client, embeddingReq openai.EmbeddingRequest) (*openai.EmbeddingResponse, error) {
+	ctx, span := tracer.Start(ctx, "openai.embeddings", trace.WithAttributes(attribute.String("openai.model", embeddingReq.Model.String())))
+	response, err := client.CreateEmbeddings(ctx, embeddingReq)
+	endSpan(span, err)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate embeddings from OpenAI: %w", err)
 	}
@@ -120,6 +126,16 @@ func requestEmbeddings(client *openai.Client, embeddingReq openai.EmbeddingReque
 	return &response, nil
 }
 
+// estimateTokens approximates the token count of a text without a tokenizer,
+// using the rule of thumb of four characters per token.
+func estimateTokens(text string) int64 {
+	return int64((len(text) + 3) / 4)
+}
+
+func estimateCost(model string, tokens int64) float64 {
+	return float64(tokens) / 1000 * embeddingPricePer1KTokens[model]
+}
+
 func chunkString(str string, chunkSize int) []string {
 	var chunks []string
 	for i := 0; i < len(str); i += chunkSize {
@@ -5,46 +5,134 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
-	"os"
+	"strings"
+	"time"
 
 	"github.com/pinecone-io/go-pinecone/pinecone_grpc"
 	"github.com/sashabaranov/go-openai"
 )
 
-func storeEmbeddings(commitId string, embeddings []*pinecone_grpc.Vector) error {
-	pineconeAPIURL := os.Getenv("PINECONE_API_URL")
-	pineconeAPIURL = fmt.Sprintf("%s/vectors/upsert", pineconeAPIURL)
-	apiKey := os.Getenv("PINECONE_API_KEY")
+// pineconeDeleteBatchSize is the maximum number of IDs Pinecone deletes per request.
+const pineconeDeleteBatchSize = 1000
 
-	requestBody, err := json.Marshal(map[string]interface{}{
-		"vectors": embeddings,
+// pineconeStore is a namespace of a Pinecone index. The zero value is the
+// default namespace of the index at PINECONE_API_URL.
+type pineconeStore struct {
+	url       string
+	namespace string
+}
+
+func newPineconeStore(generation Generation) pineconeStore {
+	return pineconeStore{url: generation.Index, namespace: generation.Namespace}
+}
+
+func (s pineconeStore) storeEmbeddings(ctx context.Context, embeddings []*pinecone_grpc.Vector) error {
+	defer observeDuration(upsertDuration, time.Now())
+
+	_, err := s.request(ctx, http.MethodPost, "/vectors/upsert", map[string]interface{}{
+		"vectors":   embeddings,
+		"namespace": s.namespace,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upsert embeddings to Pinecone: %w", err)
+	}
+
+	return nil
+}
+
+// deleteVectors deletes vectors by ID.
+func (s pineconeStore) deleteVectors(ctx context.Context, ids []string) error {
+	for start := 0; start < len(ids); start += pineconeDeleteBatchSize {
+		end := start + pineconeDeleteBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		_, err := s.request(ctx, http.MethodPost, "/vectors/delete", map[string]interface{}{
+			"ids":       ids[start:end],
+			"namespace": s.namespace,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete vectors from Pinecone: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// deleteRepositoryVectors deletes every vector tagged with the repository ID.
+func (s pineconeStore) deleteRepositoryVectors(ctx context.Context, repoID string) error {
+	_, err := s.request(ctx, http.MethodPost, "/vectors/delete", map[string]interface{}{
+		"filter": map[string]interface{}{
+			"repoId": map[string]interface{}{"$eq": repoID},
+		},
+		"namespace": s.namespace,
 	})
 	if err != nil {
-		return fmt.Errorf("failed to marshal JSON: %w", err)
+		return fmt.Errorf("failed to delete repository vectors from Pinecone: %w", err)
+	}
+
+	return nil
+}
+
+// request sends a request to the Pinecone index. The body, if any, is sent
+// as JSON.
+func (s pineconeStore) request(ctx context.Context, method, path string, body interface{}) (_ []byte, err error) {
+	operation, _, _ := strings.Cut(path, "?")
+	ctx, span := tracer.Start(ctx, "pinecone"+operation)
+	defer func() { endSpan(span, err) }()
+
+	pineconeAPIURL := s.url
+	if pineconeAPIURL == "" {
+		pineconeAPIURL = config.VectorStore.PineconeURL
+	}
+	pineconeAPIURL += path
+
+	var requestBody io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal JSON: %w", err)
+		}
+		requestBody = bytes.NewBuffer(encoded)
 	}
 
-	req, err := http.NewRequestWithContext(context.Background(), "POST", pineconeAPIURL, bytes.NewBuffer(requestBody))
+	req, err := http.NewRequestWithContext(ctx, method, pineconeAPIURL, requestBody)
 	if err != nil {
-		return fmt.Errorf("failed to create POST request: %w", err)
+		return nil, fmt.Errorf("failed to create %s request: %w", method, err)
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Api-Key", apiKey)
+	req.Header.Set("Api-Key", config.VectorStore.PineconeAPIKey)
 
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to send POST request: %w", err)
+		return nil, fmt.Errorf("failed to send %s request: %w", method, err)
 	}
 	defer resp.Body.Close()
 
+	responseBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
 	if resp.StatusCode != http.StatusOK {
-		body, _ := ioutil.ReadAll(resp.Body)
-		return fmt.Errorf("failed to upsert embeddings to Pinecone, status code: %d, response: %s", resp.StatusCode, string(body))
+		return nil, fmt.Errorf("status code: %d, response: %s", resp.StatusCode, string(responseBody))
 	}
 
-	return nil
+	return responseBody, nil
+}
+
+// vectorID scopes a vector ID to its repository, so the same commit in two
+// repositories, such as a fork, does not overwrite the other's vectors.
+func vectorID(repoID, id string) string {
+	if repoID == "" {
+		return id
+	}
+	return fmt.Sprintf("%s:%s", repoID, id)
 }
 
 func transformToPineconeVectors(commitId string, embeddings []openai.Embedding) []*pinecone_grpc.Vector {
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// Privacy modes control how contributors are identified in indexed text.
+const (
+	privacyEmail     = "email"
+	privacyName      = "name"
+	privacyHandle    = "handle"
+	privacyPseudonym = "pseudonym"
+)
+
+const withheldContact = "withheld"
+
+var emailRE = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+
+// PrivacyPolicy decides which personal data of contributors is indexed.
+// Handles maps lower-case email addresses to internal directory handles.
+type PrivacyPolicy struct {
+	Mode    string            `json:"mode" bson:"mode"`
+	Handles map[string]string `json:"handles,omitempty" bson:"handles,omitempty"`
+}
+
+// errNoPseudonymSecret refuses the pseudonym mode without a secret. The
+// pseudonyms are keyed hashes of email addresses; keyed by anything public,
+// they could be reversed by hashing known addresses.
+var errNoPseudonymSecret = errors.New("privacy mode pseudonym requires privacy.pseudonym_secret (PSEUDONYM_SECRET)")
+
+// validate checks that the policy can be applied with the configuration.
+func (p *PrivacyPolicy) validate() error {
+	if p != nil && p.Mode == privacyPseudonym && config.Privacy.PseudonymSecret == "" {
+		return errNoPseudonymSecret
+	}
+	return nil
+}
+
+// contributor returns the name and contact to index for an author.
+func (p *PrivacyPolicy) contributor(name, email string) (string, string) {
+	if p == nil {
+		return name, email
+	}
+
+	switch p.Mode {
+	case privacyName:
+		return name, withheldContact
+	case privacyHandle:
+		if handle, ok := p.Handles[strings.ToLower(email)]; ok {
+			return name, handle
+		}
+		return name, withheldContact
+	case privacyPseudonym:
+		pseudonym := pseudonymise(email)
+		return pseudonym, pseudonym
+	default:
+		return name, email
+	}
+}
+
+// sharesPerson reports whether the person ID of contributors may be stored
+// with their vectors. Only repositories that index email addresses share it,
+// so the ID does not link otherwise masked contributions.
+func (p *PrivacyPolicy) sharesPerson() bool {
+	return p == nil || p.Mode == "" || p.Mode == privacyEmail
+}
+
+// maskEmails replaces email addresses in text, such as Co-authored-by trailers,
+// with the contact the policy allows for them.
+func (p *PrivacyPolicy) maskEmails(text string) string {
+	if p.sharesPerson() {
+		return text
+	}
+
+	return emailRE.ReplaceAllStringFunc(text, func(email string) string {
+		_, contact := p.contributor("", email)
+		return contact
+	})
+}
+
+// pseudonymise derives a stable pseudonym from an email address. The chat
+// service derives the same pseudonyms, so the pseudonym secrets must match.
+// Without a secret the contact is withheld.
+func pseudonymise(email string) string {
+	secret := config.Privacy.PseudonymSecret
+	if secret == "" {
+		return withheldContact
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return "contributor-" + hex.EncodeToString(mac.Sum(nil))[:10]
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrivacyPolicyContributor(t *testing.T) {
+	handles := map[string]string{"jane@example.com": "@jdoe"}
+
+	tests := []struct {
+		policy  *PrivacyPolicy
+		name    string
+		contact string
+	}{
+		{policy: nil, name: "Jane Doe", contact: "Jane@Example.com"},
+		{policy: &PrivacyPolicy{Mode: privacyEmail}, name: "Jane Doe", contact: "Jane@Example.com"},
+		{policy: &PrivacyPolicy{Mode: privacyName}, name: "Jane Doe", contact: withheldContact},
+		{policy: &PrivacyPolicy{Mode: privacyHandle, Handles: handles}, name: "Jane Doe", contact: "@jdoe"},
+		{policy: &PrivacyPolicy{Mode: privacyHandle}, name: "Jane Doe", contact: withheldContact},
+	}
+
+	for _, test := range tests {
+		name, contact := test.policy.contributor("Jane Doe", "Jane@Example.com")
+		require.Equal(t, test.name, name)
+		require.Equal(t, test.contact, contact)
+	}
+
+	config.Privacy.PseudonymSecret = "a-secret-for-tests"
+	defer func() { config.Privacy.PseudonymSecret = "" }()
+
+	pseudonym := (&PrivacyPolicy{Mode: privacyPseudonym}).maskEmails("Co-authored-by: Jane <jane@example.com>")
+	require.Equal(t, "Co-authored-by: Jane <"+pseudonymise("JANE@example.com")+">", pseudonym)
+	require.NotEqual(t, withheldContact, pseudonymise("jane@example.com"))
+}
+
+func TestPseudonymModeRequiresSecret(t *testing.T) {
+	policy := &PrivacyPolicy{Mode: privacyPseudonym}
+	require.ErrorIs(t, policy.validate(), errNoPseudonymSecret)
+
+	name, contact := policy.contributor("Jane Doe", "jane@example.com")
+	require.Equal(t, withheldContact, name)
+	require.Equal(t, withheldContact, contact)
+
+	config.Privacy.PseudonymSecret = "a-secret-for-tests"
+	defer func() { config.Privacy.PseudonymSecret = "" }()
+	require.NoError(t, policy.validate())
+}
+
+func TestPersonIDIsKeyed(t *testing.T) {
+	id := personID("Ada@Example.com")
+	require.Equal(t, id, personID(" ada@example.com"))
+
+	config.Privacy.PseudonymSecret = "a-secret-for-tests"
+	defer func() { config.Privacy.PseudonymSecret = "" }()
+	require.NotEqual(t, id, personID("ada@example.com"))
+}
+
+func TestPrivacyPolicySharesPerson(t *testing.T) {
+	var none *PrivacyPolicy
+	require.True(t, none.sharesPerson())
+	require.True(t, (&PrivacyPolicy{Mode: privacyEmail}).sharesPerson())
+	for _, mode := range []string{privacyName, privacyHandle, privacyPseudonym} {
+		require.False(t, (&PrivacyPolicy{Mode: mode}).sharesPerson(), mode)
+	}
+}
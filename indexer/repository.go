@@ -5,26 +5,49 @@ import (
 	fmt "fmt"
 
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type Repository struct {
 	ID             string `json:"id,omitempty" bson:"_id,omitempty"`
 	Name           string `json:"name"`
 	URL            string `json:"url"`
-	IndexingStatus string `json:"indexing_status"`
+	IndexingStatus string `json:"indexing_status" bson:"status"`
+	Walker         string `json:"walker,omitempty" bson:"walker,omitempty"`
+
+	MonthlyTokenBudget int64          `json:"monthly_token_budget,omitempty" bson:"monthly_token_budget,omitempty"`
+	ScrubPatterns      []ScrubPattern `json:"scrub_patterns,omitempty" bson:"scrub_patterns,omitempty"`
+	Privacy            *PrivacyPolicy `json:"privacy,omitempty" bson:"privacy,omitempty"`
+	Summaries          bool           `json:"summaries,omitempty" bson:"summaries,omitempty"`
+	DocumentGlobs      []string       `json:"document_globs,omitempty" bson:"document_globs,omitempty"`
+
+	Checkpoint  string                `json:"checkpoint,omitempty" bson:"checkpoint,omitempty"`
+	LastJob     *JobReport            `json:"last_job,omitempty" bson:"last_job,omitempty"`
+	Estimate    *JobReport            `json:"estimate,omitempty" bson:"estimate,omitempty"`
+	CodeOwners  map[string]CodeOwners `json:"code_owners,omitempty" bson:"code_owners,omitempty"`
+	Heads       map[string]string     `json:"heads,omitempty" bson:"heads,omitempty"`
+	PendingPush *PendingPush          `json:"pending_push,omitempty" bson:"pending_push,omitempty"`
+}
+
+// PendingPush collects the branches pushed to since the last incremental job.
+type PendingPush struct {
+	Branches  []string `json:"branches" bson:"branches"`
+	Scheduled bool     `json:"scheduled" bson:"scheduled"`
 }
 
+const (
+	statusIndexed      = "indexed"
+	statusPausedBudget = "paused_budget"
+	statusDeleting     = "deleting"
+)
+
 func getRepositoryByID(ctx context.Context, repoID string, repoCol *mongo.Collection) (Repository, error) {
 	var repo Repository
-	objectID, err := primitive.ObjectIDFromHex(repoID)
-	if err != nil {
-		return repo, fmt.Errorf("failed to convert repoID to ObjectID: %w", err)
-	}
 
-	filter := bson.M{"_id": objectID}
-	err = repoCol.FindOne(ctx, filter).Decode(&repo)
+	// The repository service keys repositories by the SHA-256 hash of their URL.
+	filter := bson.M{"_id": repoID}
+	err := repoCol.FindOne(ctx, filter).Decode(&repo)
 	if err != nil {
 		return repo, fmt.Errorf("failed to find repository by ID: %w", err)
 	}
@@ -32,11 +55,27 @@ func getRepositoryByID(ctx context.Context, repoID string, repoCol *mongo.Collec
 	return repo, nil
 }
 
-func updateRepositoryStatus(ctx context.Context, repo Repository, status string, repoCol *mongo.Collection) error {
-	_, err := repoCol.UpdateOne(ctx, bson.M{"_id": repo.ID}, bson.M{"$set": bson.M{"status": status}})
+func updateRepository(ctx context.Context, repo Repository, fields bson.M, repoCol *mongo.Collection) error {
+	_, err := repoCol.UpdateOne(ctx, bson.M{"_id": repo.ID}, bson.M{"$set": fields})
 	if err != nil {
-		return fmt.Errorf("failed to update repository status: %w", err)
+		return fmt.Errorf("failed to update repository: %w", err)
 	}
 
-	return bson.ErrDecodeToNil
+	return nil
+}
+
+// takePendingPush removes the pending push of a repository and returns the
+// repository as it was before, so pushes arriving from now on schedule a
+// new job.
+func takePendingPush(ctx context.Context, repoID string, repoCol *mongo.Collection) (Repository, error) {
+	var repo Repository
+	err := repoCol.FindOneAndUpdate(ctx,
+		bson.M{"_id": repoID},
+		bson.M{"$unset": bson.M{"pending_push": ""}},
+		options.FindOneAndUpdate().SetReturnDocument(options.Before)).Decode(&repo)
+	if err != nil {
+		return repo, fmt.Errorf("failed to find repository by ID: %w", err)
+	}
+
+	return repo, nil
 }
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// ScrubPattern is a repository specific secret pattern. Matches are replaced
+// with a placeholder carrying the pattern's name.
+type ScrubPattern struct {
+	Name    string `json:"name" bson:"name"`
+	Pattern string `json:"pattern" bson:"pattern"`
+}
+
+// secretDetector replaces the given submatch of every match of pattern. When
+// accept is set, matches it rejects are left untouched.
+type secretDetector struct {
+	name     string
+	pattern  *regexp.Regexp
+	submatch int
+	accept   func(string) bool
+}
+
+const minSecretEntropy = 3.5
+
+var builtinDetectors = []secretDetector{
+	{
+		name:    "private_key",
+		pattern: regexp.MustCompile(`(?s)-----BEGIN [A-Z0-9 ]*PRIVATE KEY( BLOCK)?-----.*?(-----END [A-Z0-9 ]*PRIVATE KEY( BLOCK)?-----|$)`),
+	},
+	{
+		name:    "connection_string",
+		pattern: regexp.MustCompile(`\b[a-zA-Z][a-zA-Z0-9+.\-]*://[^\s:/@"'<>]+:[^\s@/"'<>]+@[^\s"'<>]+`),
+	},
+	{
+		name:     "connection_string",
+		pattern:  regexp.MustCompile(`(?i)\b(?:AccountKey|SharedAccessKey|SharedAccessSignature|Password|Pwd)=([^;\s"']+)`),
+		submatch: 1,
+	},
+	{
+		name:    "jwt",
+		pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_\-]{8,}\.eyJ[A-Za-z0-9_\-]{8,}\.[A-Za-z0-9_\-]{8,}`),
+	},
+	{
+		name:    "aws_access_key",
+		pattern: regexp.MustCompile(`\b(?:AKIA|ASIA|AGPA|AIDA|AROA)[0-9A-Z]{16}\b`),
+	},
+	{
+		name:    "gcp_api_key",
+		pattern: regexp.MustCompile(`\bAIza[0-9A-Za-z_\-]{35}\b`),
+	},
+	{
+		name:    "github_token",
+		pattern: regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`),
+	},
+	{
+		name:    "slack_token",
+		pattern: regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9\-]{10,}`),
+	},
+	{
+		name:     "high_entropy_secret",
+		pattern:  regexp.MustCompile(`(?i)[A-Za-z0-9_.\-]*(?:key|secret|token|passw(?:or)?d|pwd|credential|auth)[A-Za-z0-9_.\-]*["']?\s*[:=]\s*["']?([A-Za-z0-9+/=_\-.~]{16,})`),
+		submatch: 1,
+		accept:   isHighEntropy,
+	},
+}
+
+type scrubber struct {
+	detectors []secretDetector
+}
+
+func newScrubber(custom []ScrubPattern) (*scrubber, error) {
+	detectors := append([]secretDetector{}, builtinDetectors...)
+	for _, pattern := range custom {
+		re, err := regexp.Compile(pattern.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scrub pattern %q: %w", pattern.Name, err)
+		}
+
+		name := pattern.Name
+		if name == "" {
+			name = "custom"
+		}
+		detectors = append(detectors, secretDetector{name: name, pattern: re})
+	}
+
+	return &scrubber{detectors: detectors}, nil
+}
+
+// scrub replaces detected secrets with typed placeholders and returns the
+// number of redactions per detector.
+func (s *scrubber) scrub(text string) (string, map[string]int) {
+	counts := map[string]int{}
+	if s == nil {
+		return text, counts
+	}
+
+	for _, detector := range s.detectors {
+		text = detector.redact(text, counts)
+	}
+	return text, counts
+}
+
+func (d secretDetector) redact(text string, counts map[string]int) string {
+	matches := d.pattern.FindAllStringSubmatchIndex(text, -1)
+	if len(matches) == 0 {
+		return text
+	}
+
+	placeholder := fmt.Sprintf("[REDACTED:%s]", d.name)
+	var builder strings.Builder
+	last := 0
+	for _, match := range matches {
+		start, end := match[2*d.submatch], match[2*d.submatch+1]
+		if start < 0 {
+			continue
+		}
+		if d.accept != nil && !d.accept(text[start:end]) {
+			continue
+		}
+
+		builder.WriteString(text[last:start])
+		builder.WriteString(placeholder)
+		last = end
+		counts[d.name]++
+	}
+	builder.WriteString(text[last:])
+
+	return builder.String()
+}
+
+// isHighEntropy reports whether a value looks random enough to be a secret
+// rather than a word, identifier or variable reference.
+func isHighEntropy(value string) bool {
+	hasLetter := strings.IndexFunc(value, unicode.IsLetter) >= 0
+	hasDigit := strings.IndexFunc(value, unicode.IsDigit) >= 0
+	return hasLetter && hasDigit && shannonEntropy(value) >= minSecretEntropy
+}
+
+func shannonEntropy(value string) float64 {
+	if value == "" {
+		return 0
+	}
+
+	frequencies := map[rune]float64{}
+	for _, r := range value {
+		frequencies[r]++
+	}
+
+	entropy := 0.0
+	length := float64(len([]rune(value)))
+	for _, count := range frequencies {
+		p := count / length
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
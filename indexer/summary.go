@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	summaryModel          = openai.GPT3Dot5Turbo
+	summaryMaxTokens      = 150
+	summaryDiffLength     = 4000
+	summaryDiffExcerpt    = 2000
+	summaryPromptOverhead = 60
+)
+
+const summaryPrompt = "You summarise git commits for a search index that finds experts. In at most three sentences, describe what the commit changes and why, naming the components, technologies and topics involved. Do not mention the author. Answer with the summary only."
+
+// chatPricePer1KTokens lists the USD price of 1000 tokens per chat model.
+var chatPricePer1KTokens = map[string]float64{
+	openai.GPT3Dot5Turbo: 0.002,
+}
+
+// summarizeCommit returns a short "what and why" summary of a commit. A
+// summary stored in the ledger by an earlier run, even one that failed
+// afterwards, is reused instead of calling the model again.
+func summarizeCommit(ctx context.Context, job *indexJob, commitID, commitMsg, diffString string) (string, int64, error) {
+	summary, err := job.ledger.summary(ctx, ledgerID(job.repo.ID, commitID))
+	if err != nil || summary != "" {
+		return summary, 0, err
+	}
+
+	messages := summaryMessages(commitMsg, diffString)
+	err = job.budget.reserve(ctx, estimateSummaryTokens(commitMsg, diffString))
+	if err != nil {
+		return "", 0, err
+	}
+
+	completionCtx, span := tracer.Start(ctx, "openai.chat_completion", trace.WithAttributes(attribute.String("openai.model", summaryModel)))
+	resp, err := newOpenAIClient().CreateChatCompletion(completionCtx, openai.ChatCompletionRequest{
+		Model:       summaryModel,
+		Messages:    messages,
+		MaxTokens:   summaryMaxTokens,
+		Temperature: 0,
+		N:           1,
+	})
+	endSpan(span, err)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to summarise commit: %w", err)
+	}
+	if len(resp.Choices) == 0 {
+		return "", 0, fmt.Errorf("failed to summarise commit: empty response")
+	}
+
+	// The summary is saved before the budget can stop the job, so a retry
+	// does not generate it again.
+	summary = strings.TrimSpace(resp.Choices[0].Message.Content)
+	saveErr := job.ledger.saveSummary(ctx, job.repo.ID, ledgerID(job.repo.ID, commitID), summary)
+
+	tokens := int64(resp.Usage.TotalTokens)
+	tokensTotal.WithLabelValues("summary").Add(float64(tokens))
+	err = job.budget.record(ctx, tokens)
+	if err != nil {
+		return "", tokens, err
+	}
+	if saveErr != nil {
+		return "", tokens, saveErr
+	}
+
+	return summary, tokens, nil
+}
+
+func summaryMessages(commitMsg, diffString string) []openai.ChatCompletionMessage {
+	return []openai.ChatCompletionMessage{
+		{Role: "system", Content: summaryPrompt},
+		{Role: "user", Content: fmt.Sprintf("Commit-Message:\n%s\nDiff:\n%s", commitMsg, trimDiff(diffString, summaryDiffLength))},
+	}
+}
+
+// estimateSummaryTokens approximates the prompt and completion tokens of a summary.
+func estimateSummaryTokens(commitMsg, diffString string) int64 {
+	prompt := summaryPrompt + commitMsg + trimDiff(diffString, summaryDiffLength)
+	return estimateTokens(prompt) + summaryPromptOverhead + summaryMaxTokens
+}
+
+func trimDiff(diffString string, length int) string {
+	if len(diffString) > length {
+		return diffString[:length]
+	}
+	return diffString
+}
+
+// summaryEmbeddingInput embeds the summary together with the commit message
+// instead of the raw diff.
+func summaryEmbeddingInput(commitMsg, summary string, author, email, commitId, repoURL string) embeddingInput {
+	input := fmt.Sprintf("Author: %s\nRepoURL:\n%s\nCommit-Message:\n%s\nSummary:\n%s\nEmail: %s\nCommitId: \n%s", author, repoURL, commitMsg, summary, email, commitId)
+	return embeddingInput{ID: commitId, Text: input}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// pineconeFetchBatchSize keeps the IDs of a fetch request within URL length limits.
+const pineconeFetchBatchSize = 100
+
+// pineconeUpsertBatchSize is the number of vectors Pinecone accepts per upsert.
+const pineconeUpsertBatchSize = 100
+
+// storedVector is a vector as kept by a vector store.
+type storedVector struct {
+	ID       string                 `json:"id"`
+	Values   []float32              `json:"values"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// vectorStore is the backend the embeddings are kept in. VECTOR_STORE
+// selects it; only Pinecone is supported so far.
+type vectorStore interface {
+	// dimension returns the dimension of the vectors the store accepts.
+	dimension(ctx context.Context) (int, error)
+	// fetch returns the stored vectors among ids. Unknown IDs are skipped.
+	fetch(ctx context.Context, ids []string) ([]storedVector, error)
+	upsert(ctx context.Context, vectors []storedVector) error
+	// deleteAll deletes every vector of the store.
+	deleteAll(ctx context.Context) error
+}
+
+// newVectorStore returns the store holding the vectors of a generation.
+func newVectorStore(generation Generation) (vectorStore, error) {
+	switch name := config.VectorStore.Kind; name {
+	case "", "pinecone":
+		return newPineconeStore(generation), nil
+	default:
+		return nil, fmt.Errorf("unknown vector store %q", name)
+	}
+}
+
+// activeStore returns the active generation and its store.
+func activeStore(ctx context.Context, ledger *ledger) (Generation, vectorStore, error) {
+	state, err := ledger.indexState(ctx)
+	if err != nil {
+		return Generation{}, nil, err
+	}
+	store, err := newVectorStore(state.Active)
+	return state.Active, store, err
+}
+
+func (s pineconeStore) dimension(ctx context.Context) (int, error) {
+	body, err := s.request(ctx, http.MethodPost, "/describe_index_stats", map[string]interface{}{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to describe Pinecone index: %w", err)
+	}
+
+	var stats struct {
+		Dimension int `json:"dimension"`
+	}
+	if err := json.Unmarshal(body, &stats); err != nil {
+		return 0, fmt.Errorf("failed to decode index stats: %w", err)
+	}
+	return stats.Dimension, nil
+}
+
+func (s pineconeStore) fetch(ctx context.Context, ids []string) ([]storedVector, error) {
+	var vectors []storedVector
+	for start := 0; start < len(ids); start += pineconeFetchBatchSize {
+		end := start + pineconeFetchBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		query := url.Values{"ids": ids[start:end], "namespace": {s.namespace}}
+		body, err := s.request(ctx, http.MethodGet, "/vectors/fetch?"+query.Encode(), nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch vectors from Pinecone: %w", err)
+		}
+
+		var response struct {
+			Vectors map[string]storedVector `json:"vectors"`
+		}
+		if err := json.Unmarshal(body, &response); err != nil {
+			return nil, fmt.Errorf("failed to decode fetched vectors: %w", err)
+		}
+
+		// The response is keyed by ID; keep the order of the request.
+		for _, id := range ids[start:end] {
+			if vector, ok := response.Vectors[id]; ok {
+				vectors = append(vectors, vector)
+			}
+		}
+	}
+
+	return vectors, nil
+}
+
+func (s pineconeStore) upsert(ctx context.Context, vectors []storedVector) error {
+	defer observeDuration(upsertDuration, time.Now())
+
+	for start := 0; start < len(vectors); start += pineconeUpsertBatchSize {
+		end := start + pineconeUpsertBatchSize
+		if end > len(vectors) {
+			end = len(vectors)
+		}
+
+		_, err := s.request(ctx, http.MethodPost, "/vectors/upsert", map[string]interface{}{
+			"vectors":   vectors[start:end],
+			"namespace": s.namespace,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to upsert vectors to Pinecone: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (s pineconeStore) deleteAll(ctx context.Context) error {
+	_, err := s.request(ctx, http.MethodPost, "/vectors/delete", map[string]interface{}{
+		"deleteAll": true,
+		"namespace": s.namespace,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete namespace %q from Pinecone: %w", s.namespace, err)
+	}
+	return nil
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+const (
+	goGitWalker  = "go-git"
+	gitLogWalker = "git-log"
+)
+
+const maxDiffLength = 32000
+
+// Commit is the walker independent view of a commit that processCommit consumes.
+type Commit struct {
+	Hash    string
+	Parents []string
+	Author  object.Signature
+	Message string
+	Diff    string
+	Files   object.FileStats
+
+	loadDiff func(*Commit) error
+}
+
+// resolveDiff fills in Diff and Files for walkers that compute them lazily.
+func (c *Commit) resolveDiff() error {
+	if c.loadDiff == nil {
+		return nil
+	}
+	load := c.loadDiff
+	c.loadDiff = nil
+	return load(c)
+}
+
+// CommitWalker walks the history reachable from a commit, newest first.
+// Commits reachable from exclude are left out, unless it is the zero hash,
+// so a branch merged since exclude is walked down to where it forked.
+type CommitWalker interface {
+	Walk(ctx context.Context, from, exclude plumbing.Hash, fn func(*Commit) error) error
+}
+
+func newCommitWalker(name string, r *git.Repository, repoPath string) (CommitWalker, error) {
+	switch name {
+	case "", goGitWalker:
+		return &goGitCommitWalker{repo: r}, nil
+	case gitLogWalker:
+		return &gitLogCommitWalker{path: repoPath}, nil
+	default:
+		return nil, fmt.Errorf("unknown commit walker: %s", name)
+	}
+}
+
+type goGitCommitWalker struct {
+	repo *git.Repository
+}
+
+func (w *goGitCommitWalker) Walk(ctx context.Context, from, exclude plumbing.Hash, fn func(*Commit) error) error {
+	mutex.Lock()
+	iter, err := w.log(from, exclude)
+	shallow := w.shallow()
+	mutex.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to get commit log: %w", err)
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		commit, err := getNextCommit(iter)
+		if err == io.EOF {
+			return nil
+		}
+		if errors.Is(err, plumbing.ErrObjectNotFound) && shallow {
+			// The history of a shallow clone ends at its missing parents.
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get next commit: %w", err)
+		}
+
+		parents := make([]string, 0, commit.NumParents())
+		for _, hash := range commit.ParentHashes {
+			parents = append(parents, hash.String())
+		}
+
+		goGitCommit := commit
+		err = fn(&Commit{
+			Hash:    commit.Hash.String(),
+			Parents: parents,
+			Author:  commit.Author,
+			Message: commit.Message,
+			loadDiff: func(c *Commit) error {
+				diff, files, err := getDiff(goGitCommit)
+				if err != nil {
+					return err
+				}
+				c.Diff = diff
+				c.Files = files
+				return nil
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// log iterates the commits reachable from from but not from exclude. The
+// iterator does not walk past excluded commits.
+func (w *goGitCommitWalker) log(from, exclude plumbing.Hash) (object.CommitIter, error) {
+	if exclude.IsZero() {
+		return w.repo.Log(&git.LogOptions{From: from})
+	}
+
+	excluded := map[plumbing.Hash]bool{}
+	ancestors, err := w.repo.Log(&git.LogOptions{From: exclude})
+	if err != nil {
+		return nil, err
+	}
+	err = ancestors.ForEach(func(commit *object.Commit) error {
+		excluded[commit.Hash] = true
+		return nil
+	})
+	if err != nil && !(errors.Is(err, plumbing.ErrObjectNotFound) && w.shallow()) {
+		return nil, err
+	}
+
+	commit, err := w.repo.CommitObject(from)
+	if err != nil {
+		return nil, err
+	}
+	return object.NewCommitPreorderIter(commit, excluded, nil), nil
+}
+
+// shallow reports whether the repository is a shallow clone.
+func (w *goGitCommitWalker) shallow() bool {
+	hashes, err := w.repo.Storer.Shallow()
+	return err == nil && len(hashes) > 0
+}
+
+func getNextCommit(iter object.CommitIter) (*object.Commit, error) {
+	mutex.Lock()
+	commit, err := iter.Next()
+	mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return commit, nil
+}
+
+func getDiff(commit *object.Commit) (string, object.FileStats, error) {
+	if commit == nil || commit.NumParents() == 0 {
+		return "", nil, nil
+	}
+
+	previousCommit, err := commit.Parent(0)
+	if err != nil {
+		return "", nil, fmt.Errorf("error getting parent commit: %w", err)
+	}
+
+	if previousCommit == nil {
+		return "", nil, fmt.Errorf("previousCommit is nil")
+	}
+
+	diff, err := previousCommit.Patch(commit)
+	if err != nil {
+		return "", nil, fmt.Errorf("error getting diff: %w", err)
+	}
+
+	diffString := diff.String()
+	if len(diffString) > maxDiffLength {
+		diffString = diffString[:maxDiffLength]
+	}
+	return diffString, diff.Stats(), nil
+}
+
+// gitLogCommitWalker streams `git log --patch --numstat` instead of computing
+// patches through go-git, which is considerably faster on large histories.
+type gitLogCommitWalker struct {
+	path string
+}
+
+// Each record of the git log output starts with a boundary that is unique
+// per run, so commit messages and patches cannot end a record early.
+const (
+	gitLogFieldSeparator = "\x00"
+	gitLogFields         = "%H%x00%P%x00%an%x00%ae%x00%ai%x00%B%x00"
+	gitLogTimeLayout     = "2006-01-02 15:04:05 -0700"
+)
+
+var commitHashRE = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+func newGitLogBoundary() []byte {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		panic(err)
+	}
+	return []byte("\x1e" + hex.EncodeToString(token) + "\x1e")
+}
+
+func (w *gitLogCommitWalker) Walk(ctx context.Context, from, exclude plumbing.Hash, fn func(*Commit) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	boundary := newGitLogBoundary()
+	args := []string{"-c", "core.quotePath=false", "log",
+		"--patch", "--numstat", "--no-renames", "--no-color", "--no-ext-diff",
+		"--diff-merges=first-parent", "--format=" + string(boundary) + gitLogFields, from.String()}
+	if !exclude.IsZero() {
+		args = append(args, "^"+exclude.String())
+	}
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = w.path
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to open git log output: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start git log: %w", err)
+	}
+
+	parseErr := parseGitLog(stdout, boundary, fn)
+	if parseErr != nil {
+		cancel()
+		io.Copy(io.Discard, stdout)
+	}
+
+	waitErr := cmd.Wait()
+	if parseErr != nil {
+		return parseErr
+	}
+	if waitErr != nil {
+		return fmt.Errorf("git log failed: %w: %s", waitErr, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func parseGitLog(r io.Reader, boundary []byte, fn func(*Commit) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 1<<20), math.MaxInt)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		if i := bytes.Index(data, boundary); i >= 0 {
+			return i + len(boundary), data[:i], nil
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	})
+
+	// Everything before the first boundary is empty.
+	for scanner.Scan() {
+		record := scanner.Bytes()
+		if len(record) == 0 {
+			continue
+		}
+
+		commit, err := parseGitLogRecord(string(record))
+		if err != nil {
+			return err
+		}
+		if err := fn(commit); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read git log output: %w", err)
+	}
+	return nil
+}
+
+func parseGitLogRecord(record string) (*Commit, error) {
+	fields := strings.SplitN(record, gitLogFieldSeparator, 7)
+	if len(fields) != 7 || !commitHashRE.MatchString(fields[0]) {
+		return nil, fmt.Errorf("malformed git log record: %.80q", record)
+	}
+
+	// The author's time zone is kept, as go-git does.
+	when, err := time.Parse(gitLogTimeLayout, fields[4])
+	if err != nil {
+		return nil, fmt.Errorf("malformed commit time %q: %w", fields[4], err)
+	}
+	_, offset := when.Zone()
+
+	commit := &Commit{
+		Hash:    fields[0],
+		Parents: strings.Fields(fields[1]),
+		Author: object.Signature{
+			Name:  fields[2],
+			Email: fields[3],
+			When:  time.Unix(when.Unix(), 0).In(time.FixedZone("", offset)),
+		},
+		Message: fields[5],
+	}
+
+	// Keep parity with the go-git walker, which does not diff root commits.
+	if len(commit.Parents) == 0 {
+		return commit, nil
+	}
+
+	files, diff := splitNumstat(strings.TrimLeft(fields[6], "\n"))
+	commit.Files = files
+	if len(diff) > maxDiffLength {
+		diff = diff[:maxDiffLength]
+	}
+	commit.Diff = diff
+
+	return commit, nil
+}
+
+// splitNumstat separates the leading numstat block from the patch that follows it.
+func splitNumstat(output string) (object.FileStats, string) {
+	var files object.FileStats
+
+	for output != "" {
+		line := output
+		rest := ""
+		if i := strings.IndexByte(output, '\n'); i >= 0 {
+			line = output[:i]
+			rest = output[i+1:]
+		}
+
+		if line == "" {
+			return files, rest
+		}
+
+		parts := strings.SplitN(line, "\t", 3)
+		if len(parts) != 3 {
+			return files, output
+		}
+
+		// Binary files report "-" for both counts.
+		additions, _ := strconv.Atoi(parts[0])
+		deletions, _ := strconv.Atoi(parts[1])
+		files = append(files, object.FileStat{
+			Name:     parts[2],
+			Addition: additions,
+			Deletion: deletions,
+		})
+		output = rest
+	}
+
+	return files, ""
+}
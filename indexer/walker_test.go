@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/require"
+)
+
+func createTestRepo(tb testing.TB, commits int) string {
+	tb.Helper()
+
+	dir := tb.TempDir()
+	run := func(args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=Jane Doe", "GIT_AUTHOR_EMAIL=jane@example.com",
+			"GIT_COMMITTER_NAME=Jane Doe", "GIT_COMMITTER_EMAIL=jane@example.com",
+			// A zone other than the local one, so walkers must keep it.
+			"TZ=Asia/Kolkata")
+		output, err := cmd.CombinedOutput()
+		require.NoError(tb, err, string(output))
+	}
+
+	run("init", "-q", "-b", "main")
+	for i := 0; i < commits; i++ {
+		file := filepath.Join(dir, fmt.Sprintf("docs/article-%d.md", i%10))
+		require.NoError(tb, os.MkdirAll(filepath.Dir(file), 0755))
+		content := strings.Repeat(fmt.Sprintf("line %d of commit %d\n", i, i), 20)
+		require.NoError(tb, os.WriteFile(file, []byte(content), 0644))
+		run("add", "-A")
+		run("commit", "-q", "-m", fmt.Sprintf("Update article %d\n\nCommit number %d", i%10, i))
+	}
+
+	return dir
+}
+
+func walkAll(tb testing.TB, walkerName, dir string) []*Commit {
+	tb.Helper()
+
+	r, err := git.PlainOpen(dir)
+	require.NoError(tb, err)
+	head, err := r.Head()
+	require.NoError(tb, err)
+
+	walker, err := newCommitWalker(walkerName, r, dir)
+	require.NoError(tb, err)
+
+	var commits []*Commit
+	err = walker.Walk(context.Background(), head.Hash(), plumbing.ZeroHash, func(commit *Commit) error {
+		if err := commit.resolveDiff(); err != nil {
+			return err
+		}
+		commits = append(commits, commit)
+		return nil
+	})
+	require.NoError(tb, err)
+	return commits
+}
+
+func TestWalkersProduceSameCommits(t *testing.T) {
+	dir := createTestRepo(t, 15)
+
+	goGitCommits := walkAll(t, goGitWalker, dir)
+	gitLogCommits := walkAll(t, gitLogWalker, dir)
+
+	require.Len(t, gitLogCommits, len(goGitCommits))
+	for i := range goGitCommits {
+		expected, actual := goGitCommits[i], gitLogCommits[i]
+		require.Equal(t, expected.Hash, actual.Hash)
+		require.Equal(t, expected.Parents, actual.Parents)
+		require.Equal(t, expected.Author.Name, actual.Author.Name)
+		require.Equal(t, expected.Author.Email, actual.Author.Email)
+		require.Equal(t, expected.Author.When.Unix(), actual.Author.When.Unix())
+		require.Equal(t, expected.Author.When.Format(time.RFC3339), actual.Author.When.Format(time.RFC3339))
+		require.Equal(t, expected.Message, actual.Message)
+		require.Equal(t, expected.Files, actual.Files)
+		require.Equal(t, expected.Diff == "", actual.Diff == "")
+	}
+}
+
+func TestWalkReportsMissingCommits(t *testing.T) {
+	dir := createTestRepo(t, 3)
+
+	r, err := git.PlainOpen(dir)
+	require.NoError(t, err)
+	head, err := r.Head()
+	require.NoError(t, err)
+	commit, err := r.CommitObject(head.Hash())
+	require.NoError(t, err)
+
+	// Remove the parent of the head, as in a corrupt or shallow clone.
+	parent := commit.ParentHashes[0].String()
+	require.NoError(t, os.Remove(filepath.Join(dir, ".git", "objects", parent[:2], parent[2:])))
+
+	walker, err := newCommitWalker(goGitWalker, r, dir)
+	require.NoError(t, err)
+
+	walked := 0
+	err = walker.Walk(context.Background(), head.Hash(), plumbing.ZeroHash, func(*Commit) error {
+		walked++
+		return nil
+	})
+	require.Error(t, err)
+	require.Equal(t, 1, walked)
+}
+
+func TestWalkEndsAtShallowBoundary(t *testing.T) {
+	source := createTestRepo(t, 5)
+	dir := filepath.Join(t.TempDir(), "clone")
+	output, err := exec.Command("git", "clone", "-q", "--depth", "2", "file://"+source, dir).CombinedOutput()
+	require.NoError(t, err, string(output))
+
+	r, err := git.PlainOpen(dir)
+	require.NoError(t, err)
+	head, err := r.Head()
+	require.NoError(t, err)
+	walker, err := newCommitWalker(goGitWalker, r, dir)
+	require.NoError(t, err)
+
+	walked := 0
+	err = walker.Walk(context.Background(), head.Hash(), plumbing.ZeroHash, func(*Commit) error {
+		walked++
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, walked)
+}
+
+func TestParseGitLogRecord(t *testing.T) {
+	hash, parent := strings.Repeat("a", 40), strings.Repeat("b", 40)
+	record := hash + "\x00" + parent + "\x00Jane Doe\x00jane@example.com\x002023-11-14 23:13:20 +0100\x00Fix typo\n\x00\n\n3\t1\tdocs/a.md\n-\t-\tlogo.png\n\ndiff --git a/docs/a.md b/docs/a.md\n+new\n"
+
+	commit, err := parseGitLogRecord(record)
+	require.NoError(t, err)
+	require.Equal(t, hash, commit.Hash)
+	require.Equal(t, []string{parent}, commit.Parents)
+	require.Equal(t, "Jane Doe", commit.Author.Name)
+	require.Equal(t, int64(1700000000), commit.Author.When.Unix())
+	_, offset := commit.Author.When.Zone()
+	require.Equal(t, 3600, offset)
+	require.Equal(t, "Fix typo\n", commit.Message)
+	require.Len(t, commit.Files, 2)
+	require.Equal(t, "docs/a.md", commit.Files[0].Name)
+	require.Equal(t, 3, commit.Files[0].Addition)
+	require.Equal(t, 0, commit.Files[1].Addition)
+	require.Equal(t, "diff --git a/docs/a.md b/docs/a.md\n+new\n", commit.Diff)
+
+	_, err = parseGitLogRecord("not a hash" + record[40:])
+	require.Error(t, err)
+}
+
+func TestParseGitLogIgnoresSeparatorsInCommits(t *testing.T) {
+	boundary := newGitLogBoundary()
+	record := func(hash, message string) string {
+		return string(boundary) + hash + "\x00\x00Jane Doe\x00jane@example.com\x002023-11-14 23:13:20 +0100\x00" + message + "\x00"
+	}
+	output := record(strings.Repeat("a", 40), "Split\x1ehere\n") + record(strings.Repeat("b", 40), "Second\n")
+
+	var messages []string
+	err := parseGitLog(strings.NewReader(output), boundary, func(commit *Commit) error {
+		messages = append(messages, commit.Message)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"Split\x1ehere\n", "Second\n"}, messages)
+}
+
+func BenchmarkCommitWalkers(b *testing.B) {
+	dir := createTestRepo(b, 200)
+
+	for _, walkerName := range []string{goGitWalker, gitLogWalker} {
+		b.Run(walkerName, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				walkAll(b, walkerName, dir)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	servicebus "github.com/Azure/azure-service-bus-go"
+)
+
+// errShuttingDown pauses a job when the worker is asked to shut down. The
+// job stores its checkpoint and its message is abandoned, so the next
+// worker resumes where it stopped.
+var errShuttingDown = errors.New("worker is shutting down")
+
+const lockRenewalTimeout = 30 * time.Second
+
+// worker receives jobs from the queue until it gets SIGTERM or a receiver
+// fails. worker.concurrency sets how many repositories it indexes at once.
+type worker struct {
+	queue       *servicebus.Queue
+	handler     *MessageHandler
+	concurrency int
+
+	ready  int32
+	failed int32
+}
+
+func newWorker(queue *servicebus.Queue, handler *MessageHandler) *worker {
+	return &worker{
+		queue:       queue,
+		handler:     handler,
+		concurrency: config.Worker.Concurrency,
+	}
+}
+
+// run listens with one receiver per concurrent job. On shutdown it stops
+// receiving and waits for the running jobs to finish or checkpoint.
+func (w *worker) run() error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(signals)
+
+	ctx, stopReceiving := context.WithCancel(context.Background())
+	defer stopReceiving()
+
+	stopped := make(chan error, w.concurrency)
+	listeners := make([]*servicebus.ListenerHandle, 0, w.concurrency)
+	defer func() {
+		for _, listener := range listeners {
+			listener.Close(context.Background())
+		}
+	}()
+
+	for i := 0; i < w.concurrency; i++ {
+		receiver, err := w.queue.NewReceiver(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to create receiver: %w", err)
+		}
+
+		listener := receiver.Listen(ctx, w.handler)
+		listeners = append(listeners, listener)
+		go func() {
+			<-listener.Done()
+			stopped <- listener.Err()
+		}()
+	}
+
+	atomic.StoreInt32(&w.ready, 1)
+	logger.Infow("Receiving jobs", "concurrency", w.concurrency)
+
+	var err error
+	select {
+	case sig := <-signals:
+		logger.Infow("Shutting down", "signal", sig.String())
+	case err = <-stopped:
+		atomic.StoreInt32(&w.failed, 1)
+		err = fmt.Errorf("receiver stopped: %w", err)
+	}
+
+	atomic.StoreInt32(&w.ready, 0)
+	stopReceiving()
+	w.handler.drain()
+	logger.Info("Running jobs stopped")
+	return err
+}
+
+// liveness fails once a receiver stopped, so the worker gets restarted.
+func (w *worker) liveness(rw http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&w.failed) == 1 {
+		http.Error(rw, "receiver stopped", http.StatusServiceUnavailable)
+		return
+	}
+	rw.WriteHeader(http.StatusOK)
+}
+
+// readiness succeeds while the worker receives jobs.
+func (w *worker) readiness(rw http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&w.ready) == 0 {
+		http.Error(rw, "not receiving", http.StatusServiceUnavailable)
+		return
+	}
+	rw.WriteHeader(http.StatusOK)
+}
+
+type stopKey struct{}
+
+// withStop attaches the shutdown signal of the worker to the context of a job.
+func withStop(ctx context.Context, stop <-chan struct{}) context.Context {
+	return context.WithValue(ctx, stopKey{}, stop)
+}
+
+// stopRequested reports whether the job running in ctx should checkpoint
+// and stop.
+func stopRequested(ctx context.Context) bool {
+	stop, ok := ctx.Value(stopKey{}).(<-chan struct{})
+	if !ok {
+		return false
+	}
+
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
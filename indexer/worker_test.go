@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	servicebus "github.com/Azure/azure-service-bus-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShutdownCheckpointsJob(t *testing.T) {
+	source := createTestRepo(t, 5)
+	remote := filepath.Join(t.TempDir(), "sample.git")
+	output, err := exec.Command("git", "clone", "-q", "--bare", source, remote).CombinedOutput()
+	require.NoError(t, err, string(output))
+
+	head, err := exec.Command("git", "-C", source, "rev-parse", "HEAD").Output()
+	require.NoError(t, err)
+
+	useConfig(t, func(c *Config) { c.Git.TempFolder = t.TempDir() })
+
+	stop := make(chan struct{})
+	close(stop)
+
+	job := newDryRunJob(Repository{URL: "file://" + remote})
+	err = processRepository(withStop(context.Background(), stop), job, "")
+	require.ErrorIs(t, err, errShuttingDown)
+	require.Equal(t, strings.TrimSpace(string(head)), job.checkpoint())
+	require.Zero(t, job.report().Commits)
+}
+
+func TestShutdownDuringDocumentsCheckpointsJob(t *testing.T) {
+	source := createTestRepo(t, 5)
+	remote := filepath.Join(t.TempDir(), "sample.git")
+	output, err := exec.Command("git", "clone", "-q", "--bare", source, remote).CombinedOutput()
+	require.NoError(t, err, string(output))
+
+	useConfig(t, func(c *Config) { c.Git.TempFolder = t.TempDir() })
+
+	stop := make(chan struct{})
+	close(stop)
+
+	job := newDryRunJob(Repository{URL: "file://" + remote})
+	err = processRepository(withStop(context.Background(), stop), job, checkpointDocuments)
+	require.ErrorIs(t, err, errShuttingDown)
+	require.Equal(t, checkpointDocuments, job.checkpoint())
+	require.Zero(t, job.report().Documents)
+}
+
+type countingRenewer struct {
+	renewals int32
+}
+
+func (r *countingRenewer) RenewLocks(ctx context.Context, messages ...*servicebus.Message) error {
+	atomic.AddInt32(&r.renewals, 1)
+	return nil
+}
+
+func TestRenewLockUntilStopped(t *testing.T) {
+	renewer := &countingRenewer{}
+	handler := &MessageHandler{locks: renewer, lockRenewal: 5 * time.Millisecond}
+
+	stopRenewal := handler.renewLock(context.Background(), &servicebus.Message{})
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&renewer.renewals) >= 2 }, time.Second, time.Millisecond)
+	stopRenewal()
+
+	renewals := atomic.LoadInt32(&renewer.renewals)
+	time.Sleep(20 * time.Millisecond)
+	require.Equal(t, renewals, atomic.LoadInt32(&renewer.renewals))
+}
+
+func TestDrainStopsAcceptingJobs(t *testing.T) {
+	handler := NewMessageHandler(nil, nil, nil, nil, nil)
+	require.True(t, handler.begin())
+
+	drained := make(chan struct{})
+	go func() {
+		handler.drain()
+		close(drained)
+	}()
+
+	require.Eventually(t, func() bool { return stopRequested(withStop(context.Background(), handler.stop)) }, time.Second, time.Millisecond)
+	require.False(t, handler.begin())
+
+	handler.active.Done()
+	<-drained
+}
+
+// recordingSender keeps the messages sent to it.
+type recordingSender struct {
+	messages []*servicebus.Message
+}
+
+func (s *recordingSender) Send(ctx context.Context, msg *servicebus.Message) error {
+	s.messages = append(s.messages, msg)
+	return nil
+}
+
+func TestRequeueKeepsJob(t *testing.T) {
+	sender := &recordingSender{}
+	handler := NewMessageHandler(nil, nil, nil, nil, sender)
+
+	msg := servicebus.NewMessageFromString("repo")
+	msg.UserProperties = map[string]interface{}{modeProperty: modeIncremental, correlationIDProperty: "abc"}
+	require.NoError(t, handler.requeue(context.Background(), msg))
+
+	require.Len(t, sender.messages, 1)
+	require.Equal(t, []byte("repo"), sender.messages[0].Data)
+	require.Equal(t, msg.UserProperties, sender.messages[0].UserProperties)
+
+	require.Error(t, NewMessageHandler(nil, nil, nil, nil, nil).requeue(context.Background(), msg))
+}
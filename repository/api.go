@@ -6,9 +6,10 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"io/ioutil"
-	"log"
 	"net/http"
+	"regexp"
 	"strings"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 )
@@ -17,7 +18,59 @@ type Repository struct {
 	ID             string `json:"id,omitempty" bson:"_id,omitempty"`
 	Name           string `json:"name"`
 	URL            string `json:"url"`
-	IndexingStatus string `json:"indexing_status"`
+	IndexingStatus string `json:"indexing_status" bson:"status"`
+	Walker         string `json:"walker,omitempty" bson:"walker,omitempty"`
+
+	MonthlyTokenBudget  int64          `json:"monthly_token_budget,omitempty" bson:"monthly_token_budget,omitempty"`
+	ScrubPatterns       []ScrubPattern `json:"scrub_patterns,omitempty" bson:"scrub_patterns,omitempty"`
+	Privacy             *PrivacyPolicy `json:"privacy,omitempty" bson:"privacy,omitempty"`
+	Summaries           bool           `json:"summaries,omitempty" bson:"summaries,omitempty"`
+	DocumentGlobs       []string       `json:"document_globs,omitempty" bson:"document_globs,omitempty"`
+	SyncIntervalMinutes int            `json:"sync_interval_minutes,omitempty" bson:"sync_interval_minutes,omitempty"`
+
+	Checkpoint  string                `json:"checkpoint,omitempty" bson:"checkpoint,omitempty"`
+	LastJob     *JobReport            `json:"last_job,omitempty" bson:"last_job,omitempty"`
+	Estimate    *JobReport            `json:"estimate,omitempty" bson:"estimate,omitempty"`
+	CodeOwners  map[string]CodeOwners `json:"code_owners,omitempty" bson:"code_owners,omitempty"`
+	Heads       map[string]string     `json:"heads,omitempty" bson:"heads,omitempty"`
+	PendingPush *PendingPush          `json:"pending_push,omitempty" bson:"pending_push,omitempty"`
+	NextSync    *time.Time            `json:"next_sync,omitempty" bson:"next_sync,omitempty"`
+}
+
+// CodeOwners are the rules of a branch's CODEOWNERS file, as found by the indexer.
+type CodeOwners struct {
+	Path  string `json:"path" bson:"path"`
+	Rules []struct {
+		Pattern string   `json:"pattern" bson:"pattern"`
+		Owners  []string `json:"owners" bson:"owners"`
+	} `json:"rules" bson:"rules"`
+}
+
+// JobReport is written by the indexer after an indexing job or a dry-run.
+type JobReport struct {
+	DryRun        bool    `json:"dry_run" bson:"dry_run"`
+	Commits       int64   `json:"commits" bson:"commits"`
+	Failed        int64   `json:"failed" bson:"failed"`
+	Chunks        int64   `json:"chunks" bson:"chunks"`
+	Tokens        int64   `json:"tokens" bson:"tokens"`
+	Model         string  `json:"model" bson:"model"`
+	EstimatedCost float64 `json:"estimated_cost_usd" bson:"estimated_cost_usd"`
+
+	Summaries     int64  `json:"summaries,omitempty" bson:"summaries,omitempty"`
+	SummaryTokens int64  `json:"summary_tokens,omitempty" bson:"summary_tokens,omitempty"`
+	SummaryModel  string `json:"summary_model,omitempty" bson:"summary_model,omitempty"`
+
+	Documents       int64 `json:"documents,omitempty" bson:"documents,omitempty"`
+	FailedDocuments int64 `json:"failed_documents,omitempty" bson:"failed_documents,omitempty"`
+
+	Redactions map[string]int64 `json:"redactions,omitempty" bson:"redactions,omitempty"`
+}
+
+// ScrubPattern is a repository specific regular expression for secrets the
+// indexer redacts before embedding.
+type ScrubPattern struct {
+	Name    string `json:"name" bson:"name"`
+	Pattern string `json:"pattern" bson:"pattern"`
 }
 
 func repositoryHandler(w http.ResponseWriter, r *http.Request) {
@@ -26,6 +79,8 @@ func repositoryHandler(w http.ResponseWriter, r *http.Request) {
 		listRepositories(w, r)
 	case "PUT":
 		addRepository(w, r)
+	case "DELETE":
+		deleteRepository(w, r)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
@@ -37,7 +92,7 @@ func listRepositories(w http.ResponseWriter, r *http.Request) {
 	cursor, err := repoCol.Find(ctx, bson.M{})
 	if err != nil {
 		http.Error(w, "Error fetching repositories", http.StatusInternalServerError)
-		log.Printf("Error fetching repositories: %v", err)
+		logger.Errorw("Error fetching repositories", "error", err)
 		return
 	}
 
@@ -56,6 +111,30 @@ func generateSHAHashFromURL(url string) string {
 	return hex.EncodeToString(hash[:])
 }
 
+// PrivacyPolicy decides how contributors of a repository are identified:
+// by "email", by "name" only, by an internal directory "handle" or by a "pseudonym".
+type PrivacyPolicy struct {
+	Mode    string            `json:"mode" bson:"mode"`
+	Handles map[string]string `json:"handles,omitempty" bson:"handles,omitempty"`
+}
+
+func isValidPrivacyPolicy(policy *PrivacyPolicy) bool {
+	if policy == nil {
+		return true
+	}
+
+	switch policy.Mode {
+	case "", "email", "name", "handle", "pseudonym":
+		return true
+	default:
+		return false
+	}
+}
+
+func isValidWalker(walker string) bool {
+	return walker == "" || walker == "go-git" || walker == "git-log"
+}
+
 func addRepository(w http.ResponseWriter, r *http.Request) {
 	body, err := ioutil.ReadAll(r.Body)
 
@@ -72,6 +151,40 @@ func addRepository(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !isValidWalker(repo.Walker) {
+		http.Error(w, "Unknown walker, expected \"go-git\" or \"git-log\"", http.StatusBadRequest)
+		return
+	}
+
+	if !isValidPrivacyPolicy(repo.Privacy) {
+		http.Error(w, "Unknown privacy mode, expected \"email\", \"name\", \"handle\" or \"pseudonym\"", http.StatusBadRequest)
+		return
+	}
+
+	if repo.Privacy != nil && repo.Privacy.Mode == "pseudonym" && config.Privacy.PseudonymSecret == "" {
+		http.Error(w, "The pseudonym privacy mode requires PSEUDONYM_SECRET to be set", http.StatusBadRequest)
+		return
+	}
+
+	for _, pattern := range repo.ScrubPatterns {
+		if _, err := regexp.Compile(pattern.Pattern); err != nil {
+			http.Error(w, "Invalid scrub pattern "+pattern.Name+": "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if repo.SyncIntervalMinutes < 0 {
+		http.Error(w, "Sync interval must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	for _, glob := range repo.DocumentGlobs {
+		if strings.TrimSpace(glob) == "" {
+			http.Error(w, "Document globs must not be empty", http.StatusBadRequest)
+			return
+		}
+	}
+
 	repo.IndexingStatus = "pending"
 
 	ctx := context.Background()
@@ -87,12 +200,87 @@ func addRepository(w http.ResponseWriter, r *http.Request) {
 	}
 
 	repo.ID = insertResult.InsertedID.(string)
-	if err := sendMessageToServiceBus(repo.ID); err != nil {
+	correlationID := newCorrelationID()
+	log := logger.With(correlationIDProperty, correlationID, "repo_id", repo.ID)
+	if err := sendMessageToServiceBus(ctx, repo.ID, correlationID, nil); err != nil {
 		http.Error(w, "Error sending message to service bus", http.StatusInternalServerError)
+		log.Errorw("Error queueing indexing job", "error", err)
 		return
 	}
+	log.Infow("Queued indexing job", "url", repo.URL)
 
+	w.Header().Set(correlationIDHeader, correlationID)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(repo)
 }
+
+func estimateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	repoID := r.URL.Query().Get("id")
+	if repoID == "" {
+		http.Error(w, "Missing repository id", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	count, err := repoCol.CountDocuments(ctx, bson.M{"_id": repoID})
+	if err != nil {
+		http.Error(w, "Error fetching repository", http.StatusInternalServerError)
+		return
+	}
+	if count == 0 {
+		http.Error(w, "Repository not found", http.StatusNotFound)
+		return
+	}
+
+	correlationID := newCorrelationID()
+	log := logger.With(correlationIDProperty, correlationID, "repo_id", repoID)
+	if err := sendMessageToServiceBus(ctx, repoID, correlationID, map[string]interface{}{"mode": "dry-run"}); err != nil {
+		http.Error(w, "Error sending message to service bus", http.StatusInternalServerError)
+		log.Errorw("Error queueing dry-run job", "error", err)
+		return
+	}
+	log.Info("Queued dry-run job")
+
+	w.Header().Set(correlationIDHeader, correlationID)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// deleteRepository marks the repository as deleting and asks the indexer to
+// purge its vectors, ledger entries and profile contributions. The indexer
+// removes the repository itself once the purge completed.
+func deleteRepository(w http.ResponseWriter, r *http.Request) {
+	repoID := r.URL.Query().Get("id")
+	if repoID == "" {
+		http.Error(w, "Missing repository id", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	result, err := repoCol.UpdateOne(ctx, bson.M{"_id": repoID}, bson.M{"$set": bson.M{"status": "deleting"}})
+	if err != nil {
+		http.Error(w, "Error updating repository", http.StatusInternalServerError)
+		return
+	}
+	if result.MatchedCount == 0 {
+		http.Error(w, "Repository not found", http.StatusNotFound)
+		return
+	}
+
+	correlationID := newCorrelationID()
+	log := logger.With(correlationIDProperty, correlationID, "repo_id", repoID)
+	if err := sendMessageToServiceBus(ctx, repoID, correlationID, map[string]interface{}{"mode": "purge"}); err != nil {
+		http.Error(w, "Error sending message to service bus", http.StatusInternalServerError)
+		log.Errorw("Error queueing purge job", "error", err)
+		return
+	}
+	log.Info("Queued purge job")
+
+	w.Header().Set(correlationIDHeader, correlationID)
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -58,3 +58,18 @@ func TestAddRepository(t *testing.T) {
 	assert.Equal(t, repo.IndexingStatus, createdRepo.IndexingStatus, "Repository indexing status should match")
 }
 
+func TestPseudonymModeRequiresSecret(t *testing.T) {
+	repo := Repository{
+		Name:    "Test Repo",
+		URL:     "https://github.com/example/test-repo.git",
+		Privacy: &PrivacyPolicy{Mode: "pseudonym"},
+	}
+	repoJSON, _ := json.Marshal(repo)
+
+	req, _ := http.NewRequest("PUT", "/api/repository", bytes.NewBuffer(repoJSON))
+	rr := httptest.NewRecorder()
+	repositoryHandler(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "Status code should be 400")
+	assert.Contains(t, rr.Body.String(), "PSEUDONYM_SECRET")
+}
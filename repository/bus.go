@@ -3,35 +3,58 @@ package main
 import (
 	"context"
 	"github.com/Azure/azure-service-bus-go"
-	"log"
-	"os"
-)
+	"time"
 
-var (
-	serviceBusConnString string
-	queueName            string
-	topicClient          *servicebus.Topic
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func initServiceBus() {
-	var err error
-	serviceBusConnString = os.Getenv("AZURE_SERVICE_BUS_CONNECTION_STRING")
-	queueName = os.Getenv("QUEUE_NAME")
+var topicClient *servicebus.Topic
 
-	ns, err := servicebus.NewNamespace(servicebus.NamespaceWithConnectionString(serviceBusConnString))
+func initServiceBus() {
+	ns, err := servicebus.NewNamespace(servicebus.NamespaceWithConnectionString(config.ServiceBus.ConnectionString))
 	if err != nil {
-		log.Fatal(err)
+		logger.Fatalw("Error connecting to service bus", "error", err)
 	}
 
-	topicClient, err = ns.NewTopic(queueName)
+	topicClient, err = ns.NewTopic(config.ServiceBus.QueueName)
 	if err != nil {
-		log.Fatal(err)
+		logger.Fatalw("Error opening service bus topic", "error", err)
 	}
 }
 
-func sendMessageToServiceBus(repoID string) error {
-	ctx := context.Background()
-	msg := servicebus.NewMessageFromString(repoID)
-	return topicClient.Send(ctx, msg)
+func sendMessageToServiceBus(ctx context.Context, repoID, correlationID string, properties map[string]interface{}) error {
+	ctx, msg, span := newJobMessage(ctx, repoID, correlationID, properties)
+	err := recordPublish(properties, topicClient.Send(ctx, msg))
+	endSpan(span, err)
+	return err
+}
+
+// scheduleMessageToServiceBus sends a message that is only delivered at the given time.
+func scheduleMessageToServiceBus(ctx context.Context, repoID, correlationID string, properties map[string]interface{}, at time.Time) error {
+	ctx, msg, span := newJobMessage(ctx, repoID, correlationID, properties)
+	msg.ScheduleAt(at)
+	err := recordPublish(properties, topicClient.Send(ctx, msg))
+	endSpan(span, err)
+	return err
 }
 
+// newJobMessage builds the message of an indexing job. Its properties carry
+// the correlation ID and the trace context of the publish span.
+func newJobMessage(ctx context.Context, repoID, correlationID string, properties map[string]interface{}) (context.Context, *servicebus.Message, trace.Span) {
+	userProperties := map[string]interface{}{correlationIDProperty: correlationID}
+	for key, value := range properties {
+		userProperties[key] = value
+	}
+
+	ctx, span := tracer.Start(ctx, "servicebus.publish", trace.WithSpanKind(trace.SpanKindProducer), trace.WithAttributes(
+		attribute.String("repo.id", repoID),
+		attribute.String("correlation.id", correlationID),
+	))
+	otel.GetTextMapPropagator().Inject(ctx, messageCarrier(userProperties))
+
+	msg := servicebus.NewMessageFromString(repoID)
+	msg.UserProperties = userProperties
+	return ctx, msg, span
+}
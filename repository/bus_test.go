@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestJobMessageCarriesCorrelationAndTraceContext(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	otel.SetTracerProvider(sdktrace.NewTracerProvider())
+
+	properties := map[string]interface{}{"mode": "purge"}
+	_, msg, span := newJobMessage(context.Background(), "repo", "abc123", properties)
+	span.End()
+
+	assert.Equal(t, "repo", string(msg.Data))
+	assert.Equal(t, "purge", msg.UserProperties["mode"])
+	assert.Equal(t, "abc123", msg.UserProperties[correlationIDProperty])
+	assert.Contains(t, msg.UserProperties["traceparent"], span.SpanContext().TraceID().String())
+	assert.Len(t, properties, 1)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v3"
+)
+
+// configFileEnv names the YAML file the configuration is read from. Without
+// it the configuration comes from the defaults and the environment.
+const configFileEnv = "CONFIG_FILE"
+
+const redacted = "<redacted>"
+
+// config is the configuration of the repository service, loaded at startup.
+var config = defaultConfig()
+
+// Config is the configuration of the repository service. Every field can be
+// set in the YAML file and overridden by the environment variable in its env
+// tag. Fields tagged secret are redacted when the configuration is printed.
+type Config struct {
+	LogLevel   string           `yaml:"log_level" env:"LOG_LEVEL"`
+	Tracing    TracingConfig    `yaml:"tracing"`
+	Server     ServerConfig     `yaml:"server"`
+	Database   DatabaseConfig   `yaml:"database"`
+	ServiceBus ServiceBusConfig `yaml:"service_bus"`
+	Webhooks   WebhooksConfig   `yaml:"webhooks"`
+	Scheduler  SchedulerConfig  `yaml:"scheduler"`
+	Privacy    PrivacyConfig    `yaml:"privacy"`
+}
+
+type TracingConfig struct {
+	// OTLPEndpoint is the base URL spans are exported to over OTLP/HTTP.
+	OTLPEndpoint string `yaml:"otlp_endpoint" env:"OTEL_EXPORTER_OTLP_ENDPOINT"`
+}
+
+type ServerConfig struct {
+	Port string `yaml:"port" env:"PORT"`
+}
+
+type DatabaseConfig struct {
+	ConnectionString string `yaml:"connection_string" env:"COSMOS_DB_CONNECTION_STRING" secret:"true"`
+}
+
+type ServiceBusConfig struct {
+	ConnectionString string `yaml:"connection_string" env:"AZURE_SERVICE_BUS_CONNECTION_STRING" secret:"true"`
+	// QueueName is the topic indexing jobs are published to.
+	QueueName string `yaml:"queue_name" env:"QUEUE_NAME"`
+}
+
+// WebhooksConfig authenticates the webhooks of each Git host. A host's
+// webhook rejects every request until its secret is set.
+type WebhooksConfig struct {
+	GitHubSecret        string `yaml:"github_secret" env:"GITHUB_WEBHOOK_SECRET" secret:"true"`
+	GitLabToken         string `yaml:"gitlab_token" env:"GITLAB_WEBHOOK_TOKEN" secret:"true"`
+	AzureDevOpsUsername string `yaml:"azure_devops_username" env:"AZURE_DEVOPS_WEBHOOK_USERNAME"`
+	AzureDevOpsPassword string `yaml:"azure_devops_password" env:"AZURE_DEVOPS_WEBHOOK_PASSWORD" secret:"true"`
+	// DebounceSeconds delays the job of a push so later pushes join it.
+	DebounceSeconds int `yaml:"debounce_seconds" env:"WEBHOOK_DEBOUNCE_SECONDS"`
+}
+
+type SchedulerConfig struct {
+	TickSeconds int `yaml:"tick_seconds" env:"SYNC_TICK_SECONDS"`
+	// IntervalMinutes applies to repositories without their own interval,
+	// 0 to sync only those.
+	IntervalMinutes int `yaml:"interval_minutes" env:"SYNC_INTERVAL_MINUTES"`
+}
+
+// PrivacyConfig holds the secret the indexer and the chat service key the
+// pseudonyms of contributors with. The service only checks that it is set:
+// repositories cannot use the pseudonym privacy mode without it.
+type PrivacyConfig struct {
+	PseudonymSecret string `yaml:"pseudonym_secret" env:"PSEUDONYM_SECRET" secret:"true"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		LogLevel:  "info",
+		Server:    ServerConfig{Port: "8081"},
+		Webhooks:  WebhooksConfig{DebounceSeconds: 60},
+		Scheduler: SchedulerConfig{TickSeconds: 60},
+	}
+}
+
+// loadConfig reads the defaults, then the file at CONFIG_FILE, then the
+// environment. Unknown keys in the file are errors, so typos do not go
+// unnoticed.
+func loadConfig() (Config, error) {
+	c := defaultConfig()
+	if path := os.Getenv(configFileEnv); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return c, fmt.Errorf("failed to read config file: %w", err)
+		}
+
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&c); err != nil && err != io.EOF {
+			return c, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	}
+
+	return c, applyEnv(reflect.ValueOf(&c).Elem())
+}
+
+// applyEnv overrides fields by the environment variables in their env tags.
+// Empty variables are ignored.
+func applyEnv(v reflect.Value) error {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			if err := applyEnv(field); err != nil {
+				return err
+			}
+			continue
+		}
+
+		name := v.Type().Field(i).Tag.Get("env")
+		value := os.Getenv(name)
+		if name == "" || value == "" {
+			continue
+		}
+
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(value)
+		case reflect.Int:
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("%s must be an integer, got %q", name, value)
+			}
+			field.SetInt(n)
+		}
+	}
+	return nil
+}
+
+// validate reports every invalid setting at once.
+func (c Config) validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.Database.ConnectionString != "", "database.connection_string (COSMOS_DB_CONNECTION_STRING) is required")
+	check(c.ServiceBus.ConnectionString != "", "service_bus.connection_string (AZURE_SERVICE_BUS_CONNECTION_STRING) is required")
+	check(c.ServiceBus.QueueName != "", "service_bus.queue_name (QUEUE_NAME) is required")
+	check(c.Webhooks.DebounceSeconds >= 0, "webhooks.debounce_seconds (WEBHOOK_DEBOUNCE_SECONDS) must not be negative, got %d", c.Webhooks.DebounceSeconds)
+	check(c.Webhooks.AzureDevOpsPassword == "" || c.Webhooks.AzureDevOpsUsername != "",
+		"webhooks.azure_devops_username (AZURE_DEVOPS_WEBHOOK_USERNAME) is required with a password")
+	check(c.Scheduler.TickSeconds > 0, "scheduler.tick_seconds (SYNC_TICK_SECONDS) must be positive, got %d", c.Scheduler.TickSeconds)
+	check(c.Scheduler.IntervalMinutes >= 0, "scheduler.interval_minutes (SYNC_INTERVAL_MINUTES) must not be negative, got %d", c.Scheduler.IntervalMinutes)
+
+	_, err := zapcore.ParseLevel(c.LogLevel)
+	check(err == nil, "log_level (LOG_LEVEL) must be debug, info, warn or error, got %q", c.LogLevel)
+	check(c.Tracing.OTLPEndpoint == "" || validURL(c.Tracing.OTLPEndpoint),
+		"tracing.otlp_endpoint (OTEL_EXPORTER_OTLP_ENDPOINT) must be an http or https URL, got %q", c.Tracing.OTLPEndpoint)
+	port, err := strconv.Atoi(c.Server.Port)
+	check(err == nil && port > 0 && port < 65536, "server.port (PORT) must be a port number, got %q", c.Server.Port)
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+func validURL(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// redacted returns a copy of the configuration with the secrets that are
+// set replaced.
+func (c Config) redacted() Config {
+	redactSecrets(reflect.ValueOf(&c).Elem())
+	return c
+}
+
+func redactSecrets(v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			redactSecrets(field)
+		} else if v.Type().Field(i).Tag.Get("secret") == "true" && field.String() != "" {
+			field.SetString(redacted)
+		}
+	}
+}
+
+// printConfig writes the configuration as YAML with its secrets redacted.
+func printConfig(w io.Writer, c Config) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(c.redacted()); err != nil {
+		return fmt.Errorf("failed to print config: %w", err)
+	}
+	return encoder.Close()
+}
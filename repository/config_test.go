@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// useConfig replaces the configuration with the defaults changed by modify
+// for the duration of a test.
+func useConfig(t *testing.T, modify func(c *Config)) {
+	previous := config
+	config = defaultConfig()
+	modify(&config)
+	t.Cleanup(func() { config = previous })
+}
+
+func TestConfigEnvironmentOverridesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte("server:\n  port: \"9000\"\nscheduler:\n  interval_minutes: 30\n"), 0o600))
+	t.Setenv(configFileEnv, path)
+	t.Setenv("SYNC_INTERVAL_MINUTES", "15")
+
+	c, err := loadConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, "9000", c.Server.Port)
+	assert.Equal(t, 15, c.Scheduler.IntervalMinutes)
+	assert.Equal(t, 60, c.Webhooks.DebounceSeconds)
+}
+
+func TestConfigValidation(t *testing.T) {
+	c := defaultConfig()
+	c.Scheduler.TickSeconds = 0
+	err := c.validate()
+	assert.ErrorContains(t, err, "database.connection_string (COSMOS_DB_CONNECTION_STRING) is required")
+	assert.ErrorContains(t, err, "scheduler.tick_seconds (SYNC_TICK_SECONDS) must be positive, got 0")
+}
+
+func TestConfigPrintRedactsSecrets(t *testing.T) {
+	c := defaultConfig()
+	c.Webhooks.GitHubSecret = "hook-secret"
+
+	var out bytes.Buffer
+	assert.NoError(t, printConfig(&out, c))
+	assert.NotContains(t, out.String(), "hook-secret")
+	assert.Contains(t, out.String(), "github_secret: "+redacted)
+	assert.Contains(t, out.String(), `port: "8081"`)
+}
@@ -2,8 +2,6 @@ package main
 
 import (
 	"context"
-	"log"
-	"os"
 
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -17,10 +15,9 @@ var (
 
 func initDatabase() {
 	var err error
-	connString := os.Getenv("COSMOS_DB_CONNECTION_STRING")
-	client, err = mongo.Connect(context.Background(), options.Client().ApplyURI(connString))
+	client, err = mongo.Connect(context.Background(), options.Client().ApplyURI(config.Database.ConnectionString))
 	if err != nil {
-		log.Fatal(err)
+		logger.Fatalw("Error connecting to database", "error", err)
 	}
 	repoDB = client.Database("repositoryDB")
 	repoCol = repoDB.Collection("repositories")
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// correlationIDProperty carries the ID of a job in its queue message, so the
+// indexer's log lines can be matched to the request that queued the job.
+const correlationIDProperty = "correlation_id"
+
+// correlationIDHeader returns the correlation ID of a queued job to the client.
+const correlationIDHeader = "X-Correlation-ID"
+
+// logLevel is the minimum level of the logger, set from the configuration
+// once it is loaded.
+var logLevel = zap.NewAtomicLevel()
+
+// logger writes JSON lines to stderr.
+var logger = newLogger()
+
+func newLogger() *zap.SugaredLogger {
+	zapConfig := zap.NewProductionConfig()
+	zapConfig.EncoderConfig.TimeKey = "time"
+	zapConfig.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	zapConfig.Level = logLevel
+
+	l, err := zapConfig.Build()
+	if err != nil {
+		panic(err)
+	}
+	return l.Sugar()
+}
+
+// setLogLevel sets the minimum level of the logger. Unknown levels are
+// rejected when the configuration is validated and ignored here.
+func setLogLevel(name string) {
+	if level, err := zapcore.ParseLevel(name); err == nil {
+		logLevel.SetLevel(level)
+	}
+}
+
+func newCorrelationID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -1,19 +1,74 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"net/http"
+	"os"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+const usage = `Usage:
+  repository               serve the repository API
+  repository config print  print the effective configuration, secrets redacted`
+
 func main() {
+	var err error
+	config, err = loadConfig()
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1:]); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := config.validate(); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	setLogLevel(config.LogLevel)
+	defer logger.Sync()
+
+	shutdownTracing, err := initTracing(context.Background())
+	if err != nil {
+		logger.Fatalw("Error initialising tracing", "error", err)
+	}
+	defer shutdownTracing(context.Background())
+
 	initDatabase()
 	initServiceBus()
+	startScheduler()
 
-	http.HandleFunc("/api/repository", repositoryHandler)
+	http.Handle("/api/repository", instrument("repository", repositoryHandler))
+	http.Handle("/api/repository/estimate", instrument("estimate", estimateHandler))
+	http.Handle("/api/webhook/github", instrument("webhook_github", githubWebhookHandler))
+	http.Handle("/api/webhook/gitlab", instrument("webhook_gitlab", gitlabWebhookHandler))
+	http.Handle("/api/webhook/azure-devops", instrument("webhook_azure_devops", azureDevOpsWebhookHandler))
+	http.Handle("/metrics", promhttp.Handler())
+
+	port := config.Server.Port
+	logger.Infow("Starting repository microservice", "port", port)
+	logger.Fatalw("Error serving HTTP", "error", http.ListenAndServe(":"+port, nil))
+}
 
-	port := "8081"
-	fmt.Printf("Starting repository microservice on port %s...\n", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+// runCommand runs the command given on the command line. The only one is
+// config print, which prints the configuration and then reports what is
+// wrong with it.
+func runCommand(args []string) error {
+	if len(args) != 2 || args[0] != "config" || args[1] != "print" {
+		return fmt.Errorf("unknown command %q\n%s", strings.Join(args, " "), usage)
+	}
+	if err := printConfig(os.Stdout, config); err != nil {
+		return err
+	}
+	return config.validate()
 }
 
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	apiRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "repository_api_requests_total",
+		Help: "API requests handled by the repository service, by handler, method and status code.",
+	}, []string{"handler", "method", "code"})
+
+	publishFailuresTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "repository_publish_failures_total",
+		Help: "Messages that could not be published to the indexer queue, by mode.",
+	}, []string{"mode"})
+)
+
+// instrument counts the requests of a handler.
+func instrument(name string, handler http.HandlerFunc) http.Handler {
+	return promhttp.InstrumentHandlerCounter(apiRequestsTotal.MustCurryWith(prometheus.Labels{"handler": name}), handler)
+}
+
+// recordPublish counts a failed publish and passes the error on.
+func recordPublish(properties map[string]interface{}, err error) error {
+	if err != nil {
+		mode, _ := properties["mode"].(string)
+		if mode == "" {
+			mode = "index"
+		}
+		publishFailuresTotal.WithLabelValues(mode).Inc()
+	}
+	return err
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	syncJitterFraction = 0.1
+	lsRemoteTimeout    = 30 * time.Second
+)
+
+// startScheduler periodically checks the remotes of registered repositories
+// and queues an incremental job for every repository whose branch heads
+// moved. It complements webhooks for hosts that cannot send them.
+func startScheduler() {
+	tick := time.Duration(config.Scheduler.TickSeconds) * time.Second
+	go func() {
+		for {
+			if err := syncDueRepositories(context.Background(), time.Now()); err != nil {
+				logger.Errorw("Error syncing repositories", "error", err)
+			}
+			time.Sleep(tick)
+		}
+	}()
+}
+
+// syncInterval returns how often a repository is synced. Repositories
+// without their own interval use the configured one; zero disables syncing.
+func syncInterval(repo Repository) time.Duration {
+	if repo.SyncIntervalMinutes > 0 {
+		return time.Duration(repo.SyncIntervalMinutes) * time.Minute
+	}
+	return time.Duration(config.Scheduler.IntervalMinutes) * time.Minute
+}
+
+func syncDueRepositories(ctx context.Context, now time.Time) error {
+	cursor, err := repoCol.Find(ctx, bson.M{
+		"status": bson.M{"$nin": []string{"pending", "deleting"}},
+		"$or": []bson.M{
+			{"next_sync": bson.M{"$lte": now}},
+			{"next_sync": bson.M{"$exists": false}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	var repositories []Repository
+	if err := cursor.All(ctx, &repositories); err != nil {
+		return err
+	}
+
+	for _, repo := range repositories {
+		interval := syncInterval(repo)
+		if interval <= 0 {
+			continue
+		}
+
+		// Repositories seen for the first time are spread over one interval.
+		if repo.NextSync == nil {
+			next := now.Add(time.Duration(rand.Int63n(int64(interval))))
+			repoCol.UpdateOne(ctx, bson.M{"_id": repo.ID, "next_sync": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"next_sync": next}})
+			continue
+		}
+
+		// Claiming the slot keeps other instances from syncing the same repository.
+		claim, err := repoCol.UpdateOne(ctx,
+			bson.M{"_id": repo.ID, "next_sync": repo.NextSync},
+			bson.M{"$set": bson.M{"next_sync": nextSync(now, interval)}})
+		if err != nil || claim.ModifiedCount == 0 {
+			continue
+		}
+
+		if err := syncRepository(ctx, repo); err != nil {
+			logger.Errorw("Error syncing repository", "repo_id", repo.ID, "error", err)
+		}
+	}
+
+	return nil
+}
+
+// syncRepository queues an incremental job if a remote branch head differs
+// from the head the indexer recorded. Repositories the indexer has not
+// completed yet have no heads and are left to their running job.
+func syncRepository(ctx context.Context, repo Repository) error {
+	if len(repo.Heads) == 0 {
+		return nil
+	}
+
+	heads, err := remoteHeads(ctx, repo.URL)
+	if err != nil {
+		return err
+	}
+
+	branches := movedBranches(repo.Heads, heads)
+	if len(branches) == 0 {
+		return nil
+	}
+
+	correlationID := newCorrelationID()
+	logger.Infow("Branches moved, queueing incremental job", correlationIDProperty, correlationID, "repo_id", repo.ID, "branches", branches)
+	return enqueuePush(ctx, repo.ID, correlationID, branches)
+}
+
+// remoteHeads lists the heads of the indexed branches of a remote.
+func remoteHeads(ctx context.Context, url string) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, lsRemoteTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "git", "ls-remote", "--heads", url, "refs/heads/main", "refs/heads/master")
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list remote heads: %w", err)
+	}
+
+	return parseLsRemote(string(output)), nil
+}
+
+// parseLsRemote reads "<sha>\trefs/heads/<branch>" lines into a map of branch heads.
+func parseLsRemote(output string) map[string]string {
+	heads := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || !strings.HasPrefix(fields[1], "refs/heads/") {
+			continue
+		}
+		heads[strings.TrimPrefix(fields[1], "refs/heads/")] = fields[0]
+	}
+	return heads
+}
+
+// movedBranches returns the indexed branches whose remote head differs from the recorded one.
+func movedBranches(recorded, remote map[string]string) []string {
+	var branches []string
+	for _, branch := range []string{"main", "master"} {
+		head, ok := remote[branch]
+		if ok && head != recorded[branch] {
+			branches = append(branches, branch)
+		}
+	}
+	return branches
+}
+
+// nextSync adds up to ten percent of jitter to the interval, so a large
+// fleet does not hit the Git host at the same time.
+func nextSync(now time.Time, interval time.Duration) time.Time {
+	jitter := time.Duration(rand.Int63n(int64(float64(interval)*syncJitterFraction) + 1))
+	return now.Add(interval + jitter)
+}
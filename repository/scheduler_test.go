@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLsRemote(t *testing.T) {
+	output := "998bf04f2a0e3f7c1b1d6a9c0f6e2d4b8a7c5e31\trefs/heads/main\n" +
+		"b4396191a6b0c7d3e2f1a8b9c0d1e2f3a4b5c6d7\trefs/heads/master\n"
+
+	assert.Equal(t, map[string]string{
+		"main":   "998bf04f2a0e3f7c1b1d6a9c0f6e2d4b8a7c5e31",
+		"master": "b4396191a6b0c7d3e2f1a8b9c0d1e2f3a4b5c6d7",
+	}, parseLsRemote(output))
+}
+
+func TestMovedBranches(t *testing.T) {
+	recorded := map[string]string{"main": "a", "master": "b"}
+
+	assert.Empty(t, movedBranches(recorded, map[string]string{"main": "a", "master": "b"}))
+	assert.Equal(t, []string{"main"}, movedBranches(recorded, map[string]string{"main": "c", "master": "b"}))
+	assert.Equal(t, []string{"master"}, movedBranches(map[string]string{"main": "a"}, map[string]string{"main": "a", "master": "b"}))
+}
+
+func TestNextSyncAddsBoundedJitter(t *testing.T) {
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		next := nextSync(now, time.Hour)
+		assert.False(t, next.Before(now.Add(time.Hour)))
+		assert.False(t, next.After(now.Add(66*time.Minute)))
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// pushEvent is the part of a provider's push payload the indexer needs.
+type pushEvent struct {
+	URLs     []string
+	Branches []string
+}
+
+// PendingPush collects the branches pushed to since the last incremental
+// job was scheduled. The indexer removes it when it picks up the job.
+type PendingPush struct {
+	Branches  []string `json:"branches" bson:"branches"`
+	Scheduled bool     `json:"scheduled" bson:"scheduled"`
+}
+
+func githubWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	body, ok := readWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	secret := config.Webhooks.GitHubSecret
+	if secret == "" || !validGitHubSignature(secret, body, r.Header.Get("X-Hub-Signature-256")) {
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Header.Get("X-GitHub-Event") != "push" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var payload struct {
+		Ref        string `json:"ref"`
+		Repository struct {
+			CloneURL string `json:"clone_url"`
+			HTMLURL  string `json:"html_url"`
+			SSHURL   string `json:"ssh_url"`
+		} `json:"repository"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		http.Error(w, "Error decoding request body", http.StatusBadRequest)
+		return
+	}
+
+	handlePush(w, r, pushEvent{
+		URLs:     []string{payload.Repository.CloneURL, payload.Repository.HTMLURL, payload.Repository.SSHURL},
+		Branches: []string{payload.Ref},
+	})
+}
+
+func gitlabWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	body, ok := readWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	token := config.Webhooks.GitLabToken
+	if token == "" || !constantTimeEqual(token, r.Header.Get("X-Gitlab-Token")) {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	var payload struct {
+		ObjectKind string `json:"object_kind"`
+		Ref        string `json:"ref"`
+		Project    struct {
+			GitHTTPURL string `json:"git_http_url"`
+			GitSSHURL  string `json:"git_ssh_url"`
+			WebURL     string `json:"web_url"`
+		} `json:"project"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		http.Error(w, "Error decoding request body", http.StatusBadRequest)
+		return
+	}
+	if payload.ObjectKind != "push" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	handlePush(w, r, pushEvent{
+		URLs:     []string{payload.Project.GitHTTPURL, payload.Project.WebURL, payload.Project.GitSSHURL},
+		Branches: []string{payload.Ref},
+	})
+}
+
+func azureDevOpsWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	body, ok := readWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	username, password, _ := r.BasicAuth()
+	expectedUsername := config.Webhooks.AzureDevOpsUsername
+	expectedPassword := config.Webhooks.AzureDevOpsPassword
+	if expectedPassword == "" || !constantTimeEqual(expectedUsername, username) || !constantTimeEqual(expectedPassword, password) {
+		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		return
+	}
+
+	var payload struct {
+		EventType string `json:"eventType"`
+		Resource  struct {
+			RefUpdates []struct {
+				Name string `json:"name"`
+			} `json:"refUpdates"`
+			Repository struct {
+				RemoteURL string `json:"remoteUrl"`
+				SSHURL    string `json:"sshUrl"`
+			} `json:"repository"`
+		} `json:"resource"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		http.Error(w, "Error decoding request body", http.StatusBadRequest)
+		return
+	}
+	if payload.EventType != "git.push" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	event := pushEvent{URLs: []string{payload.Resource.Repository.RemoteURL, payload.Resource.Repository.SSHURL}}
+	for _, update := range payload.Resource.RefUpdates {
+		event.Branches = append(event.Branches, update.Name)
+	}
+	handlePush(w, r, event)
+}
+
+// maxWebhookBodySize caps the payloads read before their signature is
+// checked. Push payloads carry at most a few commits, so they stay far
+// below it.
+const maxWebhookBodySize = 5 << 20
+
+func readWebhook(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBodySize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		return nil, false
+	}
+	return body, true
+}
+
+// handlePush queues an incremental job for the pushed repository. Pushes
+// to branches that are not indexed are ignored.
+func handlePush(w http.ResponseWriter, r *http.Request, event pushEvent) {
+	var branches []string
+	for _, ref := range event.Branches {
+		branch := strings.TrimPrefix(ref, "refs/heads/")
+		if branch != ref && isIndexedBranch(branch) {
+			branches = append(branches, branch)
+		}
+	}
+	if len(branches) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	ctx := context.Background()
+	repo, err := findRepositoryByURL(ctx, event.URLs)
+	if err != nil {
+		http.Error(w, "Error fetching repositories", http.StatusInternalServerError)
+		logger.Errorw("Error fetching repositories", "error", err)
+		return
+	}
+	if repo == nil {
+		http.Error(w, "Repository not found", http.StatusNotFound)
+		return
+	}
+
+	correlationID := newCorrelationID()
+	log := logger.With(correlationIDProperty, correlationID, "repo_id", repo.ID)
+	err = enqueuePush(ctx, repo.ID, correlationID, branches)
+	if err != nil {
+		http.Error(w, "Error queueing indexing job", http.StatusInternalServerError)
+		log.Errorw("Error queueing indexing job", "error", err)
+		return
+	}
+	log.Infow("Received push", "branches", branches)
+
+	w.Header().Set(correlationIDHeader, correlationID)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// enqueuePush records the pushed branches and schedules an incremental job
+// after the debounce period. Pushes arriving before the job is picked up
+// only add their branches to it, so a burst of pushes results in one job
+// carrying the correlation ID of the first push.
+func enqueuePush(ctx context.Context, repoID, correlationID string, branches []string) error {
+	_, err := repoCol.UpdateOne(ctx,
+		bson.M{"_id": repoID},
+		bson.M{"$addToSet": bson.M{"pending_push.branches": bson.M{"$each": branches}}})
+	if err != nil {
+		return err
+	}
+
+	claim, err := repoCol.UpdateOne(ctx,
+		bson.M{"_id": repoID, "pending_push.scheduled": bson.M{"$ne": true}},
+		bson.M{"$set": bson.M{"pending_push.scheduled": true}})
+	if err != nil {
+		return err
+	}
+	if claim.ModifiedCount == 0 {
+		return nil
+	}
+
+	debounce := time.Duration(config.Webhooks.DebounceSeconds) * time.Second
+	err = scheduleMessageToServiceBus(ctx, repoID, correlationID, map[string]interface{}{"mode": "incremental"}, time.Now().Add(debounce))
+	if err != nil {
+		// Release the claim so the next push schedules the job again.
+		repoCol.UpdateOne(ctx, bson.M{"_id": repoID}, bson.M{"$unset": bson.M{"pending_push.scheduled": ""}})
+		return err
+	}
+	return nil
+}
+
+// findRepositoryByURL returns the registered repository matching any of the
+// URLs of a push, or nil if none does.
+func findRepositoryByURL(ctx context.Context, urls []string) (*Repository, error) {
+	wanted := map[string]bool{}
+	for _, u := range urls {
+		if normalized := normalizeRepositoryURL(u); normalized != "" {
+			wanted[normalized] = true
+		}
+	}
+
+	cursor, err := repoCol.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"_id": 1, "url": 1, "name": 1}))
+	if err != nil {
+		return nil, err
+	}
+
+	var repositories []Repository
+	if err := cursor.All(ctx, &repositories); err != nil {
+		return nil, err
+	}
+
+	for _, repo := range repositories {
+		if wanted[normalizeRepositoryURL(repo.URL)] {
+			return &repo, nil
+		}
+	}
+	return nil, nil
+}
+
+// normalizeRepositoryURL reduces the HTTPS, SSH and web URLs of a
+// repository to the same "host/path" form.
+func normalizeRepositoryURL(rawURL string) string {
+	rawURL = strings.TrimSpace(rawURL)
+	if rawURL == "" {
+		return ""
+	}
+
+	// scp-like SSH syntax, such as git@github.com:org/repo.git.
+	if !strings.Contains(rawURL, "://") {
+		if at := strings.Index(rawURL, "@"); at >= 0 {
+			rawURL = rawURL[at+1:]
+		}
+		rawURL = "ssh://" + strings.Replace(rawURL, ":", "/", 1)
+	}
+
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+
+	host := strings.ToLower(parsed.Hostname())
+	path := strings.Trim(parsed.Path, "/")
+	path = strings.TrimSuffix(path, ".git")
+
+	// Azure DevOps serves SSH from ssh.dev.azure.com/v3/org/project/repo.
+	if host == "ssh.dev.azure.com" || host == "vs-ssh.visualstudio.com" {
+		parts := strings.Split(strings.TrimPrefix(path, "v3/"), "/")
+		if len(parts) == 3 {
+			host = "dev.azure.com"
+			path = parts[0] + "/" + parts[1] + "/_git/" + parts[2]
+		}
+	}
+
+	return host + "/" + strings.ToLower(path)
+}
+
+func isIndexedBranch(branch string) bool {
+	return branch == "main" || branch == "master"
+}
+
+func validGitHubSignature(secret string, body []byte, signature string) bool {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+func constantTimeEqual(expected, actual string) bool {
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(actual)) == 1
+}
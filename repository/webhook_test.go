@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeRepositoryURL(t *testing.T) {
+	expected := "github.com/microsoftdocs/azure-docs"
+	for _, u := range []string{
+		"https://github.com/MicrosoftDocs/azure-docs.git",
+		"https://github.com/MicrosoftDocs/azure-docs",
+		"git@github.com:MicrosoftDocs/azure-docs.git",
+		"ssh://git@github.com/MicrosoftDocs/azure-docs.git",
+	} {
+		assert.Equal(t, expected, normalizeRepositoryURL(u), u)
+	}
+
+	azure := "dev.azure.com/contoso/platform/_git/api"
+	assert.Equal(t, azure, normalizeRepositoryURL("https://contoso@dev.azure.com/contoso/platform/_git/api"))
+	assert.Equal(t, azure, normalizeRepositoryURL("git@ssh.dev.azure.com:v3/contoso/platform/api"))
+	assert.Equal(t, "", normalizeRepositoryURL(""))
+}
+
+func TestGitHubWebhookRejectsInvalidSignature(t *testing.T) {
+	useConfig(t, func(c *Config) { c.Webhooks.GitHubSecret = "secret" })
+	body := []byte(`{"ref": "refs/heads/main"}`)
+
+	req, _ := http.NewRequest("POST", "/api/webhook/github", bytes.NewBuffer(body))
+	req.Header.Set("X-GitHub-Event", "push")
+	req.Header.Set("X-Hub-Signature-256", "sha256=0000")
+	rr := httptest.NewRecorder()
+	githubWebhookHandler(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(body)
+	assert.True(t, validGitHubSignature("secret", body, "sha256="+hex.EncodeToString(mac.Sum(nil))))
+}
+
+func TestWebhookRejectsLargeBodies(t *testing.T) {
+	useConfig(t, func(c *Config) { c.Webhooks.GitHubSecret = "secret" })
+	body := bytes.Repeat([]byte(" "), maxWebhookBodySize+1)
+
+	req, _ := http.NewRequest("POST", "/api/webhook/github", bytes.NewBuffer(body))
+	req.Header.Set("X-GitHub-Event", "push")
+	rr := httptest.NewRecorder()
+	githubWebhookHandler(rr, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+}
+
+func TestWebhookIgnoresOtherBranches(t *testing.T) {
+	useConfig(t, func(c *Config) { c.Webhooks.GitLabToken = "token" })
+	body := []byte(`{"object_kind": "push", "ref": "refs/heads/feature", "project": {"git_http_url": "https://gitlab.com/example/repo.git"}}`)
+
+	req, _ := http.NewRequest("POST", "/api/webhook/gitlab", bytes.NewBuffer(body))
+	req.Header.Set("X-Gitlab-Token", "token")
+	rr := httptest.NewRecorder()
+	gitlabWebhookHandler(rr, req)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+}